@@ -0,0 +1,4 @@
+// Package adminpb holds the generated code for the gRPC control-plane API.
+package adminpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative admin.proto
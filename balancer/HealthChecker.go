@@ -0,0 +1,197 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// healthCheckInterval is how often every server is probed.
+const healthCheckInterval = 2 * time.Second
+
+// HealthCheckConfig probes a pool's servers somewhere other than their
+// traffic URL, such as a management port or a sidecar. A server's own
+// health_url wins over it.
+type HealthCheckConfig struct {
+	// Type is how servers are probed: "http" (the default), "tcp",
+	// "grpc" or a name registered with RegisterHealthChecker.
+	Type string `json:"type"`
+	// Service is the service a grpc check asks about; empty asks about
+	// the server as a whole.
+	Service string `json:"service,omitempty"`
+	// Port replaces the port of the server URL.
+	Port int `json:"port"`
+	// Path replaces the path of the server URL, e.g. "/status".
+	Path string `json:"path"`
+}
+
+func (c *HealthCheckConfig) validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("health_check: invalid port %d", c.Port)
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("health_check: path %q must start with /", c.Path)
+	}
+	if c.Service != "" && c.Type != "grpc" {
+		return fmt.Errorf("health_check: service only applies to type grpc")
+	}
+	_, err := c.checker()
+	return err
+}
+
+// healthURL is where s is probed: health_url if set, else the server URL
+// with the pool's health_check port and path, else the server URL.
+func healthURL(sc ServerConfig, c *HealthCheckConfig) string {
+	if sc.HealthURL != "" {
+		return sc.HealthURL
+	}
+	u, err := url.Parse(sc.URL)
+	if c == nil || err != nil {
+		return sc.URL
+	}
+	if c.Port > 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(c.Port))
+	}
+	if c.Path != "" {
+		u.Path, u.RawPath = c.Path, ""
+	}
+	return u.String()
+}
+
+func validateHealthURL(sc ServerConfig) error {
+	if sc.HealthURL == "" {
+		return nil
+	}
+	u, err := url.Parse(sc.HealthURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("server %q: health_url %q is not an absolute URL", sc.Name, sc.HealthURL)
+	}
+	return checkURLHost(sc.Name, u)
+}
+
+func (b *Balancer) startHealthCheck(beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(healthCheckInterval).Do(func() {
+		defer recoverJob("health_check")
+		b.checkServers()
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
+
+// Health check rounds probe up to this many servers at once, and stop
+// starting probes after the deadline, unless the config says otherwise.
+const (
+	defaultHealthCheckConcurrency = 10
+	defaultHealthCheckDeadline    = 10 * time.Second
+)
+
+var healthChecksSkipped = newCounterVec("lb_health_checks_skipped_total",
+	"Health probes not started because their round ran past its deadline.", "pool")
+
+func (b *Balancer) healthCheckConcurrency() int {
+	if n := b.cfg.HealthCheckConcurrency; n > 0 {
+		return n
+	}
+	return defaultHealthCheckConcurrency
+}
+
+func (b *Balancer) healthCheckDeadline() time.Duration {
+	if d := b.cfg.HealthCheckDeadline; d > 0 {
+		return time.Duration(d)
+	}
+	return defaultHealthCheckDeadline
+}
+
+// healthCheckStall is how long a health check round may take before the
+// watchdog restarts the checker: no probe starts after the deadline, and
+// each takes up to 2s.
+func (b *Balancer) healthCheckStall() time.Duration {
+	return 30*time.Second + b.healthCheckDeadline() + 2*time.Second
+}
+
+// checkServers runs one health check round over every known server,
+// probing several at a time. Servers whose probe hasn't started by the
+// round's deadline keep their state until the next round.
+func (b *Balancer) checkServers() {
+	servers := b.pools.Servers()
+	deadline := time.Now().Add(b.healthCheckDeadline())
+	queue := make(chan *Server, len(servers))
+	for _, s := range servers {
+		queue <- s
+	}
+	close(queue)
+	var wg sync.WaitGroup
+	var skipped atomic.Int64
+	// A panicking probe fails the whole round, as it did when the round
+	// ran in one goroutine, so its job's recoverJob still sees it.
+	var failed atomic.Pointer[any]
+	for range min(b.healthCheckConcurrency(), len(servers)) {
+		wg.Go(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					failed.CompareAndSwap(nil, &r)
+				}
+			}()
+			for s := range queue {
+				if time.Now().After(deadline) {
+					healthChecksSkipped.Inc(s.pool.name)
+					skipped.Add(1)
+					continue
+				}
+				b.checkServer(s)
+			}
+		})
+	}
+	wg.Wait()
+	if r := failed.Load(); r != nil {
+		panic(*r)
+	}
+	if n := skipped.Load(); n > 0 {
+		slog.Warn("⚠️ Health check round ran past its deadline, some servers weren't probed", "skipped", n, "servers", len(servers))
+	}
+	b.alerts.evaluate(servers)
+	statsd.reportHealth(b.pools.Pools(), servers)
+}
+
+// checkServer probes one server and adds it to or removes it from its
+// pool accordingly.
+func (b *Balancer) checkServer(server *Server) {
+	last, _, _ := server.checkFreshness()
+	alive, reason := server.Probe() // Real ping check
+	if server.RecordHealth(alive, reason) {
+		// A server that started unhealthy passing its first probe isn't
+		// news.
+		if !server.InMaintenance() && !(alive && last.IsZero()) {
+			b.alerts.healthChanged(server, alive)
+		}
+		server.pool.PublishHealth(server, alive)
+	}
+
+	if server.isDisabledByOperator() {
+		// Operator took it out; keep probing but don't re-add.
+		return
+	}
+	// Index belongs to the pool lock; requests move servers around the
+	// heap while we look. Standby servers outside the subset count as
+	// in the pool. Draining and maintenance servers come and go with
+	// their health too, and are passed over while in.
+	inPool := server.pool.Joined(server)
+	if alive && !inPool {
+		if b.pools.rejoin(server) {
+			slog.Info("✅ Server recovered, adding it to the pool", "server", server.Name)
+		}
+	} else if !alive && inPool {
+		slog.Warn("❌ Server failed its health check, removing it from the pool", "server", server.Name, "reason", reason)
+		server.pool.RemoveServer(server)
+	}
+}
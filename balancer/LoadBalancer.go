@@ -0,0 +1,374 @@
+package balancer
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The command line flags. Main registers them, so importing the package
+// doesn't add any to an application's own flag set.
+var (
+	// configPath is read at startup and again on SIGHUP.
+	configPath    = new(string)
+	pidFile       = new(string)
+	daemon        = new(bool)
+	service       = new(string)
+	workers       = new(int)
+	logLevelFlag  = new(string)
+	logFormatFlag = new(string)
+)
+
+func registerFlags() {
+	flag.StringVar(configPath, "config", "config.json", "path to the config file")
+	flag.StringVar(pidFile, "pidfile", "", "write the process ID to this file")
+	flag.BoolVar(daemon, "daemon", false, "detach from the terminal and run in the background (Unix)")
+	flag.StringVar(service, "service", "", `"install" or "uninstall" the Windows service`)
+	flag.IntVar(workers, "workers", 0, "run this many worker processes sharing the listener (Linux)")
+	flag.StringVar(logLevelFlag, "log-level", "", `log level: "debug", "info", "warn" or "error" (overrides the config)`)
+	flag.StringVar(logFormatFlag, "log-format", "", `log format: "text" or "json" (overrides the config)`)
+}
+
+// Main is the loadbalancer command: it parses the command line, loads the
+// config file and serves on the listeners it names until the process
+// exits. "loadbalancer simulate" runs a request stream through the config
+// instead; see simulate.go. To run the balancer inside another program,
+// see NewHandler.
+func Main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(simulateCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	registerFlags()
+	flag.Parse()
+	if runAsService() {
+		return
+	}
+	if *workers > 0 && workerID() == 0 {
+		if *pidFile != "" {
+			if err := writePIDFile(*pidFile); err != nil {
+				fatal("Error writing pid file", "err", err)
+			}
+		}
+		superviseWorkers(*workers)
+		return
+	}
+	run()
+}
+
+// run starts the balancer and serves until the process exits.
+func run() {
+	if workerID() == 0 && *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			fatal("Error writing pid file", "err", err)
+		}
+		removeOnExit(*pidFile)
+	}
+
+	// 1. Load Configuration
+	c, err := LoadConfig(*configPath)
+	if err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+	if err := setupLogging(c.Log, *logLevelFlag, *logFormatFlag); err != nil {
+		fatal("Error setting up logging", "err", err)
+	}
+	if c.LogFile != "" {
+		if err := openLog(c.LogFile); err != nil {
+			fatal("Error opening log file", "err", err)
+		}
+	}
+	b, err := newBalancer(*c)
+	if err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+	cfg := &b.cfg
+	b.handleSignals(*configPath)
+	slog.Info("Loaded servers from config", "servers", len(b.pools.Servers()))
+
+	// 2. Register Routes
+	plugins, err := loadPlugins(cfg.Plugins)
+	if err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+	proxy, err := b.proxyHandler(plugins)
+	if err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+	http.Handle("/", proxy)
+
+	auth, err := newAdminAuth(cfg.AdminTokens)
+	if err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+	if cfg.AdminListen != "" {
+		b.startAdminListener(cfg.AdminListen, auth)
+		b.registerPublicRoutes(http.DefaultServeMux)
+	} else {
+		b.registerRoutes(http.DefaultServeMux, auth)
+	}
+	if cfg.GRPCAdmin != nil {
+		b.startGRPCAdmin(*cfg.GRPCAdmin, auth)
+	}
+
+	if err := applyProcessSettings(cfg); err != nil {
+		fatal("Error loading configuration", "err", err)
+	}
+
+	// 3. Start Health Check (Background)
+	b.start(plugins)
+	if sc := cfg.Startup; sc != nil {
+		if sc.HoldListener {
+			b.awaitHealthy(*sc)
+		} else {
+			go b.awaitHealthy(*sc)
+		}
+	}
+	startFileWatch(cfg)
+
+	ln, err := systemdListener()
+	if err != nil {
+		fatal("Error listening", "err", err)
+	}
+	switch {
+	case ln != nil:
+		slog.Info("Using the socket passed by systemd", "addr", ln.Addr().String())
+	case workerID() > 0:
+		if ln, err = listenReusePort(cfg.Listen); err != nil {
+			fatal("Error listening", "addr", cfg.Listen, "err", err)
+		}
+	default:
+		if ln, err = net.Listen("tcp", cfg.Listen); err != nil {
+			fatal("Error listening", "addr", cfg.Listen, "err", err)
+		}
+	}
+	if cfg.ProxyProtocol != nil {
+		ln, err = newProxyProtoListener(ln, *cfg.ProxyProtocol)
+		if err != nil {
+			fatal("Error loading configuration", "err", err)
+		}
+		slog.Info("Accepting PROXY protocol headers", "addr", cfg.Listen)
+	}
+
+	srv := &http.Server{
+		Addr:           cfg.Listen,
+		Handler:        recoverPanics(cfg.Hardening.Wrap(http.DefaultServeMux)),
+		MaxHeaderBytes: cfg.Hardening.maxHeaderBytes(),
+	}
+	if cfg.TLS != nil {
+		ft, err := cfg.TLS.newFrontendTLS()
+		if err != nil {
+			fatal("Error loading TLS configuration", "err", err)
+		}
+		srv.TLSConfig = ft.config
+		if ft.challenges != nil && cfg.TLS.ACME.HTTPListen != "off" {
+			addr := cfg.TLS.ACME.httpListen()
+			slog.Info("🔐 Serving ACME HTTP-01 challenges", "addr", addr)
+			go func() { fatal("ACME challenge server stopped", "err", http.ListenAndServe(addr, ft.challenges)) }()
+		}
+		slog.Info("🚀 Weighted DSA Load Balancer starting", "addr", cfg.Listen, "tls", true)
+		notifyReady(cfg.Listen)
+		fatal("Server stopped", "err", srv.ServeTLS(ln, "", ""))
+	}
+
+	slog.Info("🚀 Weighted DSA Load Balancer starting", "addr", cfg.Listen)
+	notifyReady(cfg.Listen)
+	fatal("Server stopped", "err", srv.Serve(ln))
+}
+
+// applyProcessSettings applies the parts of cfg that are process-wide
+// rather than per balancer: the StatsD client, the health history size
+// and the request sample rate.
+func applyProcessSettings(cfg *Config) error {
+	if cfg.StatsD != nil {
+		client, err := newStatsDClient(*cfg.StatsD)
+		if err != nil {
+			return err
+		}
+		statsd = client
+	}
+	if cfg.HealthHistorySize > 0 {
+		healthHistorySize = cfg.HealthHistorySize
+	}
+	if cfg.RequestSampleRate > 0 {
+		requestSampleRate = cfg.RequestSampleRate
+	}
+	return nil
+}
+
+var fileWatchOnce sync.Once
+
+// startFileWatch reloads TLS certificates and CAs (listener and backend)
+// when they are rotated. The watch covers every reloader in the process,
+// so it's started once, at the first balancer's interval.
+func startFileWatch(cfg *Config) {
+	watchInterval := 10 * time.Second
+	if cfg.TLS != nil && cfg.TLS.WatchInterval > 0 {
+		watchInterval = time.Duration(cfg.TLS.WatchInterval)
+	}
+	fileWatchOnce.Do(func() { go watchFiles(watchInterval) })
+}
+
+// poolFor returns the pool rc sends its requests to, or the default pool
+// if rc is nil or names none.
+func (b *Balancer) poolFor(rc *RouteConfig) *ServerPool {
+	if rc != nil && rc.Pool != "" {
+		if p := b.pools.Get(rc.Pool); p != nil {
+			return p
+		}
+		// Deleted through the admin API. Answer as for an empty pool
+		// rather than sending the route's traffic somewhere else.
+		return &ServerPool{name: rc.Pool}
+	}
+	return b.pools.Default()
+}
+
+// selectServer picks the backend for r from p and takes a concurrency
+// slot on it: what an operator's override headers ask for, else the
+// routing script's choice if it made one, else the client's sticky pin,
+// else the pool's best, which for consistent_hash is the client key's
+// server.
+func (b *Balancer) selectServer(r *http.Request, p *ServerPool) (s *Server, overloaded bool) {
+	// Working out the key allocates; skip it when nothing uses it.
+	var key string
+	hashed := usesHash(p)
+	o := overrideOf(r)
+	if b.routing != nil || b.sticky != nil || hashed || o != nil {
+		key = b.clientKeys.key(r)
+	}
+	if o != nil {
+		// Pins neither way: the override is for this request only.
+		if s, overloaded = b.acquireOverride(p, o, poolKey(p, key)); s != nil {
+			b.logPick(r, s, "override")
+		}
+		return s, overloaded
+	}
+	if d := b.routing.decide(r, key); d != nil {
+		for k, v := range d.headers {
+			r.Header.Set(k, v)
+		}
+		if s, ok := d.acquire(b.pools, p); ok {
+			b.logPick(r, s, "routing_script")
+			return s, false
+		}
+	}
+	key = poolKey(p, key)
+	if s = b.sticky.acquire(key); s != nil {
+		b.logPick(r, s, "sticky")
+		return s, false
+	}
+	switch {
+	case hashed:
+		s, overloaded = b.acquireHashed(p, key)
+	case usesBandit(p):
+		s, overloaded = b.acquireBandit(p)
+	default:
+		s, overloaded = b.acquireServer(p)
+	}
+	if s != nil {
+		b.sticky.pin(key, s)
+		b.logPick(r, s, p.Strategy().Name())
+	}
+	return s, overloaded
+}
+
+// poolKey is the client key as p's sticky pins and hashing see it. A
+// client can be pinned in several pools at once.
+func poolKey(p *ServerPool, key string) string {
+	if p.name == defaultPoolName {
+		return key
+	}
+	return p.name + "/" + key
+}
+
+// logPick logs at debug level which backend r goes to and why: the
+// routing script, a sticky pin or the pool's strategy. The decision log
+// samples the same picks.
+func (b *Balancer) logPick(r *http.Request, s *Server, via string) {
+	b.decisions.record(s, via)
+	if !debugLogging() {
+		return
+	}
+	p := s.pool
+	slog.Debug("🎯 Picked backend", "client", clientIP(r), "method", r.Method, "path", r.URL.Path,
+		"pool", p.name, "via", via, "server", s.Name, "load", p.Load(s), "active", p.Active(s), "weight", p.Weight(s))
+}
+
+func (b *Balancer) ForwardRequest(res http.ResponseWriter, rep *http.Request) {
+	requestWindow.Inc()
+	if budgetSpent(res, rep) {
+		return
+	}
+	rc := matchRoute(b.cfg.Routes, rep)
+	p := b.poolFor(rc)
+	target, overloaded := b.selectServer(rep, p)
+	if target == nil && !overloaded && p.config.FailOpen {
+		target = b.failOpen(res, p)
+	}
+
+	if target == nil {
+		perr := &ProxyError{Kind: ErrNoHealthyBackend, Err: fmt.Errorf("pool %s is empty", p.name)}
+		// A backend can come back at the next health check round.
+		retry := int(healthCheckInterval / time.Second)
+		if overloaded {
+			perr = &ProxyError{Kind: ErrOverloaded, Err: fmt.Errorf("every backend of pool %s is at its concurrency limit", p.name)}
+			retry = b.pools.retryAfter(1, int64(b.pools.concurrencyLimit(p)))
+		}
+		countError(perr)
+		perr.log()
+		writeBackpressure(res, http.StatusServiceUnavailable, retry, 0)
+		return
+	}
+
+	cost := rc.cost()
+	target.pool.AddActive(target, cost)
+
+	start := time.Now()
+	if b.cfg.DebugHeaders {
+		res = &debugHeaderWriter{ResponseWriter: res, server: target.Name, start: start}
+	}
+	sw := getStatusWriter(res)
+	defer putStatusWriter(sw)
+	if rc != nil && rc.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(rep.Context(), time.Duration(rc.Timeout))
+		defer cancel()
+		rep = rep.WithContext(ctx)
+	}
+	propagateBudget(rep)
+	proxy := target.ReverseProxy
+	if rc != nil && rc.flushInterval() != 0 {
+		// The proxy is shared by every route; change a copy.
+		flushing := *proxy
+		flushing.FlushInterval = rc.flushInterval()
+		proxy = &flushing
+	}
+	// Deferred, so a panic in the proxy, which recoverPanics turns into a
+	// 502, doesn't leave the request counted against target.
+	defer func() {
+		target.pool.AddActive(target, -cost)
+		elapsed := time.Since(start)
+		// Nothing written means the proxy panicked.
+		failed := sw.status >= 500 || sw.status == 0
+		target.limiter.Release(elapsed, failed)
+		target.arm.observe(target.pool.config.Bandit, elapsed, failed)
+		target.pool.ObserveLatency(target, elapsed)
+		statsd.Count("requests", 1, target.statsdTag)
+		statsd.Timing("request_duration", elapsed, target.statsdTag)
+		target.tail.record(rep, sw.status, elapsed)
+	}()
+	proxy.ServeHTTP(sw, rep)
+}
+
+// LoadConfig reads and validates the config file at file; see ParseConfig.
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
@@ -0,0 +1,212 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrencyConfig lets each backend find its own concurrency
+// limit from latency and errors, in the style of Netflix's
+// concurrency-limits, instead of a fixed cap.
+type AdaptiveConcurrencyConfig struct {
+	// Algorithm is "aimd" (default) or "gradient".
+	Algorithm    string `json:"algorithm"`
+	InitialLimit int    `json:"initial_limit"` // default 20
+	MinLimit     int    `json:"min_limit"`     // default 1
+	MaxLimit     int    `json:"max_limit"`     // default 1000
+	// LatencyThreshold (aimd): slower responses count as a drop.
+	// Defaults to 1s.
+	LatencyThreshold Duration `json:"latency_threshold"`
+	// Backoff (aimd) multiplies the limit on a drop. Defaults to 0.9.
+	Backoff float64 `json:"backoff"`
+}
+
+func (c *AdaptiveConcurrencyConfig) validate() error {
+	switch c.Algorithm {
+	case "", "aimd", "gradient":
+	default:
+		return fmt.Errorf("adaptive_concurrency: unknown algorithm %q", c.Algorithm)
+	}
+	if c.Backoff < 0 || c.Backoff >= 1 {
+		return fmt.Errorf("adaptive_concurrency: backoff must be in (0, 1)")
+	}
+	if c.MaxLimit > 0 && c.MinLimit > c.MaxLimit {
+		return fmt.Errorf("adaptive_concurrency: min_limit above max_limit")
+	}
+	return nil
+}
+
+// adaptiveLimiter caps a backend's in-flight requests at a limit that
+// grows while it answers well and shrinks when it slows down or fails.
+// A nil limiter never limits.
+type adaptiveLimiter struct {
+	cfg      AdaptiveConcurrencyConfig
+	gradient bool
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	// gradient state: RTT EWMAs in milliseconds
+	longRTT, shortRTT float64
+}
+
+func newAdaptiveLimiter(c AdaptiveConcurrencyConfig) *adaptiveLimiter {
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 1
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	if c.LatencyThreshold <= 0 {
+		c.LatencyThreshold = Duration(time.Second)
+	}
+	if c.Backoff == 0 {
+		c.Backoff = 0.9
+	}
+	return &adaptiveLimiter{cfg: c, gradient: c.Algorithm == "gradient", limit: float64(c.InitialLimit)}
+}
+
+// Acquire takes a slot if the backend is under its limit.
+func (l *adaptiveLimiter) Acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(l.inflight) >= math.Floor(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+// HasRoom reports whether Acquire would currently succeed.
+func (l *adaptiveLimiter) HasRoom() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return float64(l.inflight) < math.Floor(l.limit)
+}
+
+// Release returns a slot and feeds the outcome into the limit.
+func (l *adaptiveLimiter) Release(rtt time.Duration, failed bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	if l.gradient {
+		l.updateGradient(rtt, failed)
+	} else {
+		l.updateAIMD(rtt, failed)
+	}
+	l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), l.limit))
+}
+
+func (l *adaptiveLimiter) updateAIMD(rtt time.Duration, failed bool) {
+	if failed || rtt > time.Duration(l.cfg.LatencyThreshold) {
+		l.limit *= l.cfg.Backoff
+		return
+	}
+	// Only grow when the limit is actually being used, or an idle backend
+	// would drift up to max_limit.
+	if float64(l.inflight+1) >= l.limit/2 {
+		l.limit += 1 / l.limit
+	}
+}
+
+// updateGradient follows Gradient2: compare a slow-moving RTT baseline
+// with recent RTTs; when recent ones grow, the gradient drops below 1 and
+// pulls the limit down.
+func (l *adaptiveLimiter) updateGradient(rtt time.Duration, failed bool) {
+	ms := float64(rtt) / float64(time.Millisecond)
+	if failed {
+		// Treat an error like a very slow answer.
+		ms = math.Max(ms, 2*l.longRTT)
+	}
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = ms, ms
+		return
+	}
+	l.shortRTT = 0.1*ms + 0.9*l.shortRTT
+	l.longRTT = 0.01*ms + 0.99*l.longRTT
+	if l.longRTT > l.shortRTT*2 {
+		// Recovering from a period of high latency: let the baseline
+		// catch up quickly.
+		l.longRTT = 0.95 * l.longRTT
+	}
+	gradient := math.Max(0.5, math.Min(1.0, l.longRTT/math.Max(l.shortRTT, 1e-9)))
+	queue := math.Sqrt(l.limit)
+	next := l.limit*gradient + queue
+	l.limit = 0.8*l.limit + 0.2*next
+}
+
+// Limit returns the current limit, or 0 for a nil limiter.
+func (l *adaptiveLimiter) Limit() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+var backendLimits = newFuncMetric("lb_backend_concurrency_limit",
+	"Current adaptive concurrency limit per backend.", "gauge", func(b *Balancer) []metricSample {
+		var out []metricSample
+		for _, s := range b.pools.Servers() {
+			if s.limiter != nil {
+				out = append(out, metricSample{[]string{"backend"}, []string{s.Name}, float64(s.limiter.Limit())})
+			}
+		}
+		return out
+	})
+
+// acquireServer picks a server and takes one of its adaptive concurrency
+// slots. If the pick is at its limit, the least loaded server with room is
+// tried instead. overloaded is set when servers exist but all are full.
+func (b *Balancer) acquireServer(p *ServerPool) (s *Server, overloaded bool) {
+	return acquireFrom(func(match func(*Server) bool) *Server {
+		if match == nil {
+			return b.pickServer(p)
+		}
+		return p.GetNextServerWhere(match)
+	})
+}
+
+// acquireFrom takes a concurrency slot on pick(nil) or, while the servers
+// it returns are at their limit, on what pick(hasRoom) returns next,
+// trying up to three servers. Every way of selecting a server shares it.
+// overloaded is set when servers exist but all are full.
+func acquireFrom(pick func(match func(*Server) bool) *Server) (s *Server, overloaded bool) {
+	s = pick(nil)
+	if s == nil {
+		return nil, false
+	}
+	for tries := 0; s != nil && tries < 3; tries++ {
+		if s.limiter.Acquire() {
+			return s, false
+		}
+		s = pick(hasRoom)
+	}
+	return nil, true
+}
+
+func hasRoom(s *Server) bool { return s.limiter.HasRoom() }
+
+// concurrencyLimit sums the adaptive limits of p's servers.
+func (m *PoolManager) concurrencyLimit(p *ServerPool) int {
+	n := 0
+	for _, s := range m.Members(p) {
+		n += s.limiter.Limit()
+	}
+	return n
+}
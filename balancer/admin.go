@@ -0,0 +1,494 @@
+package balancer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdminToken grants a role to whoever presents Token, either as
+// "Authorization: Bearer <token>" or, for reads only, as ?token= (for the
+// dashboard page).
+type AdminToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+
+	// tenant limits the token to that tenant's namespace.
+	tenant string
+}
+
+type role int
+
+// Roles are ordered: each one can do everything the previous one can.
+const (
+	roleViewer   role = iota + 1 // read /stats and the dashboard
+	roleOperator                 // disable/enable servers
+	roleAdmin                    // add/remove servers, change strategy
+)
+
+var roleNames = map[string]role{
+	"viewer":   roleViewer,
+	"operator": roleOperator,
+	"admin":    roleAdmin,
+}
+
+type adminAuth struct {
+	tokens []AdminToken
+	// csrf refuses writes a browser sends from another site, such as a
+	// form on a page the operator happens to visit.
+	csrf *http.CrossOriginProtection
+}
+
+func newAdminAuth(tokens []AdminToken) (*adminAuth, error) {
+	seen := map[string]bool{}
+	for i, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("admin_tokens[%d]: empty token", i)
+		}
+		if _, ok := roleNames[t.Role]; !ok {
+			return nil, fmt.Errorf("admin_tokens[%d]: unknown role %q", i, t.Role)
+		}
+		if seen[t.Token] {
+			return nil, fmt.Errorf("admin_tokens[%d]: token used twice", i)
+		}
+		seen[t.Token] = true
+	}
+	return &adminAuth{tokens: tokens, csrf: http.NewCrossOriginProtection()}, nil
+}
+
+// roleOf takes the token from the Authorization header, or for reads
+// from ?token=, which is how the dashboard is opened. A token in a URL
+// can end up in history, logs and Referer headers, so it doesn't allow
+// writes.
+func (a *adminAuth) roleOf(r *http.Request) (role, string, bool) {
+	tok, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found && isSafeMethod(r.Method) {
+		tok = r.URL.Query().Get("token")
+	}
+	return a.roleOfToken(tok)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// roleOfToken returns the token's role and the tenant it is limited to,
+// "" for a top-level token.
+func (a *adminAuth) roleOfToken(tok string) (role, string, bool) {
+	if tok == "" {
+		return 0, "", false
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(tok)) == 1 {
+			return roleNames[t.Role], t.tenant, true
+		}
+	}
+	return 0, "", false
+}
+
+// require only lets requests through whose token has at least role min.
+// Tenant tokens are refused. Without any tokens configured, read-only
+// pages stay open (the old behaviour) and every write is refused.
+func (a *adminAuth) require(min role, h http.HandlerFunc) http.HandlerFunc {
+	return a.check(min, false, h)
+}
+
+// requireScoped is require for handlers that tenant tokens may use too.
+// Their requests carry the tenant (see callerTenant), and h must only
+// show and change what belongs to it.
+func (a *adminAuth) requireScoped(min role, h http.HandlerFunc) http.HandlerFunc {
+	return a.check(min, true, h)
+}
+
+func (a *adminAuth) check(min role, scoped bool, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := a.csrf.Check(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if len(a.tokens) == 0 {
+			if min == roleViewer {
+				h(w, r)
+				return
+			}
+			http.Error(w, "Admin API disabled: no admin_tokens configured", http.StatusForbidden)
+			return
+		}
+		got, tenant, ok := a.roleOf(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if got < min || (tenant != "" && !scoped) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if tenant != "" {
+			r = withTenant(r, tenant)
+		}
+		h(w, r)
+	}
+}
+
+func (b *Balancer) registerAdminRoutes(mux *http.ServeMux, auth *adminAuth) {
+	mux.HandleFunc("POST /admin/servers", auth.requireScoped(roleAdmin, b.adminAddServer))
+	mux.HandleFunc("DELETE /admin/servers/{name}", auth.requireScoped(roleAdmin, b.adminRemoveServer))
+	mux.HandleFunc("POST /admin/servers/{name}/disable", auth.requireScoped(roleOperator, b.adminDisableServer))
+	mux.HandleFunc("POST /admin/servers/{name}/enable", auth.requireScoped(roleOperator, b.adminEnableServer))
+	mux.HandleFunc("POST /admin/servers/{name}/drain", auth.requireScoped(roleOperator, b.adminDrainServer))
+	mux.HandleFunc("GET /admin/strategy", auth.requireScoped(roleViewer, b.adminGetStrategy))
+	mux.HandleFunc("PUT /admin/strategy", auth.requireScoped(roleAdmin, b.adminSetStrategy))
+	mux.HandleFunc("GET /admin/pools", auth.requireScoped(roleViewer, b.adminListPools))
+	mux.HandleFunc("POST /admin/pools", auth.requireScoped(roleAdmin, b.adminCreatePool))
+	mux.HandleFunc("DELETE /admin/pools/{pool}", auth.requireScoped(roleAdmin, b.adminDeletePool))
+	mux.HandleFunc("POST /admin/pools/{pool}/drain", auth.requireScoped(roleOperator, b.adminDrainPool))
+	mux.HandleFunc("POST /admin/pools/{pool}/enable", auth.requireScoped(roleOperator, b.adminEnablePool))
+	mux.HandleFunc("GET /admin/tenants/{tenant}/rate_limit", auth.requireScoped(roleViewer, b.adminGetRateLimit))
+	mux.HandleFunc("PUT /admin/tenants/{tenant}/rate_limit", auth.require(roleAdmin, b.adminSetRateLimit))
+	mux.HandleFunc("DELETE /admin/tenants/{tenant}/rate_limit", auth.require(roleAdmin, b.adminSetRateLimit))
+	mux.HandleFunc("GET /admin/loglevel", auth.require(roleViewer, adminGetLogLevel))
+	mux.HandleFunc("PUT /admin/loglevel", auth.require(roleOperator, adminSetLogLevel))
+}
+
+// adminPool resolves ?pool= (default: the default pool), answering 404
+// itself when there's no such pool the caller may see.
+func (b *Balancer) adminPool(w http.ResponseWriter, r *http.Request) *ServerPool {
+	return b.adminPoolNamed(w, r, r.URL.Query().Get("pool"))
+}
+
+// adminPoolNamed is adminPool for a pool named in the path or body. Tenant
+// callers may leave out their prefix, and have no default pool.
+func (b *Balancer) adminPoolNamed(w http.ResponseWriter, r *http.Request, name string) *ServerPool {
+	tenant := callerTenant(r)
+	if tenant != "" && name == "" {
+		http.Error(w, "Pool not found", http.StatusNotFound)
+		return nil
+	}
+	p := b.pools.Get(qualifyName(tenant, name))
+	if p == nil || !p.visibleTo(tenant) {
+		http.Error(w, "Pool not found", http.StatusNotFound)
+		return nil
+	}
+	return p
+}
+
+// adminServer finds the server named in the path, answering 404 itself
+// when there's no such server the caller may see.
+func (b *Balancer) adminServer(w http.ResponseWriter, r *http.Request) *Server {
+	tenant := callerTenant(r)
+	s := b.pools.Find(qualifyName(tenant, r.PathValue("name")))
+	if s == nil || !s.pool.visibleTo(tenant) {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return nil
+	}
+	return s
+}
+
+type strategyBody struct {
+	Strategy string `json:"strategy"`
+}
+
+func (b *Balancer) adminGetStrategy(w http.ResponseWriter, r *http.Request) {
+	p := b.adminPool(w, r)
+	if p == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(strategyBody{Strategy: p.Strategy().Name()})
+}
+
+func (b *Balancer) adminSetStrategy(w http.ResponseWriter, r *http.Request) {
+	p := b.adminPool(w, r)
+	if p == nil {
+		return
+	}
+	var body strategyBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	st, err := strategyByName(body.Strategy)
+	if err != nil || body.Strategy == "" {
+		http.Error(w, fmt.Sprintf("Bad Request: unknown strategy %q", body.Strategy), http.StatusBadRequest)
+		return
+	}
+	p.SetStrategy(st)
+	slog.Info("🔀 Pool strategy switched via admin API", "pool", p.name, "strategy", st.Name())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type addServerBody struct {
+	ServerConfig
+	// Pool defaults to the default pool.
+	Pool string `json:"pool"`
+}
+
+func (b *Balancer) adminAddServer(w http.ResponseWriter, r *http.Request) {
+	var c addServerBody
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.Name == "" || c.URL == "" {
+		http.Error(w, "Bad Request: name and url are required", http.StatusBadRequest)
+		return
+	}
+	if err := validateServerURL(c.ServerConfig); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateHealthURL(c.ServerConfig); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant := callerTenant(r)
+	p := b.pools.Get(qualifyName(tenant, c.Pool))
+	if p == nil || !p.visibleTo(tenant) || (tenant != "" && c.Pool == "") {
+		http.Error(w, fmt.Sprintf("Bad Request: unknown pool %q", c.Pool), http.StatusBadRequest)
+		return
+	}
+	c.Name = qualifyName(p.config.tenant, c.Name)
+	if err := b.pools.Register(p.newServer(c.ServerConfig)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Info("➕ Server added via admin API", "server", c.Name, "pool", p.name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (b *Balancer) adminRemoveServer(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	if b.pools.Unregister(s.Name) == nil {
+		http.Error(w, "Server not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("➖ Server removed via admin API", "server", s.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Balancer) adminDisableServer(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	b.pools.Disable(s)
+	slog.Info("⏸️ Server disabled via admin API", "server", s.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminDrainServer stops new requests to the server; in-flight ones
+// finish. Enable brings it back.
+func (b *Balancer) adminDrainServer(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	if !b.pools.Drain(s) {
+		http.Error(w, "Server is disabled; enable it first", http.StatusConflict)
+		return
+	}
+	slog.Info("⏸️ Server draining via admin API", "server", s.Name, "active", s.pool.Active(s))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Balancer) adminEnableServer(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	b.pools.Enable(s)
+	slog.Info("▶️ Server enabled via admin API", "server", s.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type poolInfo struct {
+	Name     string   `json:"name"`
+	Strategy string   `json:"strategy"`
+	Servers  []string `json:"servers"`
+}
+
+func (b *Balancer) adminListPools(w http.ResponseWriter, r *http.Request) {
+	out := []poolInfo{}
+	for _, p := range b.pools.Pools() {
+		if !p.visibleTo(callerTenant(r)) {
+			continue
+		}
+		info := poolInfo{Name: p.name, Strategy: p.Strategy().Name(), Servers: []string{}}
+		for _, s := range b.pools.Members(p) {
+			info.Servers = append(info.Servers, s.Name)
+		}
+		out = append(out, info)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+type createPoolBody struct {
+	Name string `json:"name"`
+	PoolConfig
+}
+
+// adminCreatePool takes the same settings as a pools entry in the config,
+// servers included.
+func (b *Balancer) adminCreatePool(w http.ResponseWriter, r *http.Request) {
+	var body createPoolBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Name == "" || body.Name == defaultPoolName {
+		http.Error(w, "Bad Request: a pool name other than default is required", http.StatusBadRequest)
+		return
+	}
+	if err := body.validate(body.Name); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A pool in a tenant's namespace belongs to the tenant, whoever
+	// creates it.
+	owner := callerTenant(r)
+	if t, _, ok := strings.Cut(body.Name, "."); ok && owner == "" {
+		if _, ok := b.cfg.Tenants[t]; ok {
+			owner = t
+		}
+	}
+	if owner != "" {
+		body.Name, body.tenant = qualifyName(owner, body.Name), owner
+		body.Servers = slices.Clone(body.Servers)
+		for i := range body.Servers {
+			body.Servers[i].Name = qualifyName(owner, body.Servers[i].Name)
+		}
+	}
+	p, err := b.pools.Create(body.Name, body.PoolConfig)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	for _, c := range body.Servers {
+		if err := b.pools.Register(p.newServer(c)); err != nil {
+			slog.Warn("⚠️ Pool server not added", "pool", p.name, "err", err)
+		}
+	}
+	slog.Info("🆕 Pool created via admin API", "pool", p.name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (b *Balancer) adminDeletePool(w http.ResponseWriter, r *http.Request) {
+	p := b.adminPoolNamed(w, r, r.PathValue("pool"))
+	if p == nil {
+		return
+	}
+	removed, err := b.pools.Delete(p.name)
+	if err != nil {
+		status := http.StatusNotFound
+		if p.name == defaultPoolName {
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	slog.Info("🗑️ Pool deleted via admin API", "pool", p.name, "servers", len(removed))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Balancer) adminDrainPool(w http.ResponseWriter, r *http.Request) {
+	p := b.adminPoolNamed(w, r, r.PathValue("pool"))
+	if p == nil {
+		return
+	}
+	servers, err := b.pools.DrainAll(p.name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Info("⏸️ Pool drained via admin API", "pool", p.name, "servers", len(servers))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Balancer) adminEnablePool(w http.ResponseWriter, r *http.Request) {
+	p := b.adminPoolNamed(w, r, r.PathValue("pool"))
+	if p == nil {
+		return
+	}
+	servers, err := b.pools.EnableAll(p.name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Info("▶️ Pool enabled via admin API", "pool", p.name, "servers", len(servers))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+	// Duration is how long a PUT keeps the level, 15m by default.
+	Duration Duration `json:"duration,omitempty"`
+	// Configured and Until describe an override, in responses.
+	Configured string     `json:"configured,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+func adminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	level, base, until := logLevelState()
+	body := logLevelBody{Level: strings.ToLower(level.String())}
+	if !until.IsZero() {
+		body.Configured, body.Until = strings.ToLower(base.String()), &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// adminSetLogLevel changes the log level for a while, e.g. to debug
+// during an incident, then it goes back by itself.
+func adminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body logLevelBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil || body.Level == "" {
+		http.Error(w, fmt.Sprintf("Bad Request: unknown level %q", body.Level), http.StatusBadRequest)
+		return
+	}
+	d := 15 * time.Minute
+	if body.Duration != 0 {
+		d = time.Duration(body.Duration)
+	}
+	if d <= 0 || d > maxLogLevelOverride {
+		http.Error(w, fmt.Sprintf("Bad Request: duration must be between 0 and %s", maxLogLevelOverride), http.StatusBadRequest)
+		return
+	}
+	overrideLogLevel(level, d)
+	slog.Warn("📝 Log level changed via admin API", "level", level.String(), "for", d)
+	adminGetLogLevel(w, r)
+}
+
+// startAdminListener serves the status routes on addr, apart from the
+// proxied traffic. Prefork workers share it like the main listener.
+func (b *Balancer) startAdminListener(addr string, auth *adminAuth) {
+	var ln net.Listener
+	var err error
+	if workerID() > 0 {
+		ln, err = listenReusePort(addr)
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		fatal("Error listening", "addr", addr, "err", err)
+	}
+	mux := http.NewServeMux()
+	b.registerStatusRoutes(mux, auth)
+	slog.Info("🛠️ Admin API listening", "addr", ln.Addr().String())
+	go func() { fatal("Admin server stopped", "err", http.Serve(ln, recoverPanics(mux))) }()
+}
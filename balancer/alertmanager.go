@@ -0,0 +1,255 @@
+package balancer
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// AlertmanagerConfig pushes the firing alerts to a Prometheus
+// Alertmanager, which expects them sent again until they resolve.
+type AlertmanagerConfig struct {
+	// URL is the Alertmanager's base URL, e.g. http://alertmanager:9093.
+	URL string `json:"url"`
+	// Interval is how often firing alerts are sent again. Defaults to 1m;
+	// keep it below the Alertmanager's resolve_timeout.
+	Interval Duration `json:"interval"`
+	// Labels are added to every alert, e.g. to tell instances apart.
+	Labels  map[string]string `json:"labels"`
+	Headers map[string]string `json:"headers"`
+}
+
+func (c *AlertmanagerConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval)
+	}
+	return time.Minute
+}
+
+// alertPairs maps each alert kind that starts a condition to the kind
+// that ends it. Alerts of other kinds are one-offs that never fire for a
+// while.
+var alertPairs = map[string]string{
+	"backend_down":     "backend_up",
+	"backend_flapping": "backend_stable",
+	"pool_empty":       "pool_recovered",
+	"error_rate_high":  "error_rate_ok",
+	"mixed_versions":   "versions_converged",
+}
+
+// resolves returns the kind an alert of kind ends, if any.
+func resolves(kind string) (string, bool) {
+	for start, end := range alertPairs {
+		if end == kind {
+			return start, true
+		}
+	}
+	return "", false
+}
+
+// resolvedAlert is a condition that ended and that the Alertmanager
+// hasn't been told about yet.
+type resolvedAlert struct {
+	Alert
+	endsAt time.Time
+}
+
+// track updates the firing conditions with al and reports whether they
+// changed. The caller holds a.mu.
+func (a *alerter) track(al Alert) bool {
+	id := al.Key + "\xff" + al.Kind
+	if _, ok := alertPairs[al.Kind]; ok {
+		if _, firing := a.firing[id]; firing {
+			return false
+		}
+		a.firing[id] = al
+		return true
+	}
+	start, ok := resolves(al.Kind)
+	if !ok {
+		return false
+	}
+	return a.end(al.Key+"\xff"+start, al.Time)
+}
+
+// end stops the firing condition id. The caller holds a.mu.
+func (a *alerter) end(id string, at time.Time) bool {
+	f, ok := a.firing[id]
+	if !ok {
+		return false
+	}
+	delete(a.firing, id)
+	if a.alertmanager != nil {
+		a.resolved = append(a.resolved, resolvedAlert{f, at})
+	}
+	return true
+}
+
+// forget ends the conditions of servers that are gone, which can't
+// resolve on their own any more.
+func (a *alerter) forget(servers []*Server) {
+	present := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		present[s.Name] = true
+	}
+	now := time.Now()
+	changed := false
+	a.mu.Lock()
+	for id, f := range a.firing {
+		if f.Server != "" && !present[f.Server] {
+			changed = a.end(id, now) || changed
+		}
+	}
+	for name := range a.flapping {
+		if !present[name] {
+			delete(a.flapping, name)
+		}
+	}
+	a.mu.Unlock()
+	if changed && a.alertmanager != nil {
+		go a.push()
+	}
+}
+
+// amAlert is an alert in the Alertmanager's API format.
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt,omitzero"`
+}
+
+func (a *alerter) amAlert(al Alert, endsAt time.Time) amAlert {
+	labels := map[string]string{"alertname": al.Kind, "severity": al.Severity, "key": al.Key}
+	if al.Server != "" {
+		labels["server"] = al.Server
+	}
+	if pool, ok := strings.CutPrefix(al.Key, "pool:"); ok {
+		labels["pool"] = pool
+	}
+	if a.alertmanager != nil {
+		for k, v := range a.alertmanager.cfg.Labels {
+			labels[k] = v
+		}
+	}
+	return amAlert{Labels: labels, Annotations: map[string]string{"summary": al.Message}, StartsAt: al.Time, EndsAt: endsAt}
+}
+
+// firingAlerts returns the conditions firing now, oldest first.
+func (a *alerter) firingAlerts() []amAlert {
+	out := []amAlert{}
+	if a == nil {
+		return out
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, f := range a.firing {
+		out = append(out, a.amAlert(f, time.Time{}))
+	}
+	sortAlerts(out)
+	return out
+}
+
+func sortAlerts(alerts []amAlert) {
+	slices.SortFunc(alerts, func(x, y amAlert) int {
+		return cmp.Or(x.StartsAt.Compare(y.StartsAt), cmp.Compare(x.Labels["key"], y.Labels["key"]),
+			cmp.Compare(x.Labels["alertname"], y.Labels["alertname"]))
+	})
+}
+
+// alertsHandler lists the firing conditions in the Alertmanager's format,
+// for teams that don't scrape /metrics.
+func (b *Balancer) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(b.alerts.firingAlerts())
+}
+
+type alertmanagerPusher struct {
+	cfg    AlertmanagerConfig
+	client *http.Client
+	// mu keeps pushes in order, so a resolved alert isn't overtaken by an
+	// older push that still had it firing.
+	mu sync.Mutex
+}
+
+func newAlertmanagerPusher(c AlertmanagerConfig) (*alertmanagerPusher, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("alertmanager: url is required")
+	}
+	return &alertmanagerPusher{cfg: c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// push sends the firing conditions and the ones that ended since the
+// last successful push to the Alertmanager.
+func (a *alerter) push() {
+	am := a.alertmanager
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	a.mu.Lock()
+	payload := make([]amAlert, 0, len(a.firing)+len(a.resolved))
+	for _, f := range a.firing {
+		payload = append(payload, a.amAlert(f, time.Time{}))
+	}
+	sortAlerts(payload)
+	for _, r := range a.resolved {
+		payload = append(payload, a.amAlert(r.Alert, r.endsAt))
+	}
+	sent := len(a.resolved)
+	a.mu.Unlock()
+
+	if err := am.post(payload); err != nil {
+		slog.Warn("⚠️ Alertmanager push failed", "err", err)
+		return
+	}
+	a.mu.Lock()
+	a.resolved = a.resolved[sent:]
+	a.mu.Unlock()
+}
+
+func (am *alertmanagerPusher) post(alerts []amAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(am.cfg.URL, "/") + "/api/v2/alerts"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range am.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := am.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alertmanager %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// startPush sends the firing alerts to the Alertmanager every interval.
+func (a *alerter) startPush(beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(a.alertmanager.cfg.interval()).Do(func() {
+		defer recoverJob("alertmanager_push")
+		a.push()
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
@@ -0,0 +1,333 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertsConfig turns on notifications for health transitions, an empty
+// pool, a high error rate and, with version_check, mixed versions.
+type AlertsConfig struct {
+	// Cooldown suppresses repeats of the same alert. Defaults to 5m.
+	Cooldown Duration `json:"cooldown"`
+	// ErrorRateThreshold fires an alert when errors/requests over the last
+	// minute exceed it (e.g. 0.05). Zero disables the check.
+	ErrorRateThreshold float64 `json:"error_rate_threshold"`
+	// ErrorRateMinRequests avoids alerting on a handful of requests.
+	// Defaults to 20.
+	ErrorRateMinRequests int64 `json:"error_rate_min_requests"`
+	// FlapThreshold is how many health transitions within FlapWindow make
+	// a server flapping. Default 4 within 10m; health_history_size must
+	// keep at least that many.
+	FlapThreshold int                 `json:"flap_threshold"`
+	FlapWindow    Duration            `json:"flap_window"`
+	Webhooks      []WebhookConfig     `json:"webhooks"`
+	Email         []EmailConfig       `json:"email"`
+	PagerDuty     []PagerDutyConfig   `json:"pagerduty"`
+	Alertmanager  *AlertmanagerConfig `json:"alertmanager,omitempty"`
+}
+
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+type Alert struct {
+	// Key identifies what the alert is about ("server:api-1", "pool"...);
+	// alerts with the same key and kind are deduplicated.
+	Key      string `json:"key"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	Server   string `json:"server,omitempty"`
+	Message  string `json:"message"`
+	// Resolved marks the recovery half of a pair (backend_up after
+	// backend_down and so on).
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+// Notifier delivers alerts somewhere. Notify is called from its own
+// goroutine, so it may block.
+type Notifier interface {
+	Notify(Alert) error
+}
+
+// severityFilter limits a notifier to some severities; empty means all.
+type severityFilter []string
+
+func (f severityFilter) validate() error {
+	for _, sev := range f {
+		if sev != severityInfo && sev != severityWarning && sev != severityCritical {
+			return fmt.Errorf("unknown severity %q", sev)
+		}
+	}
+	return nil
+}
+
+func (f severityFilter) allows(sev string) bool {
+	return len(f) == 0 || slices.Contains(f, sev)
+}
+
+type routedNotifier struct {
+	Notifier
+	severities severityFilter
+}
+
+type alerter struct {
+	notifiers      []routedNotifier
+	cooldown       time.Duration
+	errorThreshold float64
+	minRequests    int64
+	flapThreshold  int
+	flapWindow     time.Duration
+	alertmanager   *alertmanagerPusher
+
+	mu        sync.Mutex
+	sent      map[string]time.Time // key+kind -> last sent
+	poolEmpty map[string]bool      // by pool name
+	errorHigh bool
+	mixed     map[string]bool // by pool name
+	flapping  map[string]bool // by server name
+	// firing holds the conditions that started and haven't ended, by
+	// key+kind, and resolved the ones that ended since the last
+	// Alertmanager push. See alertmanager.go.
+	firing   map[string]Alert
+	resolved []resolvedAlert
+}
+
+func newAlerter(c AlertsConfig) (*alerter, error) {
+	a := &alerter{
+		cooldown:       time.Duration(c.Cooldown),
+		errorThreshold: c.ErrorRateThreshold,
+		minRequests:    c.ErrorRateMinRequests,
+		flapThreshold:  c.FlapThreshold,
+		flapWindow:     time.Duration(c.FlapWindow),
+		sent:           make(map[string]time.Time),
+		poolEmpty:      make(map[string]bool),
+		mixed:          make(map[string]bool),
+		flapping:       make(map[string]bool),
+		firing:         make(map[string]Alert),
+	}
+	if a.cooldown <= 0 {
+		a.cooldown = 5 * time.Minute
+	}
+	if a.minRequests <= 0 {
+		a.minRequests = 20
+	}
+	if a.flapThreshold <= 0 {
+		a.flapThreshold = 4
+	}
+	if a.flapWindow <= 0 {
+		a.flapWindow = 10 * time.Minute
+	}
+	if c.Alertmanager != nil {
+		var err error
+		if a.alertmanager, err = newAlertmanagerPusher(*c.Alertmanager); err != nil {
+			return nil, fmt.Errorf("alerts: %w", err)
+		}
+	}
+	add := func(kind string, i int, sev severityFilter, n Notifier, err error) error {
+		if err == nil {
+			err = sev.validate()
+		}
+		if err != nil {
+			return fmt.Errorf("alerts: %s[%d]: %w", kind, i, err)
+		}
+		a.notifiers = append(a.notifiers, routedNotifier{n, sev})
+		return nil
+	}
+	for i, wc := range c.Webhooks {
+		n, err := newWebhookNotifier(wc)
+		if err := add("webhooks", i, wc.Severities, n, err); err != nil {
+			return nil, err
+		}
+	}
+	for i, ec := range c.Email {
+		n, err := newEmailNotifier(ec)
+		if err := add("email", i, ec.Severities, n, err); err != nil {
+			return nil, err
+		}
+	}
+	for i, pc := range c.PagerDuty {
+		n, err := newPagerDutyNotifier(pc)
+		if err := add("pagerduty", i, pc.Severities, n, err); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// fire sends an alert to every notifier unless the same key and kind went
+// out within the cooldown. Either way it counts for the firing
+// conditions.
+func (a *alerter) fire(al Alert) {
+	if a == nil {
+		return
+	}
+	al.Time = time.Now()
+	id := al.Key + "\xff" + al.Kind
+	a.mu.Lock()
+	if a.track(al) && a.alertmanager != nil {
+		go a.push()
+	}
+	if last, ok := a.sent[id]; ok && al.Time.Sub(last) < a.cooldown {
+		a.mu.Unlock()
+		return
+	}
+	a.sent[id] = al.Time
+	a.mu.Unlock()
+
+	slog.Warn("🔔 "+al.Message, "severity", al.Severity)
+	for _, n := range a.notifiers {
+		if !n.severities.allows(al.Severity) {
+			continue
+		}
+		go func(n Notifier) {
+			if err := n.Notify(al); err != nil {
+				slog.Warn("⚠️ Alert delivery failed", "err", err)
+			}
+		}(n)
+	}
+}
+
+func (a *alerter) healthChanged(s *Server, alive bool) {
+	if alive {
+		a.fire(Alert{Key: "server:" + s.Name, Kind: "backend_up", Severity: severityInfo, Resolved: true, Server: s.Name,
+			Message: fmt.Sprintf("%s is healthy again", s.Name)})
+	} else {
+		a.fire(Alert{Key: "server:" + s.Name, Kind: "backend_down", Severity: severityWarning, Server: s.Name,
+			Message: fmt.Sprintf("%s failed its health check", s.Name)})
+	}
+}
+
+// byPool groups servers by the name of their pool, in pool name order.
+func byPool(servers []*Server) ([]string, map[string][]*Server) {
+	groups := map[string][]*Server{}
+	for _, s := range servers {
+		groups[s.pool.name] = append(groups[s.pool.name], s)
+	}
+	return slices.Sorted(maps.Keys(groups)), groups
+}
+
+// evaluate checks the pool-wide conditions. It runs after each health
+// check round and only alerts on transitions.
+func (a *alerter) evaluate(servers []*Server) {
+	if a == nil {
+		return
+	}
+	names, groups := byPool(servers)
+	for _, name := range names {
+		a.evaluatePool(name, groups[name])
+	}
+	now := time.Now()
+	for _, s := range servers {
+		a.evaluateFlapping(s, now)
+	}
+	a.forget(servers)
+
+	reqs, errs := requestWindow.Sum(time.Minute), errorWindow.Sum(time.Minute)
+	rate := 0.0
+	if reqs > 0 {
+		rate = float64(errs) / float64(reqs)
+	}
+	high := a.errorThreshold > 0 && reqs >= a.minRequests && rate > a.errorThreshold
+
+	a.mu.Lock()
+	highChanged := high != a.errorHigh
+	a.errorHigh = high
+	a.mu.Unlock()
+
+	if highChanged {
+		if high {
+			a.fire(Alert{Key: "error_rate", Kind: "error_rate_high", Severity: severityWarning,
+				Message: fmt.Sprintf("error rate %.1f%% over the last minute (threshold %.1f%%)", rate*100, a.errorThreshold*100)})
+		} else {
+			a.fire(Alert{Key: "error_rate", Kind: "error_rate_ok", Severity: severityInfo, Resolved: true,
+				Message: fmt.Sprintf("error rate back to %.1f%%", rate*100)})
+		}
+	}
+}
+
+func (a *alerter) evaluatePool(name string, servers []*Server) {
+	healthy := 0
+	for _, s := range servers {
+		if s.CheckHealth() && !s.IsDisabled() {
+			healthy++
+		}
+	}
+	empty := healthy == 0
+
+	a.mu.Lock()
+	changed := empty != a.poolEmpty[name]
+	a.poolEmpty[name] = empty
+	a.mu.Unlock()
+	if !changed {
+		return
+	}
+	if empty {
+		a.fire(Alert{Key: "pool:" + name, Kind: "pool_empty", Severity: severityCritical,
+			Message: fmt.Sprintf("pool %s has no healthy servers", name)})
+	} else {
+		a.fire(Alert{Key: "pool:" + name, Kind: "pool_recovered", Severity: severityInfo, Resolved: true,
+			Message: fmt.Sprintf("pool %s has %d healthy servers again", name, healthy)})
+	}
+}
+
+// evaluateFlapping alerts when s starts and stops changing health often.
+func (a *alerter) evaluateFlapping(s *Server, now time.Time) {
+	changes := 0
+	for _, ev := range s.HealthHistory() {
+		if now.Sub(ev.Time) <= a.flapWindow {
+			changes++
+		}
+	}
+	flapping := changes >= a.flapThreshold
+
+	a.mu.Lock()
+	changed := flapping != a.flapping[s.Name]
+	a.flapping[s.Name] = flapping
+	a.mu.Unlock()
+	if !changed {
+		return
+	}
+	if flapping {
+		a.fire(Alert{Key: "server:" + s.Name, Kind: "backend_flapping", Severity: severityWarning, Server: s.Name,
+			Message: fmt.Sprintf("%s changed health %d times in %s", s.Name, changes, a.flapWindow)})
+	} else {
+		a.fire(Alert{Key: "server:" + s.Name, Kind: "backend_stable", Severity: severityInfo, Resolved: true, Server: s.Name,
+			Message: fmt.Sprintf("%s is no longer flapping", s.Name)})
+	}
+}
+
+// evaluateVersions alerts when the healthy servers of a pool start and stop
+// reporting different versions.
+func (a *alerter) evaluateVersions(servers []*Server) {
+	if a == nil {
+		return
+	}
+	names, groups := byPool(servers)
+	for _, name := range names {
+		versions := runningVersions(groups[name])
+		mixed := len(versions) > 1
+		a.mu.Lock()
+		changed := mixed != a.mixed[name]
+		a.mixed[name] = mixed
+		a.mu.Unlock()
+		if !changed {
+			continue
+		}
+		if mixed {
+			a.fire(Alert{Key: "pool:" + name, Kind: "mixed_versions", Severity: severityWarning,
+				Message: fmt.Sprintf("pool %s runs mixed versions: %s", name, strings.Join(versions, ", "))})
+		} else {
+			a.fire(Alert{Key: "pool:" + name, Kind: "versions_converged", Severity: severityInfo, Resolved: true,
+				Message: fmt.Sprintf("pool %s runs a single version again", name)})
+		}
+	}
+}
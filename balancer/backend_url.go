@@ -0,0 +1,73 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// backendDirector points requests at target, which can carry a base path
+// (http://host:8080/service) and a query of its own. Unlike
+// NewSingleHostReverseProxy it joins paths without adding a trailing
+// slash: "/" goes to "/service", "/x" to "/service/x". Percent-encoding
+// of both paths is kept as sent.
+func backendDirector(target *url.URL) func(*http.Request) {
+	return func(r *http.Request) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		r.URL.Path, r.URL.RawPath = joinBasePath(target, r.URL)
+		switch {
+		case target.RawQuery == "":
+		case r.URL.RawQuery == "":
+			r.URL.RawQuery = target.RawQuery
+		default:
+			r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+		}
+		if _, ok := r.Header["User-Agent"]; !ok {
+			// Don't let net/http add its own.
+			r.Header.Set("User-Agent", "")
+		}
+	}
+}
+
+// joinBasePath returns the Path and RawPath of req's path appended to
+// base's.
+func joinBasePath(base, req *url.URL) (path, rawPath string) {
+	if base.Path == "" || base.Path == "/" {
+		return req.Path, req.RawPath
+	}
+	if req.Path == "" || req.Path == "/" {
+		return base.Path, base.RawPath
+	}
+	reqPath, reqEscaped := req.Path, req.EscapedPath()
+	if !strings.HasPrefix(reqPath, "/") {
+		reqPath, reqEscaped = "/"+reqPath, "/"+reqEscaped
+	}
+	path = strings.TrimSuffix(base.Path, "/") + reqPath
+	escaped := strings.TrimSuffix(base.EscapedPath(), "/") + reqEscaped
+	if escaped != (&url.URL{Path: path}).EscapedPath() {
+		rawPath = escaped
+	}
+	return path, rawPath
+}
+
+// validateServerURL checks that a backend URL can be proxied to: http or
+// https with a host. It may have a path.
+func validateServerURL(sc ServerConfig) error {
+	u, err := url.Parse(sc.URL)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("server %q: url %q must be http:// or https:// with a host", sc.Name, sc.URL)
+	}
+	return checkURLHost(sc.Name, u)
+}
+
+// checkURLHost rejects IPv6 literals without brackets, which url.Parse
+// accepts but splits at the wrong colon: "http://2001:db8::1/" would be
+// host 2001:db8: and port 1.
+func checkURLHost(server string, u *url.URL) error {
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return fmt.Errorf("server %q: IPv6 address in %q must be in brackets, as in http://[2001:db8::1]:8080", server, u.Redacted())
+	}
+	return nil
+}
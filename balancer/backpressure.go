@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryAfter caps the hint we give clients; past a minute the estimate
+// isn't worth much.
+const maxRetryAfter = 60
+
+// averageLatency is the mean latency EWMA of the registered servers, or 1s
+// before any request has been measured.
+func (m *PoolManager) averageLatency() time.Duration {
+	var sum float64
+	n := 0
+	for _, s := range m.Servers() {
+		if ms := s.pool.Latency(s); ms > 0 {
+			sum += ms
+			n++
+		}
+	}
+	if n == 0 {
+		return time.Second
+	}
+	return time.Duration(sum / float64(n) * float64(time.Millisecond))
+}
+
+// retryAfter estimates how long until there is room again: excess
+// requests ahead of the client drain at capacity per average latency.
+func (m *PoolManager) retryAfter(excess, capacity int64) int {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if excess < 1 {
+		excess = 1
+	}
+	secs := math.Ceil(float64(excess) / float64(capacity) * m.averageLatency().Seconds())
+	return int(math.Max(1, math.Min(maxRetryAfter, secs)))
+}
+
+// writeBackpressure answers a refused request with enough information for
+// a well-behaved client to back off: Retry-After always, and the
+// RateLimit headers when there is a known budget.
+func writeBackpressure(w http.ResponseWriter, status, retry int, limit int64) {
+	h := w.Header()
+	h.Set("Retry-After", strconv.Itoa(retry))
+	if limit > 0 {
+		h.Set("RateLimit-Limit", strconv.FormatInt(limit, 10))
+		h.Set("RateLimit-Remaining", "0")
+		h.Set("RateLimit-Reset", strconv.Itoa(retry))
+	}
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,225 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loadbalancer/pluginapi"
+)
+
+// Balancer is one load balancer: its config, server pools, the features
+// the config turns on and the handlers serving them. Main builds one from
+// the config file, NewHandler from the config it's given and tests build
+// their own, so nothing is shared between them. Metrics, StatsD and plugin registrations stay process-wide.
+type Balancer struct {
+	cfg   Config
+	pools *PoolManager
+	// configServers tracks the servers that came from the config file, so
+	// a reload only adds and removes those and leaves admin API and
+	// discovered servers alone.
+	configServers *targetSync
+
+	// These are nil when the config doesn't turn them on; their methods
+	// are no-ops then.
+	alerts     *alerter
+	sticky     *stickyTable
+	clientKeys *clientKeyResolver
+	routing    *routingScript
+	registry   *registrar
+	rateLimits *tenantLimits
+	decisions  *decisionLog
+
+	// watchdog restarts background jobs that stop reporting in. It is set
+	// up by start.
+	watchdog *watchdog
+
+	// splitOverride is the traffic split of the schedule window that is
+	// open, if any. It replaces the default pool's own while set.
+	splitOverride atomic.Pointer[[]TrafficSplit]
+
+	// ready is set once the startup gate has passed; see startup.go.
+	ready atomic.Bool
+
+	// done is closed by stop, ending the background jobs that aren't
+	// under the watchdog.
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newBalancer sets up the pools, servers and optional features of c.
+// Nothing runs in the background until start.
+func newBalancer(c Config) (*Balancer, error) {
+	b := &Balancer{cfg: c, pools: newPoolManager(), done: make(chan struct{})}
+	b.configServers = newTargetSync(b.pools, "config")
+	err := c.eachPool(func(name string, pc *PoolConfig) error {
+		p := b.pools.Get(name)
+		if p == nil {
+			var err error
+			if p, err = b.pools.Create(name, *pc); err != nil {
+				return err
+			}
+		} else if err := p.configure(*pc); err != nil {
+			return err
+		}
+		for _, sc := range pc.Servers {
+			if err := b.pools.Register(p.newServer(sc)); err != nil {
+				return err
+			}
+			b.configServers.owned[sc.Name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Alerts != nil {
+		if b.alerts, err = newAlerter(*c.Alerts); err != nil {
+			return nil, err
+		}
+	}
+	if c.Sticky != nil {
+		b.sticky = newStickyTable(*c.Sticky)
+	}
+	b.rateLimits = newTenantLimits(&c)
+	if b.clientKeys, err = newClientKeyResolver(c.ClientKey, c.Routes); err != nil {
+		return nil, err
+	}
+	if c.RoutingScript != "" {
+		if b.routing, err = newRoutingScript(c.RoutingScript); err != nil {
+			return nil, err
+		}
+	}
+	if c.Registration != nil {
+		if b.registry, err = newRegistrar(*c.Registration, b.pools); err != nil {
+			return nil, err
+		}
+	}
+	if c.DecisionLog != nil {
+		if b.decisions, err = newDecisionLog(*c.DecisionLog); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// proxyHandler is ForwardRequest with everything the config puts in front
+// of it, innermost first: middleware plugins, forward auth, admission,
+// tenant rate limits, method filtering, client certificate headers,
+// deadlines, operator override headers, the client IP resolver and the
+// request totals.
+func (b *Balancer) proxyHandler(plugins []pluginapi.Plugin) (http.Handler, error) {
+	c := &b.cfg
+	proxy := wrapPlugins(plugins, http.HandlerFunc(b.ForwardRequest))
+	if c.ForwardAuth != nil {
+		proxy = newForwardAuth(*c.ForwardAuth).Wrap(proxy)
+	}
+	if c.Priority != nil {
+		proxy = newAdmission(*c.Priority, c.Routes, b.pools).Wrap(proxy)
+	}
+	proxy = b.rateLimits.Wrap(proxy)
+	proxy = filterMethods(c.Routes, proxy)
+	proxy = clientCertHeaders(proxy)
+	if c.Deadline != nil {
+		proxy = c.Deadline.Wrap(proxy)
+	}
+	auth, err := newAdminAuth(c.AdminTokens)
+	if err != nil {
+		return nil, err
+	}
+	proxy = b.overrideHeaders(auth, proxy)
+	ipResolver, err := newClientIPResolver(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return countResponses(ipResolver.Wrap(proxy)), nil
+}
+
+// registerRoutes adds the status and public routes to one mux, for when
+// there is no admin_listen.
+func (b *Balancer) registerRoutes(mux *http.ServeMux, auth *adminAuth) {
+	b.registerStatusRoutes(mux, auth)
+	b.registerPublicRoutes(mux)
+}
+
+// registerStatusRoutes adds /stats and its summary, route and sticky views,
+// /metrics, /alerts, the dashboard and the admin API to mux, behind auth.
+func (b *Balancer) registerStatusRoutes(mux *http.ServeMux, auth *adminAuth) {
+	mux.HandleFunc("/stats", auth.requireScoped(roleViewer, b.statsHandler))
+	mux.HandleFunc("/stats/summary", auth.requireScoped(roleViewer, b.summaryHandler))
+	mux.HandleFunc("/stats/routes", auth.requireScoped(roleViewer, b.routesHandler))
+	mux.HandleFunc("/metrics", auth.require(roleViewer, b.metricsHandler))
+	mux.HandleFunc("GET /alerts", auth.require(roleViewer, b.alertsHandler))
+	mux.HandleFunc("GET /stats/sticky", auth.require(roleViewer, b.stickyHandler))
+	mux.HandleFunc("/dashboard", auth.requireScoped(roleViewer, b.dashboardHandler))
+	mux.HandleFunc("GET /dashboard/config", auth.requireScoped(roleViewer, b.dashboardConfigHandler))
+	mux.HandleFunc("GET /dashboard/servers/{name}", auth.requireScoped(roleViewer, b.dashboardServerHandler))
+	mux.HandleFunc("GET /dashboard/servers/{name}/events", auth.requireScoped(roleViewer, b.dashboardEventsHandler))
+	b.registerAdminRoutes(mux, auth)
+}
+
+// registerPublicRoutes adds the routes that stay on the proxy's listener:
+// the self-registration endpoints, behind their own tokens, and /readyz.
+func (b *Balancer) registerPublicRoutes(mux *http.ServeMux) {
+	b.registry.registerRoutes(mux)
+	mux.HandleFunc("GET /readyz", b.readyzHandler)
+}
+
+// start runs the background jobs: health checks, schedules, discovery,
+// version checks, resource scrapes, prewarming, pool hygiene, registration expiry and
+// Alertmanager pushes. The periodic ones run under the watchdog.
+func (b *Balancer) start(plugins []pluginapi.Plugin) {
+	c := &b.cfg
+	b.watchdog = &watchdog{}
+	b.watchdog.watch("health_check", b.healthCheckStall, b.startHealthCheck)
+	b.startSchedules()
+	b.startDiscovery(plugins, c.Plugins)
+	if c.XDS != nil {
+		b.startXDS(*c.XDS)
+	}
+	if vc := c.VersionCheck; vc != nil {
+		b.watchdog.watch("version_check", every(3, vc.interval()+time.Minute), func(beat func()) func() {
+			return b.startVersionChecks(*vc, beat)
+		})
+	}
+	if rc := c.Resources; rc != nil {
+		b.watchdog.watch("resource_scrape", every(3, rc.interval()), func(beat func()) func() {
+			return b.startResourceScrapes(*rc, beat)
+		})
+	}
+	if c.Prewarm != nil && c.Prewarm.Connections > 0 {
+		b.startPrewarm(*c.Prewarm)
+	}
+	hygieneInterval := 30 * time.Second
+	if c.PoolHygieneInterval > 0 {
+		hygieneInterval = time.Duration(c.PoolHygieneInterval)
+	}
+	b.watchdog.watch("pool_hygiene", every(3, hygieneInterval), func(beat func()) func() {
+		return b.startPoolHygiene(hygieneInterval, beat)
+	})
+	if b.registry != nil {
+		b.watchdog.watch("registration_sweep", every(5, registrationSweepInterval), b.registry.start)
+	}
+	if a := b.alerts; a != nil && a.alertmanager != nil {
+		b.watchdog.watch("alertmanager_push", every(3, a.alertmanager.cfg.interval()), a.startPush)
+	}
+	if b.decisions != nil {
+		go b.decisions.run(b.done)
+	}
+	go b.watchdog.run(watchdogInterval, b.done)
+}
+
+// stop ends the background jobs start began. Requests in flight carry on;
+// that is for the caller's http.Server to wait for.
+func (b *Balancer) stop() {
+	b.stopOnce.Do(func() {
+		close(b.done)
+		if b.watchdog != nil {
+			b.watchdog.stopAll()
+		}
+		for _, p := range b.pools.Pools() {
+			p.close()
+		}
+	})
+}
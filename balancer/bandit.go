@@ -0,0 +1,124 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// The bandit strategy (experimental) treats picking a backend as a
+// multi-armed bandit. Every finished request pays its server a reward
+// between 0 and 1: 0 for a failure, and for a success more the faster it
+// was compared with latency_target. Each pick draws a guess of every
+// server's reward from what it has paid lately (Thompson sampling) and
+// takes the best guess per unit of load. Servers that pay well get more
+// traffic, and the draws keep trying the others, so one that degrades
+// loses traffic within about a window of its own requests and one that
+// recovers wins it back.
+
+// BanditConfig tunes the bandit strategy of a pool.
+type BanditConfig struct {
+	// LatencyTarget is the response time that earns half the reward of an
+	// instant one. Defaults to 100ms.
+	LatencyTarget Duration `json:"latency_target"`
+	// Window is about how many of a server's most recent requests its
+	// reward is learnt from; older ones fade out. Defaults to 100.
+	Window int `json:"window"`
+}
+
+func (c *BanditConfig) validate() error {
+	if c.LatencyTarget < 0 || c.Window < 0 {
+		return fmt.Errorf("bandit: latency_target and window can't be negative")
+	}
+	return nil
+}
+
+func (c *BanditConfig) latencyTarget() time.Duration {
+	if c != nil && c.LatencyTarget > 0 {
+		return time.Duration(c.LatencyTarget)
+	}
+	return 100 * time.Millisecond
+}
+
+func (c *BanditConfig) window() int {
+	if c != nil && c.Window > 0 {
+		return c.Window
+	}
+	return 100
+}
+
+// bandit keeps the heap ordered like weighted_least_connections, for
+// prewarming, stats and the fallback when selection can't scan; picks
+// go through acquireBandit.
+type bandit struct{ weightedLeastConnections }
+
+func (bandit) Name() string { return "bandit" }
+
+// usesBandit reports whether p picks servers with the bandit.
+func usesBandit(p *ServerPool) bool {
+	_, ok := p.Strategy().(bandit)
+	return ok
+}
+
+// banditArm is what a server has paid: decayed sums of the rewards of its
+// requests and of what they fell short of 1. Every server has one, so a
+// pool switched to the bandit starts from what it has already seen.
+type banditArm struct {
+	mu          sync.Mutex
+	paid, short float64
+}
+
+// observe pays the arm for one finished request.
+func (a *banditArm) observe(c *BanditConfig, took time.Duration, failed bool) {
+	reward := 0.0
+	if !failed {
+		reward = 1 / (1 + float64(took)/float64(c.latencyTarget()))
+	}
+	keep := 1 - 1/float64(c.window())
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.paid = a.paid*keep + reward
+	a.short = a.short*keep + 1 - reward
+}
+
+// posterior is the mean and variance of the arm's Beta(1+paid, 1+short)
+// posterior; an arm that has seen nothing is a coin toss.
+func (a *banditArm) posterior() (mean, variance float64) {
+	a.mu.Lock()
+	alpha, beta := 1+a.paid, 1+a.short
+	a.mu.Unlock()
+	n := alpha + beta
+	return alpha / n, alpha * beta / (n * n * (n + 1))
+}
+
+// draw guesses the arm's reward from its posterior, by a normal
+// approximation of the Beta, which is close enough once a server has a
+// few requests behind it and cheap enough to do for every server on
+// every pick. norm draws from the standard normal distribution.
+func (a *banditArm) draw(norm func() float64) float64 {
+	mean, variance := a.posterior()
+	return min(max(mean+norm()*math.Sqrt(variance), 0), 1)
+}
+
+// Reward is s's expected reward under the bandit, from 0 to 1.
+func (s *Server) Reward() float64 {
+	mean, _ := s.arm.posterior()
+	return mean
+}
+
+// banditDraw ranks servers for GetServerBy by one fresh draw each, per
+// unit of weight-adjusted load. It is never a pool's strategy: the
+// scores change on every call, which a heap can't hold.
+type banditDraw struct{}
+
+func (banditDraw) Name() string { return "bandit" }
+func (banditDraw) Load(s *Server) float64 {
+	return -s.arm.draw(s.pool.normFloat64) * float64(max(s.Weight, 1)) / float64(s.ActiveConnections+1)
+}
+
+// acquireBandit is acquireServer for bandit pools. It scans the pool, so
+// its cost grows with the pool size; subsetting keeps large pools small.
+func (b *Balancer) acquireBandit(p *ServerPool) (s *Server, overloaded bool) {
+	return acquireFrom(func(match func(*Server) bool) *Server { return p.GetServerBy(banditDraw{}, match) })
+}
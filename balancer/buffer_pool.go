@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// defaultBufferSize is the copy buffer ReverseProxy allocates for every
+// request when it has no BufferPool.
+const defaultBufferSize = 32 << 10
+
+// bufferPool is an httputil.BufferPool of fixed size buffers, reused
+// across requests instead of allocated for each.
+type bufferPool struct {
+	size int
+	// pool holds *[]byte, since putting a bare slice in an interface
+	// allocates. boxes recycles the emptied pointers for the next Put.
+	pool  sync.Pool
+	boxes sync.Pool
+}
+
+// bufferPools has one pool per buffer size, shared by every server pool
+// asking for that size.
+var bufferPools sync.Map // int -> *bufferPool
+
+// buffersOfSize returns the shared pool of size byte buffers; 0 means
+// defaultBufferSize.
+func buffersOfSize(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	if bp, ok := bufferPools.Load(size); ok {
+		return bp.(*bufferPool)
+	}
+	bp, _ := bufferPools.LoadOrStore(size, &bufferPool{size: size})
+	return bp.(*bufferPool)
+}
+
+func (bp *bufferPool) Get() []byte {
+	box, ok := bp.pool.Get().(*[]byte)
+	if !ok {
+		return make([]byte, bp.size)
+	}
+	b := *box
+	*box = nil
+	bp.boxes.Put(box)
+	return b
+}
+
+func (bp *bufferPool) Put(b []byte) {
+	if cap(b) < bp.size {
+		return
+	}
+	box, ok := bp.boxes.Get().(*[]byte)
+	if !ok {
+		box = new([]byte)
+	}
+	*box = b[:bp.size]
+	bp.pool.Put(box)
+}
+
+func validateBufferSize(size int) error {
+	if size < 0 || size > 0 && size < 512 {
+		return fmt.Errorf("buffer_size must be at least 512 bytes, got %d", size)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// certStore holds the listener's certificates and picks one by SNI. A
+// fileReloader calls load again when the files on disk change.
+type certStore struct {
+	files []CertificateConfig
+
+	mu     sync.RWMutex
+	certs  []*tls.Certificate
+	byName map[string]*tls.Certificate
+}
+
+func newCertStore(files []CertificateConfig) (*certStore, error) {
+	cs := &certStore{files: files}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (cs *certStore) paths() []string {
+	var paths []string
+	for _, f := range cs.files {
+		paths = append(paths, f.CertFile, f.KeyFile)
+	}
+	return paths
+}
+
+// load reads every certificate. On error the previously loaded set stays
+// in place, so a half-written renewal never takes the listener down.
+func (cs *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(cs.files))
+	byName := make(map[string]*tls.Certificate)
+
+	for _, f := range cs.files {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: %s: %w", f.CertFile, err)
+		}
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, n := range names {
+			n = strings.ToLower(n)
+			// First certificate listed wins for a name.
+			if _, ok := byName[n]; !ok {
+				byName[n] = &cert
+			}
+		}
+		certs = append(certs, &cert)
+	}
+
+	cs.mu.Lock()
+	cs.certs, cs.byName = certs, byName
+	cs.mu.Unlock()
+	return nil
+}
+
+// fileReloader re-runs load whenever one of paths changes on disk. It is
+// used for every piece of TLS material we read from files: listener
+// certificates, the client CA and the backend client certificate.
+type fileReloader struct {
+	name  string
+	paths []string
+	load  func() error
+
+	mu       sync.Mutex
+	modTimes []time.Time
+}
+
+var (
+	reloadersMu sync.Mutex
+	reloaders   []*fileReloader
+)
+
+// watchFile registers a reloader with watchFiles. load must already have
+// succeeded once; later failures keep the previous material in place.
+func watchFile(name string, paths []string, load func() error) *fileReloader {
+	fr := &fileReloader{name: name, paths: paths, load: load}
+	fr.modTimes = fr.stat()
+	reloadersMu.Lock()
+	reloaders = append(reloaders, fr)
+	reloadersMu.Unlock()
+	return fr
+}
+
+func (fr *fileReloader) stat() []time.Time {
+	times := make([]time.Time, len(fr.paths))
+	for i, p := range fr.paths {
+		if st, err := os.Stat(p); err == nil {
+			times[i] = st.ModTime()
+		}
+	}
+	return times
+}
+
+func (fr *fileReloader) reloadIfChanged() {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	now := fr.stat()
+	if slices.EqualFunc(now, fr.modTimes, time.Time.Equal) {
+		return
+	}
+	if err := fr.load(); err != nil {
+		slog.Warn("⚠️ Reload failed, keeping the current one", "file", fr.name, "err", err)
+		return
+	}
+	fr.modTimes = now
+	slog.Info("🔐 Reloaded", "file", fr.name)
+}
+
+func watchFiles(interval time.Duration) {
+	for range time.Tick(interval) {
+		reloadersMu.Lock()
+		current := slices.Clone(reloaders)
+		reloadersMu.Unlock()
+		for _, fr := range current {
+			fr.reloadIfChanged()
+		}
+	}
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// lookup finds the certificate for an SNI name: exact match first, then a
+// wildcard for the parent domain. ok is false if only the default matched.
+func (cs *certStore) lookup(serverName string) (cert *tls.Certificate, ok bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	name := normalizeSNI(serverName)
+	if c, found := cs.byName[name]; found {
+		return c, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c, found := cs.byName["*"+name[i:]]; found {
+			return c, true
+		}
+	}
+	return cs.certs[0], false
+}
+
+// normalizeSNI lowercases a host name and drops a trailing root dot.
+func normalizeSNI(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func (cs *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := cs.lookup(hello.ServerName)
+	return cert, nil
+}
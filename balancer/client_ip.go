@@ -0,0 +1,107 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// clientIPResolver works out the real client address. X-Forwarded-For is
+// only believed when the request came through one of the trusted proxies,
+// and then we take the right-most hop that isn't a trusted proxy itself.
+type clientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+func newClientIPResolver(trustedProxies []string) (*clientIPResolver, error) {
+	trusted, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &clientIPResolver{trusted: trusted}, nil
+}
+
+func (cr *clientIPResolver) resolve(r *http.Request) string {
+	peer := canonicalIP(remoteHost(r.RemoteAddr))
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !prefixesContain(cr.trusted, addr) {
+		return peer
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hops = append(hops, h)
+			}
+		}
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseForwardedHop(hops[i])
+		if err != nil {
+			// Garbage in the chain: stop at the last address we could trust.
+			break
+		}
+		client = hop.String()
+		if !prefixesContain(cr.trusted, hop) {
+			break
+		}
+	}
+	return client
+}
+
+// Wrap stores the resolved client IP on the request context for rate
+// limiting, ACLs and logging to read via clientIP.
+func (cr *clientIPResolver) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, cr.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP returns the resolved client address, falling back to the TCP
+// peer when the request didn't pass through clientIPResolver.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return canonicalIP(remoteHost(r.RemoteAddr))
+}
+
+// canonicalIP writes an address the same way whichever form it came in:
+// IPv4-mapped IPv6 as IPv4, without an IPv6 zone. Anything else is
+// returned as is.
+func canonicalIP(s string) string {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return s
+	}
+	return addr.Unmap().WithZone("").String()
+}
+
+// parseForwardedHop reads one X-Forwarded-For entry. Besides bare
+// addresses, some proxies send a port or brackets ("203.0.113.7:4711",
+// "[2001:db8::1]:4711", "[2001:db8::1]").
+func parseForwardedHop(h string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(h); err == nil {
+		return ap.Addr().Unmap().WithZone(""), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(h, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,242 @@
+package balancer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ClientKeyConfig says what identifies a client for sticky sessions and
+// consistent_hash pools. It can be set for the whole config and
+// overridden per route.
+type ClientKeyConfig struct {
+	// Source is "ip" (the default), "header", "cookie", "jwt_claim",
+	// "query", "path", "expr" or a name registered with RegisterClientKey.
+	Source string `json:"source"`
+	// Name is the header, cookie, claim or query parameter to read, the
+	// path segment (1 for the first), or for expr the expression; see
+	// parseKeyExpr.
+	Name string `json:"name,omitempty"`
+}
+
+// ClientKeyFunc extracts a client key from a request. ok is false when
+// the request doesn't carry one; the client IP is used then.
+type ClientKeyFunc func(r *http.Request) (key string, ok bool)
+
+var (
+	customKeysMu sync.RWMutex
+	customKeys   = map[string]ClientKeyFunc{}
+)
+
+// RegisterClientKey makes f available as client key source name. Call it
+// before the config is loaded.
+func RegisterClientKey(name string, f ClientKeyFunc) {
+	customKeysMu.Lock()
+	defer customKeysMu.Unlock()
+	customKeys[name] = f
+}
+
+func ipKey(r *http.Request) (string, bool) { return clientIP(r), true }
+
+func (c *ClientKeyConfig) extractor() (ClientKeyFunc, error) {
+	if c == nil {
+		return ipKey, nil
+	}
+	switch c.Source {
+	case "", "ip":
+		return ipKey, nil
+	case "header", "cookie", "jwt_claim", "query", "path", "expr":
+		if c.Name == "" {
+			return nil, fmt.Errorf("client_key: %s needs a name", c.Source)
+		}
+	}
+	name := c.Name
+	switch c.Source {
+	case "header":
+		return func(r *http.Request) (string, bool) {
+			v := r.Header.Get(name)
+			return v, v != ""
+		}, nil
+	case "cookie":
+		return func(r *http.Request) (string, bool) {
+			ck, err := r.Cookie(name)
+			if err != nil || ck.Value == "" {
+				return "", false
+			}
+			return ck.Value, true
+		}, nil
+	case "jwt_claim":
+		return func(r *http.Request) (string, bool) { return jwtClaim(r, name) }, nil
+	case "query":
+		return func(r *http.Request) (string, bool) {
+			v := r.URL.Query().Get(name)
+			return v, v != ""
+		}, nil
+	case "path":
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("client_key: path needs a segment number from 1, got %q", name)
+		}
+		return func(r *http.Request) (string, bool) { return pathSegment(r.URL.Path, n) }, nil
+	case "expr":
+		return parseKeyExpr(name)
+	}
+	customKeysMu.RLock()
+	f, ok := customKeys[c.Source]
+	customKeysMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("client_key: unknown source %q", c.Source)
+	}
+	return f, nil
+}
+
+// pathSegment returns the nth segment of path, counting from 1.
+func pathSegment(path string, n int) (string, bool) {
+	for seg := range strings.SplitSeq(strings.TrimPrefix(path, "/"), "/") {
+		if n--; n == 0 {
+			return seg, seg != ""
+		}
+	}
+	return "", false
+}
+
+// parseKeyExpr compiles a key expression: terms such as "header:X-Tenant",
+// "cookie:uid", "query:user", "path:2", "jwt_claim:sub" or "ip", joined
+// with "+" to combine them and "|" for alternatives, tried in order, e.g.
+// "header:X-Tenant + path:2 | ip". An alternative yields a key only if
+// all of its terms do.
+func parseKeyExpr(expr string) (ClientKeyFunc, error) {
+	var alts [][]ClientKeyFunc
+	for alt := range strings.SplitSeq(expr, "|") {
+		var terms []ClientKeyFunc
+		for term := range strings.SplitSeq(alt, "+") {
+			source, name, _ := strings.Cut(strings.TrimSpace(term), ":")
+			if source == "expr" || source == "" {
+				return nil, fmt.Errorf("client_key: bad term %q in %q", strings.TrimSpace(term), expr)
+			}
+			f, err := (&ClientKeyConfig{Source: source, Name: name}).extractor()
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, f)
+		}
+		alts = append(alts, terms)
+	}
+	return func(r *http.Request) (string, bool) {
+	next:
+		for _, terms := range alts {
+			parts := make([]string, len(terms))
+			for i, f := range terms {
+				v, ok := f(r)
+				if !ok {
+					continue next
+				}
+				parts[i] = v
+			}
+			return strings.Join(parts, "/"), true
+		}
+		return "", false
+	}, nil
+}
+
+// jwtClaim reads a top-level claim from the bearer token. The signature
+// is NOT checked: this is good enough to spread or pin traffic, but put
+// forward_auth in front if clients must not be able to pick their key.
+func jwtClaim(r *http.Request, claim string) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	var claims map[string]any
+	if json.Unmarshal(payload, &claims) != nil {
+		return "", false
+	}
+	switch v := claims[claim].(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// keySource is a compiled ClientKeyConfig. name prefixes every key, so a
+// header value can never collide with an IP.
+type keySource struct {
+	name string
+	f    ClientKeyFunc
+}
+
+func (c *ClientKeyConfig) compile() (*keySource, error) {
+	f, err := c.extractor()
+	if err != nil {
+		return nil, err
+	}
+	name := "ip"
+	if c != nil && c.Source != "" {
+		name = c.Source
+		if c.Name != "" {
+			name += ":" + c.Name
+		}
+	}
+	return &keySource{name, f}, nil
+}
+
+// clientKeyResolver holds the default source and the per-route ones.
+type clientKeyResolver struct {
+	routes  []RouteConfig
+	byRoute []*keySource // nil where the route has no client_key
+	def     *keySource
+}
+
+func newClientKeyResolver(def *ClientKeyConfig, routes []RouteConfig) (*clientKeyResolver, error) {
+	ck := &clientKeyResolver{routes: routes, byRoute: make([]*keySource, len(routes))}
+	var err error
+	if ck.def, err = def.compile(); err != nil {
+		return nil, err
+	}
+	for i := range routes {
+		if routes[i].ClientKey == nil {
+			continue
+		}
+		if ck.byRoute[i], err = routes[i].ClientKey.compile(); err != nil {
+			return nil, fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	return ck, nil
+}
+
+// key identifies the client of r, falling back to its IP when the
+// configured source finds nothing. A nil resolver always uses the IP.
+func (ck *clientKeyResolver) key(r *http.Request) string {
+	if ck == nil {
+		return "ip=" + clientIP(r)
+	}
+	src := ck.def
+	for i := range ck.routes {
+		if ck.routes[i].matches(r) {
+			if ck.byRoute[i] != nil {
+				src = ck.byRoute[i]
+			}
+			break
+		}
+	}
+	if key, ok := src.f(r); ok {
+		return src.name + "=" + key
+	}
+	return "ip=" + clientIP(r)
+}
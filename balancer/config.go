@@ -0,0 +1,433 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is the shape of config.json. Older configs that are just a JSON
+// array of servers are still accepted and treated as the server list.
+type Config struct {
+	Listen        string               `json:"listen"`
+	TLS           *TLSConfig           `json:"tls,omitempty"`
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty"`
+	// TrustedProxies are CIDRs of proxies whose X-Forwarded-For we believe
+	// when working out the client IP.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// AdminTokens protect /stats, the dashboard and the admin API.
+	AdminTokens []AdminToken `json:"admin_tokens"`
+	// AdminListen serves /stats, /metrics, /alerts, the dashboard and the
+	// admin API on their own address, e.g. 127.0.0.1:8001 to keep them
+	// local, instead of next to the proxied traffic on Listen.
+	AdminListen string `json:"admin_listen"`
+	// Hardening limits and normalizes requests before they are routed.
+	Hardening HardeningConfig `json:"hardening"`
+	// GRPCAdmin serves the admin API over gRPC too.
+	GRPCAdmin *GRPCAdminConfig `json:"grpc_admin,omitempty"`
+	// PoolHygieneInterval is how often the heap is checked for stale
+	// entries and repaired. Defaults to 30s.
+	PoolHygieneInterval Duration `json:"pool_hygiene_interval"`
+	// HealthCheckConcurrency is how many servers are probed at once.
+	// Defaults to 10.
+	HealthCheckConcurrency int `json:"health_check_concurrency"`
+	// HealthCheckDeadline stops a health check round from starting more
+	// probes after this long, so one slow round can't hold up the next.
+	// Defaults to 10s.
+	HealthCheckDeadline Duration `json:"health_check_deadline"`
+	// Log sets the log level, format and sampling. The -log-level and
+	// -log-format flags win over it.
+	Log LogConfig `json:"log"`
+	// LogFile sends the log to a file instead of stderr. SIGUSR1 reopens
+	// it, for logrotate.
+	LogFile string `json:"log_file"`
+	// DebugHeaders adds X-Served-By and X-LB-Duration to responses. Meant
+	// for debugging; leave it off in production.
+	DebugHeaders bool `json:"debug_headers"`
+	// HealthHistorySize is how many health transitions /stats keeps per
+	// server. Defaults to 10.
+	HealthHistorySize int `json:"health_history_size"`
+	// RequestSampleRate keeps 1 in this many requests to each server for
+	// the request tail on its dashboard page. Defaults to 10.
+	RequestSampleRate int           `json:"request_sample_rate"`
+	Alerts            *AlertsConfig `json:"alerts,omitempty"`
+	StatsD            *StatsDConfig `json:"statsd,omitempty"`
+	// The top-level pool settings configure the default pool.
+	PoolConfig
+	// Pools are more named pools. Routes send requests to them by name.
+	Pools  map[string]PoolConfig `json:"pools,omitempty"`
+	Routes []RouteConfig         `json:"routes"`
+	// ClientKey identifies clients for sticky sessions. Defaults to the
+	// client IP.
+	ClientKey *ClientKeyConfig `json:"client_key,omitempty"`
+	// RoutingScript is an expr-lang expression that can pick the backend
+	// for a request. See routing_script.go.
+	RoutingScript string `json:"routing_script,omitempty"`
+	// Plugins are Go plugins loaded at startup; see pluginapi.
+	Plugins     []PluginConfig     `json:"plugins,omitempty"`
+	XDS         *XDSConfig         `json:"xds,omitempty"`
+	Priority    *PriorityConfig    `json:"priority,omitempty"`
+	ForwardAuth *ForwardAuthConfig `json:"forward_auth,omitempty"`
+	Deadline    *DeadlineConfig    `json:"deadline,omitempty"`
+	// VersionCheck polls each backend for the version it runs.
+	VersionCheck *VersionCheckConfig `json:"version_check,omitempty"`
+	// Resources polls each backend for its CPU and memory use.
+	Resources *ResourcesConfig `json:"resources,omitempty"`
+	// Schedules are recurring maintenance and traffic split windows.
+	Schedules []ScheduleConfig `json:"schedules"`
+	// Tenants are namespaces for teams sharing the balancer. See
+	// tenants.go.
+	Tenants map[string]TenantConfig `json:"tenants,omitempty"`
+	// tenantsApplied is set once the tenants are merged into the pools,
+	// routes and tokens, which must happen only once.
+	tenantsApplied bool
+	// Registration lets backends register themselves and heartbeat. See
+	// registration.go.
+	Registration *RegistrationConfig `json:"registration,omitempty"`
+	// Startup holds off readiness until enough backends pass a probe.
+	Startup *StartupConfig `json:"startup,omitempty"`
+	// DecisionLog writes sampled backend picks to a file as JSON lines.
+	DecisionLog *DecisionLogConfig `json:"decision_log,omitempty"`
+}
+
+// PoolConfig holds the servers of a backend pool and how we talk to them.
+type PoolConfig struct {
+	Servers []ServerConfig `json:"servers"`
+	// Strategy picks how servers are ranked: "weighted_least_connections"
+	// (default), "least_connections", "ewma_latency", "least_time",
+	// "consistent_hash", "resource_aware" or the experimental "bandit".
+	Strategy   string            `json:"strategy"`
+	BackendTLS *BackendTLSConfig `json:"backend_tls,omitempty"`
+	Dialer     *DialerConfig     `json:"dialer,omitempty"`
+	// Labels apply to every server of the pool; a server's own labels
+	// win on conflicts.
+	Labels              map[string]string          `json:"labels,omitempty"`
+	AdaptiveConcurrency *AdaptiveConcurrencyConfig `json:"adaptive_concurrency,omitempty"`
+	Prewarm             *PrewarmConfig             `json:"prewarm,omitempty"`
+	Sticky              *StickyConfig              `json:"sticky,omitempty"`
+	// TrafficSplit sends a share of requests to servers with given labels.
+	TrafficSplit []TrafficSplit `json:"traffic_split,omitempty"`
+	// LazyCounters stops taking the pool lock for every connection count
+	// update; the heap is rebuilt from the counters every interval instead
+	// (e.g. "10ms"). Off by default.
+	LazyCounters Duration   `json:"lazy_counters"`
+	DNS          *DNSConfig `json:"dns,omitempty"`
+	// HealthCheck probes the pool's servers on another port or path than
+	// the one traffic goes to.
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+	// BufferSize is the size in bytes of the buffers responses are copied
+	// through. They are pooled and reused. Defaults to 32KB.
+	BufferSize int `json:"buffer_size"`
+	// Subset keeps this instance to a few servers of a large pool.
+	Subset *SubsetConfig `json:"subset,omitempty"`
+	// Bandit tunes the experimental bandit strategy.
+	Bandit *BanditConfig `json:"bandit,omitempty"`
+	// LoadPrediction counts servers whose connections are climbing as
+	// busier than they are yet.
+	LoadPrediction *LoadPredictionConfig `json:"load_prediction,omitempty"`
+	// StartUnhealthy creates the pool's servers unhealthy, so they only
+	// get traffic after passing a probe.
+	StartUnhealthy bool `json:"start_unhealthy"`
+	// FailOpen sends requests to an unhealthy server rather than
+	// answering 503 when the pool has no healthy one. See fail_open.go.
+	FailOpen bool `json:"fail_open"`
+
+	// tenant is the tenant the pool belongs to, if any.
+	tenant string
+}
+
+type ServerConfig struct {
+	Name   string            `json:"name"`
+	URL    string            `json:"url"`
+	Weight int               `json:"weight"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// HealthURL is where the server is health-checked, if not at URL.
+	HealthURL string `json:"health_url,omitempty"`
+	// Maintenance lists the server's known maintenance windows.
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+// Duration lets the config use Go duration strings like "2s" or "500ms".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// validateListen checks a listen address up front, so that an IPv6
+// address without brackets is a config error instead of a failure to
+// listen at startup. An empty address is left to the caller.
+func validateListen(field, addr string) error {
+	if addr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return fmt.Errorf("%s: IPv6 address %q must be in brackets, as in [::1]:8000", field, addr)
+		}
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	return nil
+}
+
+// ParseConfig reads a config file's contents, either a full config or just
+// an array of servers, fills in the defaults and validates it.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{Listen: ":8000"}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &c.Servers); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validate merges in the tenants, if that hasn't been done yet, and checks
+// every section of c, loading the plugins it names on the way.
+func (c *Config) validate() error {
+	if !c.tenantsApplied {
+		if err := c.applyTenants(); err != nil {
+			return err
+		}
+	}
+	if c.ProxyProtocol != nil {
+		if err := c.ProxyProtocol.validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseCIDRs(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	// Plugins first: they can add strategies the config refers to.
+	if _, err := loadPlugins(c.Plugins); err != nil {
+		return err
+	}
+	if err := c.eachPool(func(name string, pc *PoolConfig) error { return pc.validate(name) }); err != nil {
+		return err
+	}
+	seen := map[string]string{}
+	if err := c.eachPool(func(name string, pc *PoolConfig) error {
+		for _, sc := range pc.Servers {
+			if other, dup := seen[sc.Name]; dup {
+				return fmt.Errorf("server %q is in pool %s and pool %s", sc.Name, other, name)
+			}
+			seen[sc.Name] = name
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if _, err := newAdminAuth(c.AdminTokens); err != nil {
+		return err
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if err := c.Hardening.validate(); err != nil {
+		return err
+	}
+	if c.HealthCheckConcurrency < 0 || c.HealthCheckDeadline < 0 {
+		return fmt.Errorf("health_check_concurrency and health_check_deadline can't be negative")
+	}
+	if c.GRPCAdmin != nil && c.GRPCAdmin.Listen == "" {
+		return fmt.Errorf("grpc_admin: listen is required")
+	}
+	if err := validateListen("listen", c.Listen); err != nil {
+		return err
+	}
+	if err := validateListen("admin_listen", c.AdminListen); err != nil {
+		return err
+	}
+	if c.GRPCAdmin != nil {
+		if err := validateListen("grpc_admin: listen", c.GRPCAdmin.Listen); err != nil {
+			return err
+		}
+	}
+	if c.Alerts != nil {
+		if _, err := newAlerter(*c.Alerts); err != nil {
+			return err
+		}
+	}
+	var classes map[string]PriorityClassConfig
+	if c.Priority != nil {
+		classes = c.Priority.Classes
+	}
+	if err := validateRoutes(c.Routes, classes, c.Pools); err != nil {
+		return err
+	}
+	if _, err := newClientKeyResolver(c.ClientKey, c.Routes); err != nil {
+		return err
+	}
+	if c.RoutingScript != "" {
+		if _, err := newRoutingScript(c.RoutingScript); err != nil {
+			return err
+		}
+	}
+	for i := range c.Schedules {
+		if err := c.Schedules[i].validate(); err != nil {
+			return err
+		}
+	}
+	if c.XDS != nil {
+		if err := c.XDS.validate(); err != nil {
+			return err
+		}
+	}
+	if c.StatsD != nil {
+		if err := c.StatsD.validate(); err != nil {
+			return err
+		}
+	}
+	if c.VersionCheck != nil {
+		if err := c.VersionCheck.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Resources != nil {
+		if err := c.Resources.validate(); err != nil {
+			return err
+		}
+	} else if err := c.eachPool(func(name string, pc *PoolConfig) error {
+		if pc.Strategy == "resource_aware" {
+			return fmt.Errorf("pools.%s: strategy resource_aware needs a resources block to learn from", name)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if c.Deadline != nil {
+		if err := c.Deadline.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Startup != nil {
+		if err := c.Startup.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Registration != nil {
+		if err := c.Registration.validate(); err != nil {
+			return err
+		}
+	}
+	if c.DecisionLog != nil {
+		if err := c.DecisionLog.validate(); err != nil {
+			return err
+		}
+	}
+	if c.ForwardAuth != nil && c.ForwardAuth.Address == "" {
+		return fmt.Errorf("forward_auth: address is required")
+	}
+	return nil
+}
+
+// eachPool calls f for the default pool and then every named pool, by
+// name, stopping at the first error.
+func (c *Config) eachPool(f func(name string, pc *PoolConfig) error) error {
+	if err := f(defaultPoolName, &c.PoolConfig); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Pools)) {
+		pc := c.Pools[name]
+		if err := f(name, &pc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pc *PoolConfig) validate(name string) error {
+	if name != defaultPoolName {
+		if name == "" {
+			return errors.New("pools: empty pool name")
+		}
+		// These work across pools and are only read from the top level.
+		if pc.Sticky != nil || pc.Prewarm != nil {
+			return fmt.Errorf("pools.%s: sticky and prewarm can only be set at the top level", name)
+		}
+	}
+	if _, err := strategyByName(pc.Strategy); err != nil {
+		return err
+	}
+	if pc.AdaptiveConcurrency != nil {
+		if err := pc.AdaptiveConcurrency.validate(); err != nil {
+			return err
+		}
+	}
+	if pc.Bandit != nil {
+		if err := pc.Bandit.validate(); err != nil {
+			return err
+		}
+	}
+	if pc.LoadPrediction != nil {
+		if err := pc.LoadPrediction.validate(); err != nil {
+			return err
+		}
+		if pc.LazyCounters > 0 {
+			return fmt.Errorf("load_prediction needs the pool lock on every count, so it can't be used with lazy_counters")
+		}
+	}
+	if err := validateTrafficSplit(pc.TrafficSplit); err != nil {
+		return err
+	}
+	if pc.Sticky != nil {
+		if err := pc.Sticky.validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := pc.Dialer.dialer(); err != nil {
+		return err
+	}
+	if err := validateBufferSize(pc.BufferSize); err != nil {
+		return err
+	}
+	if pc.HealthCheck != nil {
+		if err := pc.HealthCheck.validate(); err != nil {
+			return err
+		}
+	}
+	if pc.Subset != nil {
+		if err := pc.Subset.validate(); err != nil {
+			return fmt.Errorf("pools.%s: %w", name, err)
+		}
+	}
+	for _, sc := range pc.Servers {
+		if err := validateServerURL(sc); err != nil {
+			return err
+		}
+		if err := validateHealthURL(sc); err != nil {
+			return err
+		}
+		for _, m := range sc.Maintenance {
+			sched := m.schedule(sc.Name)
+			if err := sched.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connStats counts one backend's connections, from the traces of the
+// requests, probes and prewarming sent to it. A connection belongs to the
+// first backend that uses it.
+type connStats struct {
+	open, inUse    atomic.Int64
+	opened, reused atomic.Int64
+
+	dials, dialNanos                            atomic.Int64
+	handshakes, handshakeNanos, handshakeErrors atomic.Int64
+}
+
+// ConnStats is the /stats view of connStats. Idle is approximate under
+// HTTP/2, where requests share a connection.
+type ConnStats struct {
+	Open   int64 `json:"open"`
+	Idle   int64 `json:"idle"`
+	Opened int64 `json:"opened"`
+	Reused int64 `json:"reused"`
+	// ReuseRatio is the share of requests that got a pooled connection.
+	ReuseRatio     float64 `json:"reuse_ratio"`
+	AvgDialMs      float64 `json:"avg_dial_ms"`
+	TLSHandshakes  int64   `json:"tls_handshakes"`
+	TLSErrors      int64   `json:"tls_handshake_errors"`
+	AvgHandshakeMs float64 `json:"avg_tls_handshake_ms"`
+}
+
+// connMetric reports one value of every backend's connStats.
+func connMetric(name, help, typ string, value func(cs *connStats) float64) *funcMetric {
+	return newFuncMetric(name, help, typ, func(b *Balancer) []metricSample {
+		var out []metricSample
+		for _, s := range b.pools.Servers() {
+			out = append(out, metricSample{[]string{"backend"}, []string{s.Name}, value(&s.conns)})
+		}
+		return out
+	})
+}
+
+var (
+	backendConns = newFuncMetric("lb_backend_connections",
+		"Open connections per backend, by state (idle, active).", "gauge", func(b *Balancer) []metricSample {
+			var out []metricSample
+			for _, s := range b.pools.Servers() {
+				idle := s.conns.idle()
+				out = append(out,
+					metricSample{[]string{"backend", "state"}, []string{s.Name, "idle"}, float64(idle)},
+					metricSample{[]string{"backend", "state"}, []string{s.Name, "active"}, float64(s.conns.open.Load() - idle)})
+			}
+			return out
+		})
+	backendConnsOpened = connMetric("lb_backend_connections_opened_total",
+		"Connections opened to each backend.", "counter", func(cs *connStats) float64 { return float64(cs.opened.Load()) })
+	backendConnsReused = connMetric("lb_backend_connections_reused_total",
+		"Requests to each backend that reused a pooled connection.", "counter", func(cs *connStats) float64 { return float64(cs.reused.Load()) })
+	backendDialSeconds = connMetric("lb_backend_dial_seconds_total",
+		"Time spent on TCP connects to each backend; divide by lb_backend_connections_opened_total for the mean.", "counter",
+		func(cs *connStats) float64 { return time.Duration(cs.dialNanos.Load()).Seconds() })
+	backendHandshakes = connMetric("lb_backend_tls_handshakes_total",
+		"Completed TLS handshakes with each backend.", "counter", func(cs *connStats) float64 { return float64(cs.handshakes.Load()) })
+	backendHandshakeErrors = connMetric("lb_backend_tls_handshake_errors_total",
+		"Failed TLS handshakes with each backend.", "counter", func(cs *connStats) float64 { return float64(cs.handshakeErrors.Load()) })
+	backendHandshakeSeconds = connMetric("lb_backend_tls_handshake_seconds_total",
+		"Time spent on completed TLS handshakes with each backend.", "counter",
+		func(cs *connStats) float64 { return time.Duration(cs.handshakeNanos.Load()).Seconds() })
+)
+
+func (cs *connStats) idle() int64 {
+	return max(cs.open.Load()-cs.inUse.Load(), 0)
+}
+
+func (cs *connStats) view() ConnStats {
+	v := ConnStats{
+		Open:          cs.open.Load(),
+		Idle:          cs.idle(),
+		Opened:        cs.opened.Load(),
+		Reused:        cs.reused.Load(),
+		TLSHandshakes: cs.handshakes.Load(),
+		TLSErrors:     cs.handshakeErrors.Load(),
+	}
+	if n := v.Opened + v.Reused; n > 0 {
+		v.ReuseRatio = float64(v.Reused) / float64(n)
+	}
+	if n := cs.dials.Load(); n > 0 {
+		v.AvgDialMs = float64(cs.dialNanos.Load()) / float64(n) / 1e6
+	}
+	if v.TLSHandshakes > 0 {
+		v.AvgHandshakeMs = float64(cs.handshakeNanos.Load()) / float64(v.TLSHandshakes) / 1e6
+	}
+	return v
+}
+
+// trackedConn is a pooled connection that knows which backend's open
+// count it is in.
+type trackedConn struct {
+	net.Conn
+	owner  atomic.Pointer[connStats]
+	closed atomic.Bool
+}
+
+// trackConns wraps a transport's dial so that its connections can be
+// counted per backend.
+func trackConns(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: c}, nil
+	}
+}
+
+// claim counts c as one of cs's open connections, unless another backend
+// already did.
+func (c *trackedConn) claim(cs *connStats) {
+	if !c.owner.CompareAndSwap(nil, cs) {
+		return
+	}
+	cs.open.Add(1)
+	cs.opened.Add(1)
+	if c.closed.Load() && c.owner.CompareAndSwap(cs, nil) {
+		cs.open.Add(-1)
+	}
+}
+
+func (c *trackedConn) Close() error {
+	if !c.closed.Swap(true) {
+		if cs := c.owner.Swap(nil); cs != nil {
+			cs.open.Add(-1)
+		}
+	}
+	return c.Conn.Close()
+}
+
+// connTracing is a backend's transport: the pool's, with a trace on every
+// request that feeds the backend's connStats.
+type connTracing struct {
+	next  http.RoundTripper
+	stats *connStats
+}
+
+func (t *connTracing) RoundTrip(req *http.Request) (*http.Response, error) {
+	cs := t.stats
+	var (
+		mu       sync.Mutex
+		dialing  = map[string]time.Time{}
+		tlsStart time.Time
+		got      atomic.Int64
+	)
+	trace := &httptrace.ClientTrace{
+		// Dials run on their own goroutines, several at once for hosts
+		// with more than one address.
+		ConnectStart: func(_, addr string) {
+			mu.Lock()
+			dialing[addr] = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			mu.Lock()
+			start, ok := dialing[addr]
+			mu.Unlock()
+			if ok && err == nil {
+				cs.dials.Add(1)
+				cs.dialNanos.Add(int64(time.Since(start)))
+			}
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mu.Lock()
+			start := tlsStart
+			mu.Unlock()
+			if err != nil {
+				cs.handshakeErrors.Add(1)
+				return
+			}
+			cs.handshakes.Add(1)
+			cs.handshakeNanos.Add(int64(time.Since(start)))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			c := info.Conn
+			if tc, ok := c.(*tls.Conn); ok {
+				c = tc.NetConn()
+			}
+			if tc, ok := c.(*trackedConn); ok {
+				tc.claim(cs)
+			}
+			if info.Reused {
+				cs.reused.Add(1)
+			}
+			got.Add(1)
+			cs.inUse.Add(1)
+		},
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	done := func() { cs.inUse.Add(-got.Swap(0)) }
+	if err != nil {
+		done()
+		return nil, err
+	}
+	body := &releaseOnClose{ReadCloser: resp.Body, release: done}
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// The proxy needs to write to an upgraded connection.
+		resp.Body = upgradedBody{body, rwc}
+	} else {
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// releaseOnClose calls release once, when the body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseOnClose) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+type upgradedBody struct {
+	*releaseOnClose
+	io.Writer
+}
@@ -0,0 +1,35 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePIDFile records our PID in path. A file left by a process that is
+// still running is an error, one left by a crash is overwritten. Removing
+// it is up to the caller's shutdown path: the prefork supervisor only may
+// once its workers are gone.
+func writePIDFile(path string) error {
+	if b, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("%s: already running as pid %d", path, pid)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removePIDFile deletes path if it still holds our PID.
+func removePIDFile(path string) {
+	b, err := os.ReadFile(path)
+	if err == nil && strings.TrimSpace(string(b)) == strconv.Itoa(os.Getpid()) {
+		os.Remove(path)
+	}
+}
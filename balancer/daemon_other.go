@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package balancer
+
+func runAsService() bool {
+	if *daemon || *service != "" {
+		fatal("-daemon and -service are not supported on this platform")
+	}
+	return false
+}
+
+func processAlive(int) bool { return false }
+
+func removeOnExit(string) {}
@@ -0,0 +1,68 @@
+//go:build unix
+
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// daemonizedEnv marks the background copy started by -daemon.
+const daemonizedEnv = "LB_DAEMONIZED"
+
+// runAsService handles -daemon: the foreground process starts a detached
+// copy of itself in a new session and exits. It returns true when this
+// process is done.
+func runAsService() bool {
+	if *service != "" {
+		fatal("-service is only supported on Windows; use -daemon or systemd")
+	}
+	if !*daemon || os.Getenv(daemonizedEnv) == "1" {
+		return false
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error starting daemon", "err", err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		fatal("Error starting daemon", "err", err)
+	}
+	defer devNull.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonizedEnv+"=1")
+	// Without log_file, the daemon's log goes nowhere.
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = devNull, devNull, devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fatal("Error starting daemon", "err", err)
+	}
+	fmt.Printf("Started in the background as pid %d\n", cmd.Process.Pid)
+	return true
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// removeOnExit removes the pid file and exits when we're asked to stop,
+// which is how a single balancer process shuts down. The prefork
+// supervisor has its own path, which waits for the workers first.
+func removeOnExit(path string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-ch
+		slog.Info("👋 Exiting", "signal", sig.String())
+		removePIDFile(path)
+		os.Exit(0)
+	}()
+}
@@ -0,0 +1,132 @@
+//go:build windows
+
+package balancer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "loadbalancer"
+
+// runAsService runs us under the Windows service manager when started by
+// it, and handles -service install/uninstall. It returns true when this
+// process is done.
+func runAsService() bool {
+	if *daemon {
+		fatal("-daemon is not supported on Windows; use -service install")
+	}
+	switch *service {
+	case "":
+	case "install":
+		if err := installService(); err != nil {
+			fatal("Error installing service", "err", err)
+		}
+		fmt.Printf("Installed service %s\n", serviceName)
+		return true
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			fatal("Error removing service", "err", err)
+		}
+		fmt.Printf("Removed service %s\n", serviceName)
+		return true
+	default:
+		fatal("Unknown -service, want install or uninstall", "service", *service)
+	}
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	if err := svc.Run(serviceName, windowsService{}); err != nil {
+		fatal("Service failed", "err", err)
+	}
+	return true
+}
+
+type windowsService struct{}
+
+func (windowsService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go run()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for c := range req {
+		switch c.Cmd {
+		case svc.Interrogate:
+			status <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			if *pidFile != "" {
+				removePIDFile(*pidFile)
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// installService registers the current binary with the flags it was given,
+// minus -service, and with the config path made absolute: services start
+// in the system directory.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	config, err := filepath.Abs(*configPath)
+	if err != nil {
+		return err
+	}
+	args := []string{"-config", config}
+	if *pidFile != "" {
+		pid, err := filepath.Abs(*pidFile)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-pidfile", pid)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "DSA Load Balancer",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, 60)
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return s.Delete()
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// removeOnExit is handled by the service's Stop request on Windows.
+func removeOnExit(string) {}
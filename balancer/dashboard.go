@@ -0,0 +1,186 @@
+package balancer
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// dashboardFiles holds the dashboard templates. They are compiled in, so
+// the binary still runs from anywhere on its own.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardPages are the dashboard's pages, each its own file rendered
+// inside layout.html.
+var dashboardPages = map[string]*template.Template{
+	"index":  parseDashboardPage("index.html"),
+	"config": parseDashboardPage("config.html"),
+	"server": parseDashboardPage("server.html"),
+}
+
+func parseDashboardPage(name string) *template.Template {
+	return template.Must(template.ParseFS(dashboardFiles, "dashboard/layout.html", "dashboard/"+name))
+}
+
+// dashboardView is what a page template gets.
+type dashboardView struct {
+	Page, Title string
+	// Token is the ?token= the page was opened with, passed on in links.
+	Token string
+	Data  any
+}
+
+// renderDashboard renders page into a buffer first, so that a template
+// error is a clean 500 rather than half a page.
+func renderDashboard(w http.ResponseWriter, r *http.Request, page, title string, data any) {
+	var buf bytes.Buffer
+	view := dashboardView{Page: page, Title: title, Token: r.URL.Query().Get("token"), Data: data}
+	if err := dashboardPages[page].ExecuteTemplate(&buf, "layout", view); err != nil {
+		slog.Error("❌ Failed to render dashboard", "page", page, "error", err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// dashboardState is the data the servers page is first drawn from, so
+// it shows something before its first poll. The page fetches /stats and
+// /stats/routes with its own query string after that, so the filters of
+// /stats work on it too.
+type dashboardState struct {
+	Stats  []ServerStats `json:"stats"`
+	Routes []RouteStats  `json:"routes"`
+	Error  string        `json:"error,omitempty"`
+}
+
+func (b *Balancer) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	state := dashboardState{Routes: b.routeStats(callerTenant(r))}
+	var err error
+	if state.Stats, _, err = b.queryStats(r); err != nil {
+		state.Error = err.Error()
+	}
+	renderDashboard(w, r, "index", "Servers", state)
+}
+
+// serverPageState is what a server's page is first drawn from, and what
+// its event stream sends every dashboardStatsInterval, without samples.
+type serverPageState struct {
+	Stats      ServerStats     `json:"stats"`
+	Errors     []lastError     `json:"errors"`
+	Samples    []RequestSample `json:"samples,omitempty"`
+	SampleRate int             `json:"sample_rate,omitempty"`
+}
+
+// dashboardStatsInterval is how often a server page's event stream sends
+// the server's stats.
+const dashboardStatsInterval = 2 * time.Second
+
+// dashboardServerHandler shows one server: its health history, latency,
+// recent errors and sampled requests.
+func (b *Balancer) dashboardServerHandler(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	renderDashboard(w, r, "server", s.Name, serverPageState{
+		Stats: serverStats(s), Errors: s.RecentErrors(), Samples: s.tail.recent(), SampleRate: requestSampleRate,
+	})
+}
+
+// dashboardEventsHandler streams a server's sampled requests as
+// server-sent "request" events, and its stats as "stats" events, until
+// the client goes away.
+func (b *Balancer) dashboardEventsHandler(w http.ResponseWriter, r *http.Request) {
+	s := b.adminServer(w, r)
+	if s == nil {
+		return
+	}
+	samples, stop := s.tail.watch()
+	defer stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+	send := func(event string, v any) error {
+		data, _ := json.Marshal(v)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	// Sent at once, so the client knows the stream is up.
+	if send("stats", serverPageState{Stats: serverStats(s), Errors: s.RecentErrors()}) != nil {
+		return
+	}
+	tick := time.NewTicker(dashboardStatsInterval)
+	defer tick.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case sm := <-samples:
+			err = send("request", sm)
+		case <-tick.C:
+			err = send("stats", serverPageState{Stats: serverStats(s), Errors: s.RecentErrors()})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// poolView is one pool on the config page: its live strategy and members,
+// and the rest of its settings as JSON.
+type poolView struct {
+	Name, Strategy string
+	Servers        []ServerStats
+	Settings       string
+}
+
+// dashboardConfigHandler shows the pools in effect, including those the
+// admin API created or changed since startup.
+func (b *Balancer) dashboardConfigHandler(w http.ResponseWriter, r *http.Request) {
+	views := []poolView{}
+	for _, p := range b.pools.Pools() {
+		if !p.visibleTo(callerTenant(r)) {
+			continue
+		}
+		v := poolView{Name: p.name, Strategy: p.Strategy().Name()}
+		for _, s := range b.pools.Members(p) {
+			v.Servers = append(v.Servers, serverStats(s))
+		}
+		v.Settings = poolSettings(p.config)
+		views = append(views, v)
+	}
+	renderDashboard(w, r, "config", "Config", views)
+}
+
+// poolSettings is c as indented JSON, without the servers the config page
+// lists live, and with the password of an egress proxy URL masked.
+func poolSettings(c PoolConfig) string {
+	if c.Dialer != nil && c.Dialer.Proxy != "" {
+		d := *c.Dialer
+		if u, err := url.Parse(d.Proxy); err == nil {
+			d.Proxy = u.Redacted()
+		} else {
+			d.Proxy = "xxxxx"
+		}
+		c.Dialer = &d
+	}
+	var m map[string]any
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &m)
+	delete(m, "servers")
+	data, _ = json.MarshalIndent(m, "", "  ")
+	return string(data)
+}
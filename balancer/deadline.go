@@ -0,0 +1,156 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeadlineConfig makes us honour the client's time budget. A request
+// carrying X-Request-Timeout or grpc-timeout gets that budget, minus our
+// own overhead, as the deadline of the backend request, and the headers
+// sent upstream are rewritten to what is left of it.
+type DeadlineConfig struct {
+	// Overhead is taken off the budget for the work we do around the
+	// backend call. Defaults to 5ms.
+	Overhead Duration `json:"overhead"`
+	// Max caps the budget a client can ask for. Zero means no cap.
+	Max Duration `json:"max"`
+}
+
+func (c *DeadlineConfig) validate() error {
+	if c.Overhead < 0 || c.Max < 0 {
+		return errors.New("deadline: overhead and max must not be negative")
+	}
+	return nil
+}
+
+// requestBudget reads the client's budget. X-Request-Timeout is seconds
+// ("2.5") or a Go duration ("2500ms"); grpc-timeout is the gRPC wire
+// format ("2500m"). Negative, non-finite and unreadable budgets are
+// ignored, and ones too long for a Duration are capped.
+func requestBudget(h http.Header) (time.Duration, bool) {
+	if v := h.Get("X-Request-Timeout"); v != "" {
+		// ParseFloat would also take hex, NaN and Inf.
+		if strings.Trim(v, "0123456789.eE+-") == "" {
+			secs, err := strconv.ParseFloat(v, 64)
+			switch {
+			case err != nil || secs < 0:
+			case secs >= maxBudgetSeconds:
+				return math.MaxInt64, true
+			default:
+				return time.Duration(secs * float64(time.Second)), true
+			}
+		} else if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d, true
+		}
+	}
+	if v := h.Get("Grpc-Timeout"); v != "" {
+		return parseGRPCTimeout(v)
+	}
+	return 0, false
+}
+
+// maxBudgetSeconds is about the longest Duration, in whole seconds.
+const maxBudgetSeconds = float64(math.MaxInt64 / time.Second)
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+	'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+}
+
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	if n > math.MaxInt64/int64(unit) {
+		return math.MaxInt64, true
+	}
+	return time.Duration(n) * unit, true
+}
+
+// formatGRPCTimeout uses the finest unit that fits the 8 digit limit.
+func formatGRPCTimeout(d time.Duration) string {
+	for _, u := range []struct {
+		unit byte
+		d    time.Duration
+	}{{'u', time.Microsecond}, {'m', time.Millisecond}, {'S', time.Second}, {'M', time.Minute}} {
+		if n := d / u.d; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + string(u.unit)
+		}
+	}
+	return strconv.FormatInt(int64(d/time.Hour), 10) + "H"
+}
+
+// Wrap puts the budget on the request context. Requests whose budget is
+// gone before we start are refused with 504.
+func (c *DeadlineConfig) Wrap(next http.Handler) http.Handler {
+	overhead := 5 * time.Millisecond
+	if c.Overhead > 0 {
+		overhead = time.Duration(c.Overhead)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		budget, ok := requestBudget(r.Header)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.Max > 0 && budget > time.Duration(c.Max) {
+			budget = time.Duration(c.Max)
+		}
+		if budget-overhead <= 0 {
+			refuseSpentBudget(w, fmt.Errorf("budget of %s leaves nothing after %s overhead", budget, overhead))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), budget-overhead)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// budgetSpent refuses r if its deadline passed while it waited with us,
+// e.g. in the admission queue or on forward auth.
+func budgetSpent(w http.ResponseWriter, r *http.Request) bool {
+	if r.Context().Err() != context.DeadlineExceeded {
+		return false
+	}
+	refuseSpentBudget(w, errors.New("budget ran out before a backend was picked"))
+	return true
+}
+
+func refuseSpentBudget(w http.ResponseWriter, err error) {
+	perr := &ProxyError{Kind: ErrDeadlineExceeded, Err: err}
+	countError(perr)
+	perr.log()
+	http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+}
+
+// propagateBudget rewrites the budget headers on the way upstream to the
+// time left, so the backend doesn't work for an answer nobody will wait
+// for.
+func propagateBudget(r *http.Request) {
+	dl, ok := r.Context().Deadline()
+	if !ok {
+		return
+	}
+	left := max(time.Until(dl), 0)
+	if r.Header.Get("X-Request-Timeout") != "" {
+		r.Header.Set("X-Request-Timeout", strings.TrimSuffix(strings.TrimRight(strconv.FormatFloat(left.Seconds(), 'f', 3, 64), "0"), "."))
+	}
+	if r.Header.Get("Grpc-Timeout") != "" {
+		r.Header.Set("Grpc-Timeout", formatGRPCTimeout(left))
+	}
+}
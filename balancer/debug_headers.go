@@ -0,0 +1,40 @@
+package balancer
+
+import (
+	"net/http"
+	"time"
+)
+
+// debugHeaderWriter adds X-Served-By and X-LB-Duration to the response
+// just before its headers go out. The duration is measured up to the
+// backend's response headers, which is what matters for a slow request.
+type debugHeaderWriter struct {
+	http.ResponseWriter
+	server      string
+	start       time.Time
+	wroteHeader bool
+}
+
+func (w *debugHeaderWriter) WriteHeader(code int) {
+	// 1xx responses are informational; the headers belong on the final one.
+	if !w.wroteHeader && code >= 200 {
+		w.wroteHeader = true
+		h := w.Header()
+		h.Set("X-Served-By", w.server)
+		h.Set("X-LB-Duration", time.Since(w.start).Round(time.Microsecond).String())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *debugHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController (used by ReverseProxy for flushing
+// and hijacking) reach the real writer.
+func (w *debugHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,186 @@
+package balancer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DecisionLogConfig writes a sample of the balancer's backend picks to a
+// file, one JSON object per line, for analysing balance quality offline.
+type DecisionLogConfig struct {
+	// Path is the file, appended to. SIGUSR1 reopens it, like log_file.
+	Path string `json:"path"`
+	// SampleRate logs 1 in this many picks. Defaults to 100.
+	SampleRate int `json:"sample_rate"`
+	// Candidates is how many of the pool's least loaded servers each line
+	// lists. Defaults to 5.
+	Candidates int `json:"candidates"`
+}
+
+func (c *DecisionLogConfig) validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("decision_log: path is required")
+	}
+	if c.SampleRate < 0 || c.Candidates < 0 {
+		return fmt.Errorf("decision_log: sample_rate and candidates can't be negative")
+	}
+	return nil
+}
+
+func (c *DecisionLogConfig) sampleRate() uint64 {
+	if c.SampleRate > 0 {
+		return uint64(c.SampleRate)
+	}
+	return 100
+}
+
+func (c *DecisionLogConfig) candidates() int {
+	if c.Candidates > 0 {
+		return c.Candidates
+	}
+	return 5
+}
+
+// decisionLogBuffer is how many lines can wait for the writer before new
+// ones are dropped.
+const decisionLogBuffer = 1024
+
+var decisionsDropped = newCounterVec("lb_decision_log_dropped_total",
+	"Decision log lines dropped because the writer fell behind.")
+
+// decision is one line of the decision log. Candidates are the pool's
+// least loaded servers taking traffic when the pick was made, as its
+// strategy ranks them, plus the chosen one if a sticky pin, override or
+// routing script went elsewhere.
+type decision struct {
+	Time       time.Time           `json:"ts"`
+	Pool       string              `json:"pool"`
+	Strategy   string              `json:"strategy"`
+	Via        string              `json:"via"`
+	Chosen     string              `json:"chosen"`
+	Candidates []decisionCandidate `json:"candidates"`
+}
+
+type decisionCandidate struct {
+	Server string  `json:"server"`
+	Load   float64 `json:"load"`
+	Active int     `json:"active"`
+	Weight int     `json:"weight"`
+}
+
+// decisionLog samples picks on the request path and hands them to a
+// writer goroutine, so a slow disk never holds up a request. Like
+// poolSubscribers, it drops lines rather than block.
+type decisionLog struct {
+	cfg     DecisionLogConfig
+	rate    uint64
+	seq     atomic.Uint64
+	entries chan decision
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newDecisionLog(c DecisionLogConfig) (*decisionLog, error) {
+	d := &decisionLog{cfg: c, rate: c.sampleRate(), entries: make(chan decision, decisionLogBuffer)}
+	if err := d.reopen(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// record samples the pick of s for a request, via being what picked it.
+// The picks not sampled cost an atomic add.
+func (d *decisionLog) record(s *Server, via string) {
+	if d == nil || d.seq.Add(1)%d.rate != 0 {
+		return
+	}
+	p := s.pool
+	e := decision{Time: time.Now(), Pool: p.name, Strategy: p.Strategy().Name(), Via: via, Chosen: s.Name,
+		Candidates: p.candidates(d.cfg.candidates(), s)}
+	select {
+	case d.entries <- e:
+	default:
+		decisionsDropped.Inc()
+	}
+}
+
+// candidates lists p's n least loaded servers, and chosen if it isn't
+// among them, with their loads in one snapshot. Load is what the heap
+// ranks by, so with lazy counters it can trail Active.
+func (p *ServerPool) candidates(n int, chosen *Server) []decisionCandidate {
+	servers := p.GetNextServers(n)
+	if !slices.Contains(servers, chosen) {
+		servers = append(servers, chosen)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	st := p.servers.strategy
+	if st == nil {
+		st = defaultStrategy
+	}
+	out := make([]decisionCandidate, 0, len(servers))
+	for _, s := range servers {
+		out = append(out, decisionCandidate{Server: s.Name, Load: st.Load(s),
+			Active: s.ActiveConnections + int(s.pendingActive.Load()), Weight: s.Weight})
+	}
+	return out
+}
+
+// run writes the sampled picks until done, then writes what is still
+// queued and closes the file.
+func (d *decisionLog) run(done <-chan struct{}) {
+	defer func() {
+		d.mu.Lock()
+		d.file.Close()
+		d.mu.Unlock()
+	}()
+	for {
+		select {
+		case e := <-d.entries:
+			d.write(e)
+		case <-done:
+			for {
+				select {
+				case e := <-d.entries:
+					d.write(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *decisionLog) write(e decision) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.file.Write(append(line, '\n')); err != nil {
+		slog.Warn("⚠️ Writing the decision log failed", "path", d.cfg.Path, "err", err)
+	}
+}
+
+// reopen opens the file again, for logrotate.
+func (d *decisionLog) reopen() error {
+	f, err := os.OpenFile(d.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("decision_log: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file = f
+	return nil
+}
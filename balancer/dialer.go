@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DialerConfig tunes how a pool opens TCP connections to its backends. The
+// zero value matches http.DefaultTransport.
+type DialerConfig struct {
+	// Timeout bounds connection setup. Defaults to 30s.
+	Timeout Duration `json:"timeout"`
+	// KeepAliveIdle is how long a connection sits idle before the first
+	// keepalive probe, KeepAliveInterval the time between probes and
+	// KeepAliveCount how many unanswered probes drop the connection.
+	// Defaults are 30s/30s/OS default. A negative idle disables keepalive.
+	KeepAliveIdle     Duration `json:"keep_alive_idle"`
+	KeepAliveInterval Duration `json:"keep_alive_interval"`
+	KeepAliveCount    int      `json:"keep_alive_count"`
+	// HappyEyeballs races IPv6 and IPv4 when a name has both (RFC 6555).
+	// On by default; set false to try addresses strictly in order.
+	HappyEyeballs *bool `json:"happy_eyeballs"`
+	// FallbackDelay is how long to wait on the first family before racing
+	// the other one. Defaults to 300ms.
+	FallbackDelay Duration `json:"fallback_delay"`
+	// Interface binds outgoing connections to a network interface
+	// (SO_BINDTODEVICE, Linux only; needs CAP_NET_RAW).
+	Interface string `json:"interface"`
+	// Proxy sends connections to the backends through an egress proxy:
+	// socks5://[user:pass@]host:port (also an SSH tunnel's -D port), or
+	// http:// or https:// for a proxy that takes CONNECT.
+	Proxy string `json:"proxy"`
+}
+
+func (c *DialerConfig) dialer() (*net.Dialer, error) {
+	d := &net.Dialer{
+		Timeout: 30 * time.Second,
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     30 * time.Second,
+			Interval: 30 * time.Second,
+			Count:    -1,
+		},
+	}
+	if c == nil {
+		return d, nil
+	}
+	if c.Timeout > 0 {
+		d.Timeout = time.Duration(c.Timeout)
+	}
+	switch {
+	case c.KeepAliveIdle < 0:
+		d.KeepAlive = -1
+		d.KeepAliveConfig = net.KeepAliveConfig{}
+	case c.KeepAliveIdle > 0:
+		d.KeepAliveConfig.Idle = time.Duration(c.KeepAliveIdle)
+	}
+	if c.KeepAliveInterval > 0 {
+		d.KeepAliveConfig.Interval = time.Duration(c.KeepAliveInterval)
+	}
+	if c.KeepAliveCount > 0 {
+		d.KeepAliveConfig.Count = c.KeepAliveCount
+	}
+	if c.FallbackDelay > 0 {
+		d.FallbackDelay = time.Duration(c.FallbackDelay)
+	}
+	if c.HappyEyeballs != nil && !*c.HappyEyeballs {
+		d.FallbackDelay = -1
+	}
+	if c.Interface != "" {
+		control, err := bindToDevice(c.Interface)
+		if err != nil {
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+		d.Control = control
+	}
+	if _, err := c.proxyURL(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package balancer
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindToDevice(string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("interface binding is only supported on Linux")
+}
@@ -0,0 +1,332 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DNSConfig caches backend host name lookups for TTL instead of resolving
+// on every new connection, and re-resolves straight away when dialing all
+// cached addresses fails. Addresses that failed to connect lately are
+// tried last.
+type DNSConfig struct {
+	// TTL is how long a lookup is reused. Defaults to 30s.
+	TTL Duration `json:"ttl"`
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// addrState is how connecting to one resolved address went lately.
+type addrState struct {
+	failures    int // in a row
+	lastError   string
+	lastFailure time.Time
+	// retryAt ends the backoff: until then the address is tried last.
+	retryAt time.Time
+}
+
+// addrBackoff is how long an address is tried last after failures
+// failed connects in a row: 5s, doubling up to 5m.
+func addrBackoff(failures int) time.Duration {
+	return min(5*time.Second<<min(failures-1, 6), 5*time.Minute)
+}
+
+var dialFailures = newCounterVec("lb_backend_dial_failures_total",
+	"Failed connects to the resolved addresses of backend host names (with dns), by host and address.", "host", "address")
+
+type dnsCache struct {
+	ttl      time.Duration
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	onChange func() // e.g. drop idle connections to addresses that went away
+	// fallbackDelay is the dialer's Happy Eyeballs head start for the
+	// first address family; negative tries all addresses in order.
+	fallbackDelay time.Duration
+	// timeout bounds a dial over all addresses when the caller's context
+	// doesn't, like the dialer's own Timeout.
+	timeout time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	// states tracks the addresses that failed, by IP.
+	states map[string]*addrState
+}
+
+func newDNSCache(c DNSConfig, d *net.Dialer) *dnsCache {
+	ttl := time.Duration(c.TTL)
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	fallback := d.FallbackDelay
+	if fallback == 0 {
+		fallback = 300 * time.Millisecond
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &dnsCache{
+		ttl:           ttl,
+		lookup:        net.DefaultResolver.LookupHost,
+		dial:          d.DialContext,
+		fallbackDelay: fallback,
+		timeout:       timeout,
+		now:           time.Now,
+		entries:       make(map[string]dnsEntry),
+		states:        make(map[string]*addrState),
+	}
+}
+
+// resolve returns the cached addresses for host, looking them up if the
+// entry is missing, expired or force is set.
+func (dc *dnsCache) resolve(ctx context.Context, host string, force bool) ([]string, error) {
+	dc.mu.Lock()
+	e, ok := dc.entries[host]
+	dc.mu.Unlock()
+	if ok && !force && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, err := dc.lookup(ctx, host)
+	if err != nil {
+		if ok {
+			// Keep using what we had rather than failing every request
+			// while DNS is down.
+			slog.Warn("⚠️ Resolving failed, using cached addresses", "host", host, "err", err)
+			return e.addrs, nil
+		}
+		return nil, err
+	}
+	dc.mu.Lock()
+	dc.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(dc.ttl)}
+	for _, ip := range e.addrs {
+		if !slices.Contains(addrs, ip) {
+			delete(dc.states, ip)
+		}
+	}
+	dc.mu.Unlock()
+	if ok && !slices.Equal(e.addrs, addrs) {
+		slog.Info("🔀 Host resolves to new addresses", "host", host, "addrs", addrs)
+		if dc.onChange != nil {
+			dc.onChange()
+		}
+	}
+	return addrs, nil
+}
+
+func (dc *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if _, perr := netip.ParseAddr(host); err != nil || perr == nil {
+		return dc.dial(ctx, network, addr)
+	}
+	addrs, err := dc.resolve(ctx, host, false)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dc.dialAny(ctx, network, host, addrs, port)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	// Every cached address failed: the backend may have moved.
+	fresh, rerr := dc.resolve(ctx, host, true)
+	if rerr != nil || slices.Equal(fresh, addrs) {
+		return nil, err
+	}
+	return dc.dialAny(ctx, network, host, fresh, port)
+}
+
+// order puts the addresses in backoff after the others, soonest retry
+// first, and otherwise keeps the resolver's order.
+func (dc *dnsCache) order(addrs []string) []string {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	now := dc.now()
+	retryAt := func(ip string) time.Time {
+		if st := dc.states[ip]; st != nil && now.Before(st.retryAt) {
+			return st.retryAt
+		}
+		return time.Time{}
+	}
+	sorted := slices.Clone(addrs)
+	slices.SortStableFunc(sorted, func(a, b string) int { return retryAt(a).Compare(retryAt(b)) })
+	return sorted
+}
+
+// record notes how connecting to ip went. Dials given up because another
+// address won the race, or the request went away, don't count.
+func (dc *dnsCache) record(ctx context.Context, host, ip string, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if err == nil {
+		delete(dc.states, ip)
+		return
+	}
+	st := dc.states[ip]
+	if st == nil {
+		st = &addrState{}
+		dc.states[ip] = st
+	}
+	now := dc.now()
+	st.failures++
+	st.lastError, st.lastFailure = err.Error(), now
+	st.retryAt = now.Add(addrBackoff(st.failures))
+	dialFailures.Inc(host, ip)
+	if st.failures == 1 {
+		slog.Warn("⚠️ Address of a backend failed to connect, trying it last for now", "host", host, "addr", ip, "err", err)
+	}
+}
+
+// AddrStats is one resolved address of a backend's host name in /stats.
+type AddrStats struct {
+	Address     string     `json:"address"`
+	Failures    int        `json:"consecutive_failures"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure_time,omitempty"`
+	// RetryAt is set while the address is tried last.
+	RetryAt *time.Time `json:"backoff_until,omitempty"`
+}
+
+// host is the host name or address in s's URL.
+func (s *Server) host() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// addresses reports host's cached addresses in resolver order, or nil
+// when host is not cached.
+func (dc *dnsCache) addresses(host string) []AddrStats {
+	if dc == nil {
+		return nil
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	now := dc.now()
+	var out []AddrStats
+	for _, ip := range dc.entries[host].addrs {
+		as := AddrStats{Address: ip}
+		if st := dc.states[ip]; st != nil {
+			as.Failures, as.LastError = st.failures, st.lastError
+			as.LastFailure = &st.lastFailure
+			if now.Before(st.retryAt) {
+				as.RetryAt = &st.retryAt
+			}
+		}
+		out = append(out, as)
+	}
+	return out
+}
+
+// dialAny connects to one of host's addrs, those in backoff last. Like
+// net.Dialer, for a dual-stack host it tries the first address's family
+// and, if that hasn't connected after fallbackDelay, races the other
+// family, so a broken IPv6 path doesn't stall every new connection.
+func (dc *dnsCache) dialAny(ctx context.Context, network, host string, addrs []string, port string) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dc.timeout)
+		defer cancel()
+	}
+	addrs = dc.order(addrs)
+	primary, fallback := splitFamilies(addrs)
+	if len(fallback) == 0 || dc.fallbackDelay < 0 {
+		return dc.dialSerial(ctx, network, host, addrs, port)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	race := func(addrs []string) {
+		conn, err := dc.dialSerial(ctx, network, host, addrs, port)
+		results <- result{conn, err}
+	}
+	go race(primary)
+	timer := time.NewTimer(dc.fallbackDelay)
+	defer timer.Stop()
+	var errs []error
+	for pending, started := 1, false; pending > 0; {
+		select {
+		case <-timer.C:
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				// A loser that connected anyway is closed once it reports.
+				for ; pending > 0; pending-- {
+					if late := <-results; late.conn != nil {
+						late.conn.Close()
+					}
+				}
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+		}
+		if !started {
+			started = true
+			pending++
+			go race(fallback)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// splitFamilies splits addrs into those of the first address's family and
+// the rest, keeping their order.
+func splitFamilies(addrs []string) (primary, fallback []string) {
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	first, err := netip.ParseAddr(addrs[0])
+	for _, a := range addrs {
+		if ip, perr := netip.ParseAddr(a); err == nil && perr == nil && ip.Is4() != first.Is4() {
+			fallback = append(fallback, a)
+		} else {
+			primary = append(primary, a)
+		}
+	}
+	return primary, fallback
+}
+
+// dialSerial tries addrs in order. Like net.Dialer, each gets an equal
+// share of the time left (at least 2s), so one address that drops
+// packets doesn't use up the whole timeout.
+func (dc *dnsCache) dialSerial(ctx context.Context, network, host string, addrs []string, port string) (net.Conn, error) {
+	var errs []error
+	for i, ip := range addrs {
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok && i < len(addrs)-1 {
+			share := max(time.Until(deadline)/time.Duration(len(addrs)-i), 2*time.Second)
+			actx, cancel = context.WithTimeout(ctx, share)
+		}
+		conn, err := dc.dial(actx, network, net.JoinHostPort(ip, port))
+		cancel()
+		dc.record(ctx, host, ip, err)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
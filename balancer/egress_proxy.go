@@ -0,0 +1,125 @@
+package balancer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// proxyURL parses Proxy. It is nil without one.
+func (c *DialerConfig) proxyURL() (*url.URL, error) {
+	if c == nil || c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("dialer: proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf("dialer: proxy %q: scheme must be socks5, http or https", c.Proxy)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("dialer: proxy %q has no host", c.Proxy)
+	}
+	return u, nil
+}
+
+// egressDialer dials through the proxy at u: SOCKS5, or an HTTP(S) proxy
+// with CONNECT. d connects to the proxy itself.
+func egressDialer(u *url.URL, d *net.Dialer) (dialFunc, error) {
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return connectDialer(u, d), nil
+	}
+	pd, err := proxy.FromURL(u, d)
+	if err != nil {
+		return nil, fmt.Errorf("dialer: proxy: %w", err)
+	}
+	return pd.(proxy.ContextDialer).DialContext, nil
+}
+
+// connectDialer opens a tunnel to each backend with an HTTP CONNECT
+// request, authenticating with the URL's user info if it has any.
+func connectDialer(u *url.URL, d *net.Dialer) dialFunc {
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		proxyAddr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+	var auth string
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass))
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("dial proxy %s: %w", proxyAddr, err)
+		}
+		// Unblocks the handshake and CONNECT when ctx ends first.
+		stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+		c, err := connect(ctx, conn, u, addr, auth)
+		if !stop() || err != nil {
+			conn.Close()
+			if err == nil {
+				err = ctx.Err()
+			}
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+func connect(ctx context.Context, conn net.Conn, u *url.URL, addr, auth string) (net.Conn, error) {
+	if u.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", u.Host, err)
+		}
+		conn = tc
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if auth != "" {
+		req.Header.Set("Proxy-Authorization", auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", u.Host, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", u.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", u.Host, addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		// The backend spoke first; keep what was read along with the
+		// response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
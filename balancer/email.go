@@ -0,0 +1,55 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type EmailConfig struct {
+	// SMTPAddr is host:port of the mail server, e.g. "smtp.example.com:587".
+	// STARTTLS is used when the server offers it.
+	SMTPAddr   string         `json:"smtp_addr"`
+	Username   string         `json:"username"`
+	Password   string         `json:"password"`
+	From       string         `json:"from"`
+	To         []string       `json:"to"`
+	Severities severityFilter `json:"severities"`
+}
+
+type emailNotifier struct {
+	cfg  EmailConfig
+	auth smtp.Auth
+}
+
+func newEmailNotifier(c EmailConfig) (*emailNotifier, error) {
+	if c.SMTPAddr == "" || c.From == "" || len(c.To) == 0 {
+		return nil, fmt.Errorf("smtp_addr, from and to are required")
+	}
+	host, _, err := net.SplitHostPort(c.SMTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp_addr: %w", err)
+	}
+	n := &emailNotifier{cfg: c}
+	if c.Username != "" {
+		n.auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) Notify(al Alert) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [%s] %s\r\n", strings.ToUpper(al.Severity), al.Message)
+	fmt.Fprintf(&msg, "Date: %s\r\n", al.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nKind: %s\r\nKey: %s\r\nTime: %s\r\n",
+		al.Message, al.Kind, al.Key, al.Time.Format(time.RFC3339))
+	if err := smtp.SendMail(n.cfg.SMTPAddr, n.auth, n.cfg.From, n.cfg.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("email via %s: %w", n.cfg.SMTPAddr, err)
+	}
+	return nil
+}
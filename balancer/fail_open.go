@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// failOpenHeader marks responses from a backend picked by fail_open.
+const failOpenHeader = "X-LB-Fail-Open"
+
+var failOpenRequests = newCounterVec("lb_fail_open_requests_total",
+	"Requests sent to an unhealthy backend because its pool had no healthy one.", "pool")
+
+// failOpenServer picks the server of p to try when none is healthy: the
+// unhealthy one that passed a probe most recently, so has been failing
+// for the shortest time. Servers an operator or schedule took out stay
+// out. It returns nil if there is none, or it is at its concurrency
+// limit.
+func (b *Balancer) failOpenServer(p *ServerPool) *Server {
+	var best *Server
+	var bestSuccess int64
+	bestFailures := 0
+	for _, s := range b.pools.Members(p) {
+		if s.State() != StateUnhealthy {
+			continue
+		}
+		_, success, failures := s.checkFreshness()
+		ns := success.UnixNano()
+		if success.IsZero() {
+			ns = 0
+		}
+		if best == nil || ns > bestSuccess || (ns == bestSuccess && failures < bestFailures) {
+			best, bestSuccess, bestFailures = s, ns, failures
+		}
+	}
+	if best == nil || !best.limiter.Acquire() {
+		return nil
+	}
+	return best
+}
+
+// failOpen is what ForwardRequest does for a pool with fail_open and no
+// healthy server: pick one anyway and say so in a response header.
+func (b *Balancer) failOpen(w http.ResponseWriter, p *ServerPool) *Server {
+	s := b.failOpenServer(p)
+	if s == nil {
+		return nil
+	}
+	failOpenRequests.Inc(p.name)
+	slog.Warn("⚠️ No healthy backend, failing open to an unhealthy one", "pool", p.name, "server", s.Name)
+	w.Header().Set(failOpenHeader, s.Name)
+	return s
+}
@@ -0,0 +1,151 @@
+package balancer
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ForwardAuthConfig enables nginx auth_request / Traefik forwardAuth style
+// authentication: every request's headers are sent to Address first and the
+// request is only proxied if the auth service answers 2xx.
+type ForwardAuthConfig struct {
+	Address string   `json:"address"`
+	Timeout Duration `json:"timeout"`
+	// AuthRequestHeaders limits which client headers are sent to the auth
+	// service. Empty means all of them.
+	AuthRequestHeaders []string `json:"auth_request_headers"`
+	// AuthResponseHeaders are copied from a 2xx auth response onto the
+	// request sent upstream (e.g. X-User, X-Roles).
+	AuthResponseHeaders []string `json:"auth_response_headers"`
+}
+
+// Hop-by-hop headers, these are removed when sent to any other hop.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard, but still sent by some clients
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders drops the hop-by-hop headers, including the ones the
+// sender listed in Connection.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			if k = textproto.TrimString(k); k != "" {
+				h.Del(k)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+type forwardAuth struct {
+	cfg    ForwardAuthConfig
+	client *http.Client
+}
+
+func newForwardAuth(cfg ForwardAuthConfig) *forwardAuth {
+	timeout := time.Duration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &forwardAuth{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: timeout,
+			// Redirects from the auth service (e.g. to a login page) are
+			// meant for the client, not for us.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (fa *forwardAuth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authReq, err := http.NewRequestWithContext(r.Context(), http.MethodGet, fa.cfg.Address, nil)
+		if err != nil {
+			slog.Warn("⚠️ Forward auth", "err", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		fa.copyRequestHeaders(authReq.Header, r)
+
+		resp, err := fa.client.Do(authReq)
+		if err != nil {
+			slog.Warn("⚠️ Forward auth request failed", "err", err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			// Hand the auth service's answer (401, 403, redirect to login...)
+			// straight back to the client.
+			removeHopHeaders(resp.Header)
+			resp.Header.Del("Content-Length")
+			for k, vv := range resp.Header {
+				w.Header()[k] = vv
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+
+		for _, k := range fa.cfg.AuthResponseHeaders {
+			// Always drop what the client sent so these can't be spoofed.
+			r.Header.Del(k)
+			if vv := resp.Header.Values(k); len(vv) > 0 {
+				r.Header[http.CanonicalHeaderKey(k)] = vv
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (fa *forwardAuth) copyRequestHeaders(dst http.Header, r *http.Request) {
+	if len(fa.cfg.AuthRequestHeaders) == 0 {
+		for k, vv := range r.Header {
+			dst[k] = append([]string(nil), vv...)
+		}
+		removeHopHeaders(dst)
+		dst.Del("Content-Length")
+	} else {
+		for _, k := range fa.cfg.AuthRequestHeaders {
+			if vv := r.Header.Values(k); len(vv) > 0 {
+				dst[http.CanonicalHeaderKey(k)] = append([]string(nil), vv...)
+			}
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	dst.Set("X-Forwarded-Method", r.Method)
+	dst.Set("X-Forwarded-Proto", proto)
+	dst.Set("X-Forwarded-Host", r.Host)
+	dst.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = canonicalIP(ip)
+		// Extend the client's chain like a normal proxy hop would.
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		dst.Set("X-Forwarded-For", ip)
+	}
+}
@@ -0,0 +1,212 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig turns the listener into HTTPS. Setting ClientCAFile enables
+// client certificate (mTLS) verification for service-to-service fronting.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+	// ClientAuth is one of "none", "request", "require", "verify_if_given"
+	// or "require_and_verify". Defaults to "require_and_verify" when a
+	// client CA is configured.
+	ClientAuth string `json:"client_auth"`
+	// Certificates are extra cert/key pairs chosen by SNI, for virtual
+	// hosts that need their own certificate. CertFile/KeyFile is the default.
+	Certificates []CertificateConfig `json:"certificates"`
+	// WatchInterval is how often certificate files are checked for changes
+	// and reloaded. Defaults to 10s.
+	WatchInterval Duration    `json:"watch_interval"`
+	ACME          *ACMEConfig `json:"acme,omitempty"`
+}
+
+// ACMEConfig obtains and renews certificates automatically (Let's Encrypt
+// by default) for Hosts. TLS-ALPN-01 is answered on the TLS listener and
+// HTTP-01 on HTTPListen.
+type ACMEConfig struct {
+	Hosts        []string `json:"hosts"`
+	Email        string   `json:"email"`
+	CacheDir     string   `json:"cache_dir"`
+	DirectoryURL string   `json:"directory_url"`
+	// HTTPListen serves HTTP-01 challenges and redirects everything else
+	// to HTTPS. Defaults to ":80"; "off" disables it.
+	HTTPListen string `json:"http_listen"`
+}
+
+func (a *ACMEConfig) manager() *autocert.Manager {
+	cacheDir := a.CacheDir
+	if cacheDir == "" {
+		cacheDir = "acme-cache"
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(a.Hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      a.Email,
+	}
+	if a.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: a.DirectoryURL}
+	}
+	return m
+}
+
+func (a *ACMEConfig) httpListen() string {
+	if a.HTTPListen == "" {
+		return ":80"
+	}
+	return a.HTTPListen
+}
+
+// Headers carrying the verified client identity to backends. They are
+// always stripped from incoming requests so clients can't forge them.
+const (
+	headerClientSubject = "X-Client-Cert-Subject"
+	headerClientSAN     = "X-Client-Cert-San"
+)
+
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// frontendTLS is everything the listener needs for HTTPS: the tls.Config,
+// the certificate store to watch and, with ACME, the HTTP-01 handler.
+type frontendTLS struct {
+	config     *tls.Config
+	certs      *certStore
+	challenges http.Handler
+}
+
+func (c *TLSConfig) newFrontendTLS() (*frontendTLS, error) {
+	ft := &frontendTLS{config: &tls.Config{MinVersion: tls.VersionTLS12}}
+	tc := ft.config
+
+	var files []CertificateConfig
+	if c.CertFile != "" || c.KeyFile != "" {
+		files = append(files, CertificateConfig{CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+	files = append(files, c.Certificates...)
+	if len(files) == 0 && c.ACME == nil {
+		return nil, fmt.Errorf("tls: cert_file/key_file, certificates or acme is required")
+	}
+	if len(files) > 0 {
+		store, err := newCertStore(files)
+		if err != nil {
+			return nil, err
+		}
+		ft.certs = store
+		tc.GetCertificate = store.GetCertificate
+		watchFile("listener certificates", store.paths(), store.load)
+	}
+
+	if c.ACME != nil {
+		if len(c.ACME.Hosts) == 0 {
+			return nil, fmt.Errorf("tls: acme needs at least one host")
+		}
+		m := c.ACME.manager()
+		acmeHosts := make(map[string]bool)
+		for _, h := range c.ACME.Hosts {
+			acmeHosts[normalizeSNI(h)] = true
+		}
+		tc.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if ft.certs != nil && !acmeHosts[normalizeSNI(hello.ServerName)] {
+				return ft.certs.GetCertificate(hello)
+			}
+			return m.GetCertificate(hello)
+		}
+		tc.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		ft.challenges = m.HTTPHandler(nil)
+	}
+
+	mode := c.ClientAuth
+	if mode == "" && c.ClientCAFile != "" {
+		mode = "require_and_verify"
+	}
+	if mode != "" {
+		auth, ok := clientAuthModes[mode]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown client_auth %q", mode)
+		}
+		// Without our own CA, crypto/tls would verify against the system
+		// roots and accept any publicly issued certificate as an identity.
+		verifies := auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert
+		if verifies && c.ClientCAFile == "" {
+			return nil, fmt.Errorf("tls: client_auth %q requires client_ca_file", mode)
+		}
+		tc.ClientAuth = auth
+	}
+	if c.ClientCAFile != "" {
+		cas, err := loadCertPool(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		var current atomic.Pointer[x509.CertPool]
+		current.Store(cas)
+		watchFile("client CA "+c.ClientCAFile, []string{c.ClientCAFile}, func() error {
+			cas, err := loadCertPool(c.ClientCAFile)
+			if err == nil {
+				current.Store(cas)
+			}
+			return err
+		})
+		// Hand each handshake the latest CA pool so a rotated client CA
+		// takes effect without a restart.
+		base := tc.Clone()
+		base.ClientCAs = cas
+		tc.ClientCAs = cas
+		tc.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			c.ClientCAs = current.Load()
+			return c, nil
+		}
+	}
+	return ft, nil
+}
+
+// clientCertHeaders forwards the subject and SANs of a verified client
+// certificate to the backend.
+func clientCertHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(headerClientSubject)
+		r.Header.Del(headerClientSAN)
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			leaf := r.TLS.VerifiedChains[0][0]
+			r.Header.Set(headerClientSubject, leaf.Subject.String())
+			if san := certSANs(leaf); san != "" {
+				r.Header.Set(headerClientSAN, san)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func certSANs(c *x509.Certificate) string {
+	var sans []string
+	for _, d := range c.DNSNames {
+		sans = append(sans, "DNS:"+d)
+	}
+	for _, u := range c.URIs {
+		sans = append(sans, "URI:"+u.String())
+	}
+	for _, e := range c.EmailAddresses {
+		sans = append(sans, "email:"+e)
+	}
+	for _, ip := range c.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	return strings.Join(sans, ",")
+}
@@ -0,0 +1,212 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/loadbalancer/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCAdminConfig serves the control-plane API in adminpb/admin.proto.
+// It uses the same admin_tokens as the REST API, sent as
+// "authorization: Bearer <token>" metadata.
+type GRPCAdminConfig struct {
+	Listen string `json:"listen"`
+}
+
+// grpcRoles is the role each method needs, like registerAdminRoutes.
+var grpcRoles = map[string]role{
+	adminpb.Admin_AddServer_FullMethodName:   roleAdmin,
+	adminpb.Admin_Drain_FullMethodName:       roleOperator,
+	adminpb.Admin_Undrain_FullMethodName:     roleOperator,
+	adminpb.Admin_SetWeight_FullMethodName:   roleOperator,
+	adminpb.Admin_StreamStats_FullMethodName: roleViewer,
+}
+
+type grpcAdmin struct {
+	adminpb.UnimplementedAdminServer
+	pools *PoolManager
+	auth  *adminAuth
+}
+
+func newGRPCAdminServer(pools *PoolManager, auth *adminAuth) *grpc.Server {
+	ga := &grpcAdmin{pools: pools, auth: auth}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (any, error) {
+			if err := ga.authorize(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			if err := ga.authorize(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return h(srv, ss)
+		}),
+	)
+	adminpb.RegisterAdminServer(srv, ga)
+	return srv
+}
+
+func (b *Balancer) startGRPCAdmin(c GRPCAdminConfig, auth *adminAuth) {
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		fatal("Error starting the gRPC admin API", "err", err)
+	}
+	slog.Info("🛠️ gRPC admin API listening", "addr", ln.Addr().String())
+	go func() {
+		if err := newGRPCAdminServer(b.pools, auth).Serve(ln); err != nil {
+			slog.Warn("⚠️ gRPC admin API stopped", "err", err)
+		}
+	}()
+}
+
+// authorize applies the same rules as adminAuth.require.
+func (ga *grpcAdmin) authorize(ctx context.Context, method string) error {
+	need, ok := grpcRoles[method]
+	if !ok {
+		need = roleAdmin
+	}
+	if len(ga.auth.tokens) == 0 {
+		if need == roleViewer {
+			return nil
+		}
+		return status.Error(codes.PermissionDenied, "admin API disabled: no admin_tokens configured")
+	}
+	var tok string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("authorization"); len(v) > 0 {
+		tok, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	got, tenant, ok := ga.auth.roleOfToken(tok)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing or unknown token")
+	}
+	if tenant != "" {
+		return status.Error(codes.PermissionDenied, "tenant tokens can only use the HTTP admin API")
+	}
+	if got < need {
+		return status.Error(codes.PermissionDenied, "forbidden")
+	}
+	return nil
+}
+
+func (ga *grpcAdmin) server(name string) (*Server, error) {
+	s := ga.pools.Find(name)
+	if s == nil {
+		return nil, status.Errorf(codes.NotFound, "server %q not found", name)
+	}
+	return s, nil
+}
+
+func (ga *grpcAdmin) AddServer(ctx context.Context, req *adminpb.AddServerRequest) (*adminpb.Server, error) {
+	if req.Name == "" || req.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, "name and url are required")
+	}
+	sc := ServerConfig{Name: req.Name, URL: req.Url, Weight: int(req.Weight), Labels: req.Labels}
+	if err := validateServerURL(sc); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s := ga.pools.Default().newServer(sc)
+	if err := ga.pools.Register(s); err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	slog.Info("➕ Server added via gRPC admin API", "server", s.Name)
+	return serverProto(serverStats(s)), nil
+}
+
+func (ga *grpcAdmin) Drain(ctx context.Context, req *adminpb.DrainRequest) (*adminpb.Server, error) {
+	s, err := ga.server(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !ga.pools.Drain(s) {
+		return nil, status.Error(codes.FailedPrecondition, "server is disabled")
+	}
+	slog.Info("⏸️ Server draining via gRPC admin API", "server", s.Name)
+	deadline := time.Now().Add(req.Timeout.AsDuration())
+	for s.pool.Active(s) > 0 && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return serverProto(serverStats(s)), nil
+}
+
+func (ga *grpcAdmin) Undrain(ctx context.Context, req *adminpb.UndrainRequest) (*adminpb.Server, error) {
+	s, err := ga.server(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	ga.pools.Enable(s)
+	slog.Info("▶️ Server enabled via gRPC admin API", "server", s.Name)
+	return serverProto(serverStats(s)), nil
+}
+
+func (ga *grpcAdmin) SetWeight(ctx context.Context, req *adminpb.SetWeightRequest) (*adminpb.Server, error) {
+	if req.Weight <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "weight must be positive")
+	}
+	s, err := ga.server(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	s.pool.SetWeight(s, int(req.Weight))
+	slog.Info("⚖️ Server weight set via gRPC admin API", "server", s.Name, "weight", req.Weight)
+	return serverProto(serverStats(s)), nil
+}
+
+func (ga *grpcAdmin) StreamStats(req *adminpb.StreamStatsRequest, stream grpc.ServerStreamingServer[adminpb.StatsSnapshot]) error {
+	interval := req.Interval.AsDuration()
+	if interval <= 0 {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		snap := &adminpb.StatsSnapshot{Time: timestamppb.Now()}
+		for _, s := range ga.pools.Servers() {
+			snap.Servers = append(snap.Servers, serverProto(serverStats(s)))
+		}
+		if err := stream.Send(snap); err != nil {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func serverProto(st ServerStats) *adminpb.Server {
+	p := &adminpb.Server{
+		Name:              st.Name,
+		Url:               st.URL,
+		Pool:              st.Pool,
+		Labels:            st.Labels,
+		InPool:            st.InPool,
+		Weight:            int32(st.Weight),
+		Healthy:           st.Health,
+		Disabled:          st.Disabled,
+		Maintenance:       st.Maintenance,
+		ActiveConnections: int32(st.Active),
+		Load:              st.Load,
+		ConcurrencyLimit:  int32(st.Limit),
+	}
+	if st.LastCheck != nil {
+		p.LastCheck = timestamppb.New(*st.LastCheck)
+	}
+	return p
+}
@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Handler is a load balancer for mounting in another program's mux,
+// built by NewHandler. It serves the proxied traffic; AdminHandler serves
+// the status and admin routes. The program owns the listeners, so the
+// config's listen, admin_listen, tls, proxy_protocol and grpc_admin
+// settings are ignored.
+type Handler struct {
+	b     *Balancer
+	proxy http.Handler
+	admin http.Handler
+}
+
+// NewHandler checks c, sets up the balancer it describes and starts its
+// health checks and other background jobs, which run until Close. Build c
+// with ParseConfig or LoadConfig to get the defaults a config file gets.
+//
+// Requests reach the backends with the path they arrive with, so mount
+// the handler with http.StripPrefix if it lives under a path of its own.
+func NewHandler(c Config) (*Handler, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	b, err := newBalancer(c)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &b.cfg
+	plugins, err := loadPlugins(cfg.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := b.proxyHandler(plugins)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := newAdminAuth(cfg.AdminTokens)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyProcessSettings(cfg); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	b.registerPublicRoutes(mux)
+	mux.Handle("/", proxy)
+	admin := http.NewServeMux()
+	b.registerStatusRoutes(admin, auth)
+	h := &Handler{
+		b:     b,
+		proxy: recoverPanics(cfg.Hardening.Wrap(mux)),
+		admin: recoverPanics(admin),
+	}
+
+	b.start(plugins)
+	if sc := cfg.Startup; sc != nil {
+		// Nothing here holds a listener back, so /readyz is the gate.
+		go b.awaitHealthy(*sc)
+	}
+	startFileWatch(cfg)
+	slog.Info("🚀 Weighted DSA Load Balancer handler ready", "servers", len(b.pools.Servers()))
+	return h, nil
+}
+
+// ServeHTTP proxies r to a backend, and answers the self-registration
+// endpoints and /readyz.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.proxy.ServeHTTP(w, r)
+}
+
+// AdminHandler serves /stats, /metrics, /alerts, the dashboard and the
+// admin API, behind the config's admin tokens. The dashboard's links are
+// absolute, so mount it at the root of a host or port of its own if the
+// dashboard is used.
+func (h *Handler) AdminHandler() http.Handler {
+	return h.admin
+}
+
+// Close stops the background jobs. It doesn't wait for requests in
+// flight; shut down the http.Server serving the handler first for that.
+func (h *Handler) Close() error {
+	h.b.stop()
+	return nil
+}
@@ -0,0 +1,105 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// HardeningConfig tunes the checks every request goes through before it
+// is routed, to keep requests that backends might read differently from
+// us (request smuggling, path confusion) from getting through. They are
+// on by default.
+type HardeningConfig struct {
+	// KeepPaths forwards paths as received instead of normalizing them.
+	KeepPaths bool `json:"keep_paths"`
+	// MaxHeaders caps the number of request header fields. Defaults to
+	// 100.
+	MaxHeaders int `json:"max_headers"`
+	// MaxHeaderBytes caps the size of the request line and headers.
+	// Defaults to 64KB.
+	MaxHeaderBytes int `json:"max_header_bytes"`
+}
+
+const (
+	defaultMaxHeaders     = 100
+	defaultMaxHeaderBytes = 64 << 10
+)
+
+func (c *HardeningConfig) validate() error {
+	if c.MaxHeaders < 0 || c.MaxHeaderBytes < 0 {
+		return fmt.Errorf("hardening: limits can't be negative")
+	}
+	return nil
+}
+
+func (c *HardeningConfig) maxHeaderBytes() int {
+	if c.MaxHeaderBytes > 0 {
+		return c.MaxHeaderBytes
+	}
+	return defaultMaxHeaderBytes
+}
+
+// Wrap rejects requests with too many headers and normalizes the path of
+// the rest. It goes in front of everything, admin API included, so that
+// route matching and the backend see the same path.
+//
+// Message framing is left to net/http, which answers conflicting
+// Content-Length headers with 400 and unknown transfer codings with 501,
+// and reads a chunked body by its chunks even if Content-Length is sent
+// too. Backends never see the client's framing: the transport sends
+// every body with framing of its own.
+func (c *HardeningConfig) Wrap(next http.Handler) http.Handler {
+	maxHeaders := c.MaxHeaders
+	if maxHeaders == 0 {
+		maxHeaders = defaultMaxHeaders
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		for _, vv := range r.Header {
+			n += len(vv)
+		}
+		if n > maxHeaders {
+			slog.Warn("🛡️ Refused request with too many header fields", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "headers", n)
+			http.Error(w, "Request Header Fields Too Large", http.StatusRequestHeaderFieldsTooLarge)
+			return
+		}
+		if !c.KeepPaths && r.Method != http.MethodConnect && r.URL.Path != "*" {
+			normalizeURLPath(r.URL)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// normalizeURLPath resolves dot segments and merges duplicate slashes in
+// u's path, keeping a trailing slash. Dot segments hidden by percent
+// encoding are resolved too: the encoded form is only kept when it still
+// matches the cleaned path.
+func normalizeURLPath(u *url.URL) {
+	u.Path = normalizePath(u.Path)
+	if u.RawPath == "" {
+		return
+	}
+	raw := normalizePath(u.RawPath)
+	if p, err := url.PathUnescape(raw); err != nil || p != u.Path {
+		raw = ""
+	}
+	u.RawPath = raw
+}
+
+func normalizePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
@@ -0,0 +1,47 @@
+package balancer
+
+import (
+	"math"
+)
+
+// usesHash reports whether p picks servers by client key.
+func usesHash(p *ServerPool) bool {
+	_, ok := p.Strategy().(consistentHash)
+	return ok
+}
+
+// hashScore is s's weighted rendezvous score for key: heavier servers win
+// proportionally more keys.
+func hashScore(key string, s *Server) float64 {
+	// The top 53 bits as a float in (0, 1).
+	u := (float64(rendezvousHash(key, s.Name)>>11) + 0.5) / (1 << 53)
+	return float64(max(s.Weight, 1)) / -math.Log(u)
+}
+
+// GetServerForKey returns the server in the heap that ranks highest for
+// key among those accepted by match (nil accepts all), or nil. A key only
+// moves when its server leaves or a server that outranks it joins.
+func (p *ServerPool) GetServerForKey(key string, match func(*Server) bool) *Server {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var best *Server
+	bestScore := 0.0
+	for _, s := range p.servers.items {
+		if s.passedOver.Load() || (match != nil && !match(s)) {
+			continue
+		}
+		if score := hashScore(key, s); best == nil || score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	if best != nil {
+		p.publish(Selected, best, true)
+	}
+	return best
+}
+
+// acquireHashed is acquireServer for consistent_hash pools: key's server,
+// or while that one is at its concurrency limit the next one for key.
+func (b *Balancer) acquireHashed(p *ServerPool, key string) (s *Server, overloaded bool) {
+	return acquireFrom(func(match func(*Server) bool) *Server { return p.GetServerForKey(key, match) })
+}
@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"time"
+)
+
+// defaultHealthHistorySize is how many transitions each server remembers
+// unless health_history_size says otherwise.
+const defaultHealthHistorySize = 10
+
+var healthHistorySize = defaultHealthHistorySize
+
+// HealthEvent is one up/down transition of a server.
+type HealthEvent struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+func healthState(alive bool) string {
+	if alive {
+		return "up"
+	}
+	return "down"
+}
+
+// RecordHealth sets the health like SetHealth and, when it changed, keeps
+// the transition and its reason in the server's history. It reports
+// whether the state changed.
+func (s *Server) RecordHealth(alive bool, reason string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	was := s.Health
+	s.Health = alive
+	s.LastCheck = time.Now()
+	if alive {
+		s.LastSuccess, s.ConsecutiveFailures = s.LastCheck, 0
+	} else {
+		s.ConsecutiveFailures++
+	}
+	if was == alive {
+		return false
+	}
+	s.history = append(s.history, HealthEvent{
+		Time:   s.LastCheck,
+		From:   healthState(was),
+		To:     healthState(alive),
+		Reason: reason,
+	})
+	if over := len(s.history) - healthHistorySize; over > 0 {
+		s.history = append(s.history[:0:0], s.history[over:]...)
+	}
+	return true
+}
+
+// HealthHistory returns the remembered transitions, oldest first.
+func (s *Server) HealthHistory() []HealthEvent {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return append([]HealthEvent{}, s.history...)
+}
@@ -0,0 +1,188 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthProbeTimeout is how long one health probe may take.
+const healthProbeTimeout = 2 * time.Second
+
+// HealthChecker probes one server. A nil error means s is healthy; the
+// error is the reason shown in its health history, stats and alerts.
+// Check must give up when ctx is done.
+type HealthChecker interface {
+	Check(ctx context.Context, s *Server) error
+}
+
+var (
+	customCheckersMu sync.RWMutex
+	customCheckers   = map[string]HealthChecker{}
+)
+
+// RegisterHealthChecker makes hc available as health_check type name, for
+// programs embedding the balancer whose backends have their own idea of
+// ready. Call it before the config is loaded.
+func RegisterHealthChecker(name string, hc HealthChecker) {
+	customCheckersMu.Lock()
+	defer customCheckersMu.Unlock()
+	customCheckers[name] = hc
+}
+
+// checker is the HealthChecker for the config's type, or nil for the
+// default, which is the health plugin if one is loaded and HTTP if not.
+func (c *HealthCheckConfig) checker() (HealthChecker, error) {
+	if c == nil {
+		return nil, nil
+	}
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "http":
+		return HTTPHealthCheck{}, nil
+	case "tcp":
+		return TCPHealthCheck{}, nil
+	case "grpc":
+		return GRPCHealthCheck{Service: c.Service}, nil
+	}
+	customCheckersMu.RLock()
+	defer customCheckersMu.RUnlock()
+	if hc, ok := customCheckers[c.Type]; ok {
+		return hc, nil
+	}
+	return nil, fmt.Errorf("health_check: unknown type %q", c.Type)
+}
+
+// HealthURL is where s is health-checked: its health_url, or its URL
+// with the pool's health_check port and path.
+func (s *Server) HealthURL() string {
+	if s.healthURL != "" {
+		return s.healthURL
+	}
+	return s.URL
+}
+
+// healthAddr is the host:port of s's health URL, with the scheme's
+// default port if it names none.
+func (s *Server) healthAddr() (string, error) {
+	u, err := url.Parse(s.HealthURL())
+	if err != nil {
+		return "", err
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	port := map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	if port == "" {
+		return "", fmt.Errorf("health URL %q has no port", s.HealthURL())
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// dialBackend connects to addr the way proxied traffic would: through the
+// pool's dialer, egress proxy and DNS cache. The connection isn't
+// counted in the server's connection stats.
+func (s *Server) dialBackend(ctx context.Context, addr string) (net.Conn, error) {
+	if s.pool != nil {
+		if t, ok := s.pool.transport.(*http.Transport); ok && t.DialContext != nil {
+			return t.DialContext(ctx, "tcp", addr)
+		}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// backendTLS is the TLS config of s's pool transport, so that probes
+// present the same client certificate and trust the same CAs as traffic.
+func (s *Server) backendTLS() *tls.Config {
+	if s.pool != nil {
+		if t, ok := s.pool.transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			return t.TLSClientConfig.Clone()
+		}
+	}
+	return &tls.Config{}
+}
+
+// HTTPHealthCheck passes a server whose health URL answers a HEAD with
+// 200. It goes through the server's transport, so it presents the same
+// client certificate as real traffic.
+type HTTPHealthCheck struct{}
+
+func (HTTPHealthCheck) Check(ctx context.Context, s *Server) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.HealthURL(), nil)
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", ErrProxy, err)
+	}
+	client := http.Client{Transport: s.ReverseProxy.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", classifyError(nil, err), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("probe returned %s", resp.Status)
+	}
+	return nil
+}
+
+// TCPHealthCheck passes a server that accepts a connection on its health
+// URL's host and port, for backends with no health endpoint.
+type TCPHealthCheck struct{}
+
+func (TCPHealthCheck) Check(ctx context.Context, s *Server) error {
+	addr, err := s.healthAddr()
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", ErrProxy, err)
+	}
+	conn, err := s.dialBackend(ctx, addr)
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", classifyError(nil, err), err)
+	}
+	return conn.Close()
+}
+
+// GRPCHealthCheck passes a server whose grpc.health.v1 Health service
+// reports SERVING for Service, "" meaning the server as a whole. It
+// connects to the health URL's host and port, in plaintext for http and
+// over TLS with the pool's backend_tls for https.
+type GRPCHealthCheck struct {
+	Service string
+}
+
+func (g GRPCHealthCheck) Check(ctx context.Context, s *Server) error {
+	addr, err := s.healthAddr()
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", ErrProxy, err)
+	}
+	creds := insecure.NewCredentials()
+	if strings.HasPrefix(s.HealthURL(), "https:") {
+		creds = credentials.NewTLS(s.backendTLS())
+	}
+	conn, err := grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(s.dialBackend))
+	if err != nil {
+		return fmt.Errorf("probe %s: %w", ErrProxy, err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: g.Service})
+	if err != nil {
+		return fmt.Errorf("probe grpc: %w", err)
+	}
+	if st := resp.GetStatus(); st != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("probe returned %s", st)
+	}
+	return nil
+}
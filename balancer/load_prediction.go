@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// LoadPredictionConfig makes a pool's strategy look a little ahead: a
+// server whose connections are climbing counts as busier than it is yet,
+// by about what the climb would add within Horizon. Under bursty traffic
+// that moves new requests off a server as it ramps, rather than once it
+// has become the busiest, so picks swing between servers less.
+type LoadPredictionConfig struct {
+	// Horizon is how far ahead to look, and also how long the smoothed
+	// connection count the climb is measured from takes to catch up.
+	// Defaults to 200ms.
+	Horizon Duration `json:"horizon"`
+}
+
+func (c *LoadPredictionConfig) validate() error {
+	if c.Horizon < 0 {
+		return fmt.Errorf("load_prediction: horizon can't be negative")
+	}
+	return nil
+}
+
+// horizon is 0, for no prediction, when c is nil.
+func (c *LoadPredictionConfig) horizon() time.Duration {
+	switch {
+	case c == nil:
+		return 0
+	case c.Horizon > 0:
+		return time.Duration(c.Horizon)
+	}
+	return 200 * time.Millisecond
+}
+
+// predictedActive is the connection count the built-in strategies rank
+// s by: its active connections plus its surge.
+func (s *Server) predictedActive() float64 {
+	return float64(s.ActiveConnections) + s.surge
+}
+
+// addActive changes s's connection count by delta and, if p has
+// load_prediction, its surge. A smoothed count follows the real one with
+// a time constant of horizon, so while connections climb it lags behind
+// by about the rise over the last horizon: that lag is the surge. A
+// falling count gives no credit, so a server emptying out is ranked by
+// its real count. A delta of 0 only lets time pass. The caller holds the
+// pool lock.
+func (p *ServerPool) addActive(s *Server, delta int) {
+	horizon := p.config.LoadPrediction.horizon()
+	if horizon <= 0 {
+		s.ActiveConnections += delta
+		return
+	}
+	now := time.Now()
+	if p.clock != nil {
+		now = p.clock()
+	}
+	held := float64(s.ActiveConnections)
+	if s.smoothedAt.IsZero() {
+		s.smoothed = held
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(s.smoothedAt))/float64(horizon))
+		s.smoothed += alpha * (held - s.smoothed)
+	}
+	s.smoothedAt = now
+	s.ActiveConnections += delta
+	s.surge = max(float64(s.ActiveConnections)-s.smoothed, 0)
+	if s.surge < minSurge {
+		s.surge = 0
+		delete(p.surging, s)
+		return
+	}
+	if p.surging == nil {
+		p.surging = map[*Server]bool{}
+	}
+	p.surging[s] = true
+}
+
+// minSurge is the surge below which a server counts as steady.
+const minSurge = 0.01
+
+// decaySurges lets the surges of servers whose count has held since it
+// last climbed fade with the time passed, and moves them in the heap to
+// match. Loads only change under the lock, so the heap stays in order;
+// the pool decays them before it picks or reports a load. The caller
+// holds the pool lock.
+func (p *ServerPool) decaySurges() {
+	for s := range p.surging {
+		p.addActive(s, 0)
+		if s.Index != -1 {
+			p.servers.fix(s.Index)
+		}
+	}
+}
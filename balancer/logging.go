@@ -0,0 +1,253 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogConfig sets up the log. Records are structured: a message, which
+// keeps its emoji, plus attributes such as server and pool.
+type LogConfig struct {
+	// Level is "debug", "info" (default), "warn" or "error". Debug adds a
+	// line per request saying which backend was picked and why.
+	Level string `json:"level"`
+	// Format is "text" (default, key=value) or "json".
+	Format string `json:"format"`
+	// Output is "stderr" (default) or "stdout". log_file sends the log to
+	// a file instead.
+	Output string `json:"output"`
+	// Sampling thins out messages that repeat many times a second, like
+	// per-request errors during an outage. Errors are never sampled.
+	Sampling *LogSamplingConfig `json:"sampling,omitempty"`
+}
+
+type LogSamplingConfig struct {
+	// Initial is how many records with the same message are logged per
+	// interval before sampling starts.
+	Initial int `json:"initial"`
+	// Thereafter logs every Nth of the rest; 0 drops them all.
+	Thereafter int `json:"thereafter"`
+	// Interval defaults to 1s.
+	Interval Duration `json:"interval"`
+}
+
+func (c *LogConfig) validate() error {
+	if c.Level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(c.Level)); err != nil {
+			return fmt.Errorf("log: unknown level %q", c.Level)
+		}
+	}
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("log: unknown format %q, want text or json", c.Format)
+	}
+	switch c.Output {
+	case "", "stderr", "stdout":
+	default:
+		return fmt.Errorf("log: unknown output %q, want stderr or stdout (use log_file for files)", c.Output)
+	}
+	if s := c.Sampling; s != nil && (s.Initial < 0 || s.Thereafter < 0 || s.Interval < 0) {
+		return fmt.Errorf("log: sampling values can't be negative")
+	}
+	return nil
+}
+
+var logsSampled = newCounterVec("lb_log_sampled_total",
+	"Log records dropped by sampling.", "level")
+
+// logLevel is the level of the installed handler. Changing it takes
+// effect at once.
+var logLevel = new(slog.LevelVar)
+
+// logOutput is where the log goes: stderr, stdout or the log file, which
+// SIGUSR1 swaps for a new one.
+var logOutput = &swapWriter{w: os.Stderr}
+
+type swapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *swapWriter) Write(b []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(b)
+}
+
+func (sw *swapWriter) Set(w io.Writer) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.w = w
+}
+
+// The handler is installed from the start, so log.Printf from libraries
+// and plugins ends up in the same place and format. run replaces it once
+// the config is loaded.
+func init() {
+	setupLogging(LogConfig{}, "", "")
+}
+
+// setupLogging installs the handler c describes as the default for slog
+// and the log package. level and format, from the command line, win over
+// the config.
+func setupLogging(c LogConfig, level, format string) error {
+	if level == "" {
+		level = c.Level
+	}
+	if format == "" {
+		format = c.Format
+	}
+	l := slog.LevelInfo
+	if level != "" {
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("unknown log level %q", level)
+		}
+	}
+	logLevel.Set(l)
+	if c.Output == "stdout" {
+		logOutput.Set(os.Stdout)
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(logOutput, opts)
+	case "json":
+		h = slog.NewJSONHandler(logOutput, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	if s := c.Sampling; s != nil {
+		interval := time.Second
+		if s.Interval > 0 {
+			interval = time.Duration(s.Interval)
+		}
+		h = &samplingHandler{Handler: h, s: &logSampler{cfg: *s, interval: interval, counts: map[string]int{}}}
+	}
+	if id := workerID(); id > 0 {
+		h = h.WithAttrs([]slog.Attr{slog.Int("worker", id)})
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// maxLogLevelOverride caps how long PUT /admin/loglevel can change the
+// level for, so a forgotten debug session ends by itself.
+const maxLogLevelOverride = 24 * time.Hour
+
+// logOverride is a level set through the admin API, and the configured
+// level it reverts to.
+var logOverride struct {
+	mu    sync.Mutex
+	base  slog.Level
+	until time.Time
+	timer *time.Timer
+}
+
+// overrideLogLevel sets the level to l for d, then back to the configured
+// level. Setting the configured level ends an override early.
+func overrideLogLevel(l slog.Level, d time.Duration) {
+	o := &logOverride
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer == nil {
+		o.base = logLevel.Level()
+	} else {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	logLevel.Set(l)
+	if l == o.base {
+		o.until = time.Time{}
+		return
+	}
+	o.until = time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if o.timer != t {
+			return
+		}
+		logLevel.Set(o.base)
+		o.timer, o.until = nil, time.Time{}
+		slog.Info("📝 Log level override ended", "level", o.base.String())
+	})
+	o.timer = t
+}
+
+// logLevelState is the level now, the configured one and, during an
+// override, when it ends.
+func logLevelState() (level, base slog.Level, until time.Time) {
+	o := &logOverride
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer == nil {
+		return logLevel.Level(), logLevel.Level(), time.Time{}
+	}
+	return logLevel.Level(), o.base, o.until
+}
+
+// debugLogging says whether debug records are logged. Check it before
+// building the attributes of a per-request debug line.
+func debugLogging() bool {
+	return slog.Default().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// fatal logs msg as an error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// samplingHandler drops repeats of a message beyond what its sampler
+// allows. Handlers derived from it share the sampler.
+type samplingHandler struct {
+	slog.Handler
+	s *logSampler
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError && !h.s.keep(r.Message, r.Time) {
+		logsSampled.Inc(r.Level.String())
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), s: h.s}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), s: h.s}
+}
+
+// logSampler counts records per message over fixed intervals.
+type logSampler struct {
+	cfg      LogSamplingConfig
+	interval time.Duration
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func (s *logSampler) keep(msg string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.start) >= s.interval {
+		clear(s.counts)
+		s.start = now
+	}
+	s.counts[msg]++
+	n := s.counts[msg] - s.cfg.Initial
+	return n <= 0 || (s.cfg.Thereafter > 0 && n%s.cfg.Thereafter == 0)
+}
@@ -0,0 +1,161 @@
+package balancer
+
+import (
+	"context"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Server struct {
+	Name              string
+	URL               string
+	Weight            int
+	ReverseProxy      *httputil.ReverseProxy
+	Health            bool
+	ActiveConnections int
+	mux               sync.RWMutex
+	Index             int
+	// LatencyEWMA is the smoothed request duration in milliseconds.
+	LatencyEWMA float64
+	// AdminState is set by operators and schedules: active, draining,
+	// maintenance or disabled. The health checker leaves servers that
+	// aren't active out of the pool even when healthy. See State.
+	AdminState ServerState
+	// Labels are free-form metadata from the config (version, zone...).
+	// They don't change after the server is created.
+	Labels map[string]string
+	// pool is the pool the server belongs to.
+	pool *ServerPool
+	// limiter is the adaptive concurrency limiter; nil when disabled.
+	limiter *adaptiveLimiter
+	// arm is what the bandit strategy has learnt about the server.
+	arm banditArm
+	// LastCheck is when the health checker last probed this server, and
+	// LastSuccess when a probe last passed. ConsecutiveFailures counts the
+	// failed probes since then.
+	LastCheck           time.Time
+	LastSuccess         time.Time
+	ConsecutiveFailures int
+
+	// smoothed follows ActiveConnections with a lag when the pool has
+	// load_prediction, and surge is how far it trails a rising count;
+	// see load_prediction.go.
+	smoothed   float64
+	smoothedAt time.Time
+	surge      float64
+
+	// pendingActive and liveLatency collect updates not yet folded into
+	// ActiveConnections and LatencyEWMA when the pool uses lazy counters.
+	pendingActive atomic.Int64
+	liveLatency   atomic.Uint64 // math.Float64bits of the EWMA
+
+	// passedOver is set while the server is draining or in maintenance.
+	// It stays in its heap, so its in-flight requests keep counting and
+	// it keeps its place, but selection skips it. Atomic so that the heap
+	// can read it under the pool lock.
+	passedOver atomic.Bool
+
+	// resources is the backend's last resource report, or nil; see
+	// resources.go. It belongs to the pool lock, as the heap ranks by it.
+	resources *ResourceReport
+	// version is what the backend last reported; see version.go.
+	version string
+	// healthURL is where Probe checks the server, when it isn't URL.
+	healthURL string
+	// statsdTag is "backend:<name>", built once rather than per request.
+	statsdTag string
+
+	history     []HealthEvent
+	errorCounts map[ErrorKind]int64
+	lastErr     *lastError
+	// recentErrs are the last recentErrorsSize errors, oldest first.
+	recentErrs []lastError
+	// tail holds sampled requests for the dashboard; see request_tail.go.
+	tail requestTail
+	// conns counts the connections to the backend; see conn_stats.go.
+	conns connStats
+}
+
+func newServer(name, urlstr string) *Server {
+	u, err := url.Parse(urlstr)
+	if err != nil {
+		u = &url.URL{}
+	}
+	rp := &httputil.ReverseProxy{Director: backendDirector(u)}
+	s := &Server{
+		Name:         name,
+		URL:          urlstr,
+		ReverseProxy: rp,
+		Health:       true,
+		Index:        -1,
+		statsdTag:    "backend:" + name,
+	}
+	rp.ErrorHandler = s.proxyErrorHandler
+	rp.ModifyResponse = s.checkBackendStatus
+	return s
+}
+
+func (s *Server) CheckHealth() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.Health
+}
+
+func (s *Server) SetHealth(alive bool) {
+	s.RecordHealth(alive, "")
+}
+
+// checkFreshness returns when s was last probed and last passed, and how
+// many probes in a row failed since.
+func (s *Server) checkFreshness() (last, success time.Time, failures int) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.LastCheck, s.LastSuccess, s.ConsecutiveFailures
+}
+
+// IsDisabled reports whether s was taken out of rotation on purpose:
+// disabled, draining or in maintenance.
+func (s *Server) IsDisabled() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.AdminState != StateActive
+}
+
+func (s *Server) InMaintenance() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.AdminState == StateMaintenance
+}
+
+func (s *Server) GetActive() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.ActiveConnections
+}
+
+func (s *Server) Ping() bool {
+	alive, _ := s.Probe()
+	return alive
+}
+
+// Probe runs one health check and says why it failed. The pool's
+// health_check type picks the checker; without one the health plugin
+// decides, if loaded, and otherwise the HTTP probe.
+func (s *Server) Probe() (alive bool, reason string) {
+	var hc HealthChecker = HTTPHealthCheck{}
+	switch {
+	case s.pool != nil && s.pool.checker != nil:
+		hc = s.pool.checker
+	case healthPlugin != nil:
+		return s.pluginProbe(healthPlugin)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+	if err := hc.Check(ctx, s); err != nil {
+		return false, err.Error()
+	}
+	return true, "probe ok"
+}
@@ -0,0 +1,213 @@
+//go:build unix
+
+package balancer
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// ==========================================
+// TEST 44: Signals, Reload and Log Reopen
+// ==========================================
+func TestSignalsReloadAndLogReopen(t *testing.T) {
+	defer logOutput.Set(os.Stderr)
+	dir := t.TempDir()
+	lb := testBalancer()
+	lb.cfg = Config{LogFile: filepath.Join(dir, "lb.log")}
+	pool := lb.pools.Default()
+
+	path := filepath.Join(dir, "config.json")
+	write := func(s string) {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"servers": [{"name": "a", "url": "http://127.0.0.1:1"}, {"name": "b", "url": "http://127.0.0.1:2"}]}`)
+	if err := lb.reloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	lb.pools.Register(lb.pools.Default().newServer(ServerConfig{Name: "manual", URL: "http://127.0.0.1:9"}))
+
+	lb.handleSignals(path)
+	defer signal.Reset(syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	signalSelf := func(sig os.Signal) {
+		p, _ := os.FindProcess(os.Getpid())
+		if err := p.Signal(sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(what string, ok func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !ok() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !ok() {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+
+	// SIGHUP: b goes, c arrives, a moves weight; the manual server stays.
+	write(`{"strategy": "least_connections", "servers": [{"name": "a", "url": "http://127.0.0.1:1", "weight": 5},
+		{"name": "c", "url": "http://127.0.0.1:3"}]}`)
+	signalSelf(syscall.SIGHUP)
+	waitFor("the reload", func() bool { return lb.pools.Find("c") != nil })
+	if lb.pools.Find("b") != nil || lb.pools.Find("manual") == nil || pool.Weight(lb.pools.Find("a")) != 5 {
+		t.Errorf("Unexpected servers after reload: %d", len(lb.pools.Servers()))
+	}
+	if pool.Strategy().Name() != "least_connections" {
+		t.Errorf("Expected the strategy reloaded, got %s", pool.Strategy().Name())
+	}
+	// A broken file changes nothing.
+	write(`{"servers": [`)
+	if err := lb.reloadConfig(path); err == nil || lb.pools.Find("c") == nil {
+		t.Error("Expected a broken config to be rejected and ignored")
+	}
+
+	// SIGUSR1 after logrotate moved the file: a new one is started.
+	if err := openLog(lb.cfg.LogFile); err != nil {
+		t.Fatal(err)
+	}
+	os.Rename(lb.cfg.LogFile, lb.cfg.LogFile+".1")
+	signalSelf(syscall.SIGUSR1)
+	waitFor("the new log file", func() bool { _, err := os.Stat(lb.cfg.LogFile); return err == nil })
+
+	// SIGUSR2 dumps the pool into it.
+	signalSelf(syscall.SIGUSR2)
+	waitFor("the pool dump", func() bool {
+		b, _ := os.ReadFile(lb.cfg.LogFile)
+		return strings.Contains(string(b), "manual") && strings.Contains(string(b), "strategy=least_connections")
+	})
+}
+
+// ==========================================
+// TEST 45: systemd Notify and Socket Activation
+// ==========================================
+func TestSystemdNotify(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := sdWatchdogInterval(); got != 50*time.Millisecond {
+		t.Errorf("Expected pings every half WatchdogSec, got %s", got)
+	}
+
+	notifyReady(":8000")
+	read := func() string {
+		buf := make([]byte, 256)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	if msg := read(); !strings.HasPrefix(msg, "READY=1\n") {
+		t.Errorf("Expected READY=1 first, got %q", msg)
+	}
+	if msg := read(); msg != "WATCHDOG=1" {
+		t.Errorf("Expected a watchdog ping, got %q", msg)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if sdWatchdogInterval() != 0 {
+		t.Error("Expected no watchdog for another process's WATCHDOG_PID")
+	}
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("Expected sdNotify to be a no-op outside systemd, got %v", err)
+	}
+
+	// Not socket-activated: LISTEN_PID is someone else's.
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		t.Errorf("Expected no inherited listener, got %v %v", ln, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected LISTEN_* cleared so children don't inherit them")
+	}
+}
+
+// ==========================================
+// TEST 46: PID File
+// ==========================================
+func TestPIDFile(t *testing.T) {
+	defer signal.Reset(syscall.SIGTERM, syscall.SIGINT)
+	path := filepath.Join(t.TempDir(), "lb.pid")
+
+	// Left behind by a crashed run: taken over.
+	os.WriteFile(path, []byte("999999999\n"), 0o644)
+	if err := writePIDFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("Expected our pid in the file, got %q", b)
+	}
+
+	// Held by a live process: refused.
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getppid())), 0o644)
+	if err := writePIDFile(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("Expected a running instance to be detected, got %v", err)
+	}
+	// Only our own pid file is removed.
+	removePIDFile(path)
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Expected another process's pid file to stay")
+	}
+	os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644)
+	removePIDFile(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected our pid file removed")
+	}
+}
+
+// ==========================================
+// TEST 47: Prefork Workers Share the Listener
+// ==========================================
+func TestPreforkReusePort(t *testing.T) {
+	defer os.Unsetenv(workerEnv)
+	if workerID() != 0 {
+		t.Fatal("Expected no worker id outside prefork mode")
+	}
+	os.Setenv(workerEnv, "3")
+	if workerID() != 3 {
+		t.Errorf("Expected worker 3, got %d", workerID())
+	}
+
+	a, err := listenReusePort("127.0.0.1:0")
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error("Expected SO_REUSEPORT listeners to be refused off Linux")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	// A second worker binds the very same port.
+	b, err := listenReusePort(a.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s: %s", a.Addr(), err)
+	}
+	b.Close()
+	// A plain listener still can't steal the port.
+	if c, err := net.Listen("tcp", a.Addr().String()); err == nil {
+		c.Close()
+		t.Error("Expected a listener without SO_REUSEPORT to be refused")
+	}
+}
@@ -0,0 +1,161 @@
+package balancer
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A tiny Prometheus text-format registry. We only need labelled counters
+// so far, which doesn't justify pulling in the full client library.
+
+// metric writes its samples. Counters ignore b; funcMetrics read the
+// state they report from it.
+type metric interface {
+	writeTo(sb *strings.Builder, b *Balancer)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []metric
+)
+
+func register(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	registry = append(registry, m)
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // key: label values joined by \xff
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Get returns the current value for one label combination.
+func (c *counterVec) Get(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *counterVec) writeTo(sb *strings.Builder, _ *Balancer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString(c.name)
+		writeLabels(sb, c.labels, strings.Split(k, "\xff"))
+		fmt.Fprintf(sb, " %g\n", c.values[k])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabels(sb *strings.Builder, names, values []string) {
+	if len(names) == 0 {
+		return
+	}
+	sb.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(sb, `%s="%s"`, n, labelEscaper.Replace(values[i]))
+	}
+	sb.WriteByte('}')
+}
+
+func (b *Balancer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	ms := append([]metric(nil), registry...)
+	metricsMu.Unlock()
+
+	var sb strings.Builder
+	for _, m := range ms {
+		m.writeTo(&sb, b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
+
+// funcMetric computes its samples at scrape time, for values that already
+// live in the balancer (server labels, pool state).
+type funcMetric struct {
+	name, help, typ string
+	samples         func(b *Balancer) []metricSample
+}
+
+type metricSample struct {
+	labels, values []string
+	value          float64
+}
+
+func newFuncMetric(name, help, typ string, samples func(b *Balancer) []metricSample) *funcMetric {
+	m := &funcMetric{name: name, help: help, typ: typ, samples: samples}
+	register(m)
+	return m
+}
+
+func (m *funcMetric) writeTo(sb *strings.Builder, b *Balancer) {
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	for _, s := range m.samples(b) {
+		sb.WriteString(m.name)
+		writeLabels(sb, s.labels, s.values)
+		fmt.Fprintf(sb, " %g\n", s.value)
+	}
+}
+
+// promLabelName turns a free-form key into a valid Prometheus label name.
+func promLabelName(k string) string {
+	b := []byte(k)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// lb_backend_info carries each server's labels (as label_<key>) so they
+// can be joined onto other series by backend.
+var backendInfo = newFuncMetric("lb_backend_info",
+	"Backend metadata; always 1.", "gauge", func(b *Balancer) []metricSample {
+		var out []metricSample
+		for _, s := range b.pools.Servers() {
+			names := []string{"backend", "pool"}
+			values := []string{s.Name, s.pool.name}
+			for _, k := range slices.Sorted(maps.Keys(s.Labels)) {
+				names = append(names, "label_"+promLabelName(k))
+				values = append(values, s.Labels[k])
+			}
+			out = append(out, metricSample{names, values, 1})
+		}
+		return out
+	})
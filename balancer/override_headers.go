@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Operators can steer a single request with these headers, to reproduce
+// an issue against one backend or under another strategy. They take an
+// operator token in X-LB-Admin-Token and never reach the backend.
+const (
+	forceBackendHeader = "X-LB-Force-Backend"
+	strategyHeader     = "X-LB-Strategy"
+	overrideAuthHeader = "X-LB-Admin-Token"
+)
+
+var routingOverrides = newCounterVec("lb_routing_overrides_total",
+	"Requests routed by operator override headers.", "kind")
+
+// routingOverride is what a request's override headers ask for.
+type routingOverride struct {
+	backend  string
+	strategy Strategy
+}
+
+type overrideKey struct{}
+
+func overrideOf(r *http.Request) *routingOverride {
+	o, _ := r.Context().Value(overrideKey{}).(*routingOverride)
+	return o
+}
+
+// overrideHeaders takes the override headers off every request. A request
+// that carries some is refused unless its token has the operator role
+// and isn't limited to a tenant, or names a backend or strategy that
+// doesn't exist. Otherwise the overrides go into its context for
+// selectServer.
+func (b *Balancer) overrideHeaders(auth *adminAuth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend, strategy := r.Header.Get(forceBackendHeader), r.Header.Get(strategyHeader)
+		token := r.Header.Get(overrideAuthHeader)
+		if backend == "" && strategy == "" && token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Del(forceBackendHeader)
+		r.Header.Del(strategyHeader)
+		r.Header.Del(overrideAuthHeader)
+		if backend == "" && strategy == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if got, tenant, ok := auth.roleOfToken(token); !ok || got < roleOperator || tenant != "" {
+			http.Error(w, "Override headers need an operator token in "+overrideAuthHeader, http.StatusForbidden)
+			return
+		}
+		if backend != "" && b.pools.Find(backend) == nil {
+			http.Error(w, forceBackendHeader+": unknown backend "+strconv.Quote(backend), http.StatusBadRequest)
+			return
+		}
+		o := &routingOverride{backend: backend}
+		if strategy != "" {
+			st, err := strategyByName(strategy)
+			if err != nil {
+				http.Error(w, strategyHeader+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			o.strategy = st
+		}
+		slog.Info("🔧 Routing override", "client", clientIP(r), "method", r.Method, "path", r.URL.Path,
+			"backend", backend, "strategy", strategy)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), overrideKey{}, o)))
+	})
+}
+
+// acquireOverride takes a slot on the server o asks for: the forced
+// backend, wherever it is and whatever its health, or p's best by o's
+// strategy. key is the request's client key, for consistent_hash.
+func (b *Balancer) acquireOverride(p *ServerPool, o *routingOverride, key string) (s *Server, overloaded bool) {
+	if o.backend != "" {
+		routingOverrides.Inc("backend")
+		// Removed since the request came in: answer as for an empty pool.
+		if s = b.pools.Find(o.backend); s == nil {
+			return nil, false
+		}
+		if !s.limiter.Acquire() {
+			return nil, true
+		}
+		return s, false
+	}
+	routingOverrides.Inc("strategy")
+	switch o.strategy.(type) {
+	case consistentHash:
+		return b.acquireHashed(p, key)
+	case bandit:
+		return b.acquireBandit(p)
+	}
+	return acquireFrom(func(match func(*Server) bool) *Server { return p.GetServerBy(o.strategy, match) })
+}
@@ -0,0 +1,88 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+type PagerDutyConfig struct {
+	// RoutingKey is the integration key of an Events API v2 service.
+	RoutingKey string `json:"routing_key"`
+	// URL overrides the Events API endpoint (mostly for testing).
+	URL        string         `json:"url"`
+	Severities severityFilter `json:"severities"`
+}
+
+// pagerDutyNotifier triggers an incident per alert key and resolves it when
+// the matching recovery alert arrives.
+type pagerDutyNotifier struct {
+	cfg    PagerDutyConfig
+	source string
+	client *http.Client
+}
+
+func newPagerDutyNotifier(c PagerDutyConfig) (*pagerDutyNotifier, error) {
+	if c.RoutingKey == "" {
+		return nil, fmt.Errorf("routing_key is required")
+	}
+	if c.URL == "" {
+		c.URL = pagerDutyEventsURL
+	}
+	source, _ := os.Hostname()
+	if source == "" {
+		source = "loadbalancer"
+	}
+	return &pagerDutyNotifier{cfg: c, source: source, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary   string `json:"summary"`
+	Source    string `json:"source"`
+	Severity  string `json:"severity"`
+	Timestamp string `json:"timestamp"`
+	Group     string `json:"group,omitempty"`
+	Class     string `json:"class"`
+}
+
+func (n *pagerDutyNotifier) Notify(al Alert) error {
+	ev := pagerDutyEvent{RoutingKey: n.cfg.RoutingKey, DedupKey: al.Key}
+	if al.Resolved {
+		ev.EventAction = "resolve"
+	} else {
+		ev.EventAction = "trigger"
+		ev.Payload = &pagerDutyPayload{
+			Summary:   al.Message,
+			Source:    n.source,
+			Severity:  al.Severity, // our names match PagerDuty's
+			Timestamp: al.Time.Format(time.RFC3339),
+			Group:     al.Server,
+			Class:     al.Kind,
+		}
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.cfg.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pagerduty: %s", resp.Status)
+	}
+	return nil
+}
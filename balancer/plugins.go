@@ -0,0 +1,170 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"plugin"
+	"sync"
+	"time"
+
+	"github.com/loadbalancer/pluginapi"
+)
+
+// PluginConfig loads one Go plugin at startup.
+type PluginConfig struct {
+	Path string `json:"path"`
+	// Config is passed to the plugin's New as is.
+	Config json.RawMessage `json:"config,omitempty"`
+	// DiscoveryInterval is how often a Discovery plugin is polled.
+	// Defaults to 30s.
+	DiscoveryInterval Duration `json:"discovery_interval,omitempty"`
+}
+
+var (
+	pluginsMu sync.Mutex
+	// loadedPlugins caches plugins by path: ParseConfig loads them to
+	// validate strategy names, main uses the same instances.
+	loadedPlugins = map[string]pluginapi.Plugin{}
+	// healthPlugin replaces Server.Probe when set.
+	healthPlugin pluginapi.HealthChecker
+)
+
+// openPlugin is plugin.Open plus the New lookup; replaced in tests.
+var openPlugin = func(path string, config json.RawMessage) (pluginapi.Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("New")
+	if err != nil {
+		return nil, err
+	}
+	newFn, ok := sym.(func(json.RawMessage) (pluginapi.Plugin, error))
+	if !ok {
+		return nil, fmt.Errorf("New has type %T, want func(json.RawMessage) (pluginapi.Plugin, error)", sym)
+	}
+	return newFn(config)
+}
+
+// loadPlugins opens every plugin and registers its strategies and health
+// checker. It is safe to call again with the same config.
+func loadPlugins(configs []PluginConfig) ([]pluginapi.Plugin, error) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	var out []pluginapi.Plugin
+	for _, c := range configs {
+		p, ok := loadedPlugins[c.Path]
+		if !ok {
+			var err error
+			if p, err = openPlugin(c.Path, c.Config); err != nil {
+				return nil, fmt.Errorf("plugins: %s: %w", c.Path, err)
+			}
+			if err := registerPlugin(p); err != nil {
+				return nil, fmt.Errorf("plugins: %s: %w", c.Path, err)
+			}
+			loadedPlugins[c.Path] = p
+			slog.Info("🧩 Loaded plugin", "plugin", p.Name(), "path", c.Path)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func registerPlugin(p pluginapi.Plugin) error {
+	if st, ok := p.(pluginapi.Strategy); ok {
+		if _, exists := strategies[st.Name()]; exists {
+			return fmt.Errorf("strategy %q already exists", st.Name())
+		}
+		strategies[st.Name()] = pluginStrategy{st}
+	}
+	if hc, ok := p.(pluginapi.HealthChecker); ok {
+		if healthPlugin != nil {
+			return fmt.Errorf("health checker %s conflicts with %s", hc.Name(), healthPlugin.Name())
+		}
+		healthPlugin = hc
+	}
+	return nil
+}
+
+// pluginStrategy adapts a plugin strategy. Load runs under the pool lock,
+// so it reads the counters directly.
+type pluginStrategy struct{ p pluginapi.Strategy }
+
+func (ps pluginStrategy) Name() string { return ps.p.Name() }
+func (ps pluginStrategy) Load(s *Server) float64 {
+	return ps.p.Load(pluginapi.Backend{
+		Name: s.Name, URL: s.URL, Weight: s.Weight, Labels: s.Labels,
+		ActiveConnections: s.ActiveConnections, LatencyEWMA: s.LatencyEWMA,
+	})
+}
+
+// pluginProbe runs the health checker plugin instead of the HTTP probe.
+func (s *Server) pluginProbe(hc pluginapi.HealthChecker) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+	return hc.Check(ctx, pluginapi.Backend{
+		Name: s.Name, URL: s.URL, Weight: s.pool.Weight(s), Labels: s.Labels,
+		ActiveConnections: s.pool.Active(s), LatencyEWMA: s.pool.Latency(s),
+	})
+}
+
+// wrapPlugins applies middleware plugins around h, the first outermost.
+func wrapPlugins(plugins []pluginapi.Plugin, h http.Handler) http.Handler {
+	for i := len(plugins) - 1; i >= 0; i-- {
+		if mw, ok := plugins[i].(pluginapi.Middleware); ok {
+			h = mw.Wrap(h)
+		}
+	}
+	return h
+}
+
+// startDiscovery polls every Discovery plugin.
+func (b *Balancer) startDiscovery(plugins []pluginapi.Plugin, configs []PluginConfig) {
+	for i, p := range plugins {
+		d, ok := p.(pluginapi.Discovery)
+		if !ok {
+			continue
+		}
+		interval := time.Duration(configs[i].DiscoveryInterval)
+		if interval <= 0 {
+			interval = 30 * time.Second
+		}
+		// Survives restarts, so a restarted watcher keeps managing the
+		// servers it added before.
+		ts := newTargetSync(b.pools, d.Name())
+		name := "discovery:" + d.Name()
+		b.watchdog.watch(name, every(3, interval+10*time.Second), func(beat func()) func() {
+			done := make(chan struct{})
+			go func() {
+				defer recoverJob(name)
+				t := time.NewTicker(interval)
+				defer t.Stop()
+				for {
+					pollDiscovery(d, ts)
+					beat()
+					select {
+					case <-done:
+						return
+					case <-t.C:
+					}
+				}
+			}()
+			return func() { close(done) }
+		})
+	}
+}
+
+func pollDiscovery(d pluginapi.Discovery, ts *targetSync) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	targets, err := d.Discover(ctx)
+	if err != nil {
+		// Keep what we have; an outage of the source shouldn't empty the pool.
+		slog.Warn("⚠️ Discovery failed", "discovery", d.Name(), "err", err)
+		return
+	}
+	ts.apply(targets)
+}
@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type PoolEventType string
+
+const (
+	ServerAdded   PoolEventType = "server_added"
+	ServerRemoved PoolEventType = "server_removed"
+	HealthChanged PoolEventType = "health_changed"
+	Selected      PoolEventType = "selected"
+)
+
+type PoolEvent struct {
+	Type   PoolEventType
+	Server *Server
+	// Healthy is the new state for HealthChanged events.
+	Healthy bool
+	Time    time.Time
+}
+
+// poolSubscribers fans events out to Subscribe channels. Sends never
+// block: a subscriber that falls behind loses events, counted in Dropped.
+type poolSubscribers struct {
+	count atomic.Int32 // fast path: skip building events nobody wants
+
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]chan PoolEvent
+
+	dropped atomic.Int64
+}
+
+// Subscribe returns a channel of pool events buffered to buffer entries
+// and a function that unsubscribes and closes the channel.
+func (p *ServerPool) Subscribe(buffer int) (<-chan PoolEvent, func()) {
+	ps := &p.events
+	ch := make(chan PoolEvent, buffer)
+	ps.mu.Lock()
+	if ps.subs == nil {
+		ps.subs = make(map[int]chan PoolEvent)
+	}
+	id := ps.nextID
+	ps.nextID++
+	ps.subs[id] = ch
+	ps.count.Add(1)
+	ps.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ps.mu.Lock()
+			delete(ps.subs, id)
+			ps.count.Add(-1)
+			close(ch)
+			ps.mu.Unlock()
+		})
+	}
+}
+
+// DroppedEvents is how many events subscribers missed because their
+// channel was full.
+func (p *ServerPool) DroppedEvents() int64 { return p.events.dropped.Load() }
+
+// PublishHealth announces a health transition of s. The health checker
+// calls it; embedders running their own checks can too.
+func (p *ServerPool) PublishHealth(s *Server, healthy bool) {
+	p.publish(HealthChanged, s, healthy)
+}
+
+func (p *ServerPool) publish(typ PoolEventType, s *Server, healthy bool) {
+	ps := &p.events
+	if ps.count.Load() == 0 {
+		return
+	}
+	ev := PoolEvent{Type: typ, Server: s, Healthy: healthy, Time: time.Now()}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, ch := range ps.subs {
+		select {
+		case ch <- ev:
+		default:
+			ps.dropped.Add(1)
+		}
+	}
+}
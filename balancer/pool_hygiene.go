@@ -0,0 +1,32 @@
+package balancer
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// poolRepairs counts every fix made by the hygiene task since startup.
+var poolRepairs atomic.Int64
+
+func (b *Balancer) checkPoolHygiene() {
+	for _, p := range b.pools.Pools() {
+		for _, fix := range p.Repair(b.pools.Members(p)) {
+			poolRepairs.Add(1)
+			slog.Warn("🧹 Pool hygiene repaired the heap", "pool", p.name, "fix", fix)
+		}
+	}
+}
+
+func (b *Balancer) startPoolHygiene(interval time.Duration, beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(interval).Do(func() {
+		defer recoverJob("pool_hygiene")
+		b.checkPoolHygiene()
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
@@ -0,0 +1,16 @@
+package balancer
+
+import (
+	"os"
+	"strconv"
+)
+
+// workerEnv carries a worker's number from the supervisor to the worker.
+const workerEnv = "LB_WORKER"
+
+// workerID is this process's worker number, or 0 for the supervisor and
+// for the normal single-process mode.
+func workerID() int {
+	id, _ := strconv.Atoi(os.Getenv(workerEnv))
+	return id
+}
@@ -0,0 +1,118 @@
+//go:build linux
+
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// superviseWorkers runs n copies of the balancer and restarts them when
+// they die. Each one binds the listener itself with SO_REUSEPORT, so the
+// kernel spreads connections across them. Signals sent to the supervisor
+// are passed on to every worker. It only returns to exit.
+func superviseWorkers(n int) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error starting workers", "err", err)
+	}
+	var (
+		mu       sync.Mutex
+		procs    = make([]*os.Process, n+1)
+		stopping bool
+		wg       sync.WaitGroup
+	)
+	for id := 1; id <= n; id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backoff := time.Second
+			for {
+				cmd := exec.Command(exe, os.Args[1:]...)
+				cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", workerEnv, id))
+				cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+				// Workers must not outlive a supervisor killed with SIGKILL.
+				cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+				mu.Lock()
+				if stopping {
+					mu.Unlock()
+					return
+				}
+				err := cmd.Start()
+				if err == nil {
+					procs[id] = cmd.Process
+				}
+				mu.Unlock()
+				if err != nil {
+					slog.Warn("⚠️ Starting worker failed", "worker", id, "err", err)
+				} else {
+					slog.Info("👷 Worker started", "worker", id, "pid", cmd.Process.Pid)
+					start := time.Now()
+					err = cmd.Wait()
+					if time.Since(start) > time.Minute {
+						backoff = time.Second
+					}
+				}
+				mu.Lock()
+				procs[id] = nil
+				done := stopping
+				mu.Unlock()
+				if done {
+					return
+				}
+				slog.Error("💥 Worker exited, restarting it", "worker", id, "err", err, "backoff", backoff)
+				time.Sleep(backoff)
+				backoff = min(2*backoff, 30*time.Second)
+			}
+		}()
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range ch {
+		mu.Lock()
+		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+			stopping = true
+			sig = syscall.SIGTERM
+		}
+		for _, p := range procs {
+			if p != nil {
+				p.Signal(sig)
+			}
+		}
+		mu.Unlock()
+		if stopping {
+			slog.Info("👋 Stopping workers", "workers", n)
+			wg.Wait()
+			if *pidFile != "" {
+				removePIDFile(*pidFile)
+			}
+			os.Exit(0)
+		}
+	}
+}
+
+// listenReusePort binds addr with SO_REUSEPORT so every worker can hold
+// its own listener on the same port.
+func listenReusePort(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
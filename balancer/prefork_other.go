@@ -0,0 +1,16 @@
+//go:build !linux
+
+package balancer
+
+import (
+	"errors"
+	"net"
+)
+
+func superviseWorkers(int) {
+	fatal("-workers is only supported on Linux")
+}
+
+func listenReusePort(string) (net.Listener, error) {
+	return nil, errors.New("SO_REUSEPORT listeners are only supported on Linux")
+}
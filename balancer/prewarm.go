@@ -0,0 +1,77 @@
+package balancer
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PrewarmConfig keeps a number of idle keep-alive connections open to each
+// healthy backend, so the first requests after a quiet period don't pay
+// for TCP and TLS handshakes.
+type PrewarmConfig struct {
+	Connections int `json:"connections"`
+	// Interval is how often the pool is topped up. It must be below the
+	// transport's 90s idle timeout. Defaults to 30s.
+	Interval Duration `json:"interval"`
+}
+
+func (c *PrewarmConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval)
+	}
+	return 30 * time.Second
+}
+
+// warmServer opens up to n connections to s by sending n concurrent HEAD
+// requests. Idle connections are reused, so only the missing ones are
+// dialed, and all n go back to the idle pool afterwards.
+func warmServer(s *Server, n int) int {
+	client := http.Client{Transport: s.ReverseProxy.Transport, Timeout: 5 * time.Second}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Head(s.URL)
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			mu.Lock()
+			ok++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return ok
+}
+
+func (b *Balancer) startPrewarm(c PrewarmConfig) {
+	warm := func() {
+		for _, s := range b.pools.Servers() {
+			// Standby servers of a subset are left cold on purpose.
+			if s.CheckHealth() && !s.IsDisabled() && !s.pool.InStandby(s) {
+				if got := warmServer(s, c.Connections); got < c.Connections {
+					slog.Warn("⚠️ Prewarmed fewer connections than asked", "server", s.Name, "prewarmed", got, "wanted", c.Connections)
+				}
+			}
+		}
+	}
+	go func() {
+		warm()
+		t := time.NewTicker(c.interval())
+		defer t.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-t.C:
+				warm()
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// PriorityConfig gives each priority class its own concurrency budget and
+// a point at which it is shed. With the defaults, batch traffic is refused
+// once the balancer is 70% busy, normal at 90%, and critical only when
+// completely full.
+type PriorityConfig struct {
+	// MaxInFlight is the total number of concurrent proxied requests.
+	MaxInFlight int                            `json:"max_in_flight"`
+	Classes     map[string]PriorityClassConfig `json:"classes"`
+}
+
+type PriorityClassConfig struct {
+	// MaxInFlight caps this class alone. Zero means only the total applies.
+	MaxInFlight int `json:"max_in_flight"`
+	// ShedAt is the fraction of the total budget in use above which new
+	// requests of this class are refused.
+	ShedAt float64 `json:"shed_at"`
+}
+
+const defaultClass = "normal"
+
+var builtinClasses = map[string]PriorityClassConfig{
+	"critical": {ShedAt: 1.0},
+	"normal":   {ShedAt: 0.9},
+	"batch":    {ShedAt: 0.7},
+}
+
+type classState struct {
+	name     string
+	limit    int64
+	shedAt   float64
+	inflight atomic.Int64
+}
+
+type admission struct {
+	pools   *PoolManager // for Retry-After estimates
+	routes  []RouteConfig
+	max     int64
+	total   atomic.Int64
+	classes map[string]*classState
+}
+
+var shedRequests = newCounterVec("lb_shed_total",
+	"Requests refused by priority admission, by class.", "class")
+
+func newAdmission(c PriorityConfig, routes []RouteConfig, pools *PoolManager) *admission {
+	a := &admission{pools: pools, routes: routes, max: int64(c.MaxInFlight), classes: map[string]*classState{}}
+	for name, cc := range builtinClasses {
+		a.classes[name] = &classState{name: name, shedAt: cc.ShedAt}
+	}
+	for name, cc := range c.Classes {
+		cs := a.classes[name]
+		if cs == nil {
+			cs = &classState{name: name, shedAt: 1.0}
+			a.classes[name] = cs
+		}
+		cs.limit = int64(cc.MaxInFlight)
+		if cc.ShedAt > 0 {
+			cs.shedAt = cc.ShedAt
+		}
+	}
+	return a
+}
+
+type classKey struct{}
+
+// requestClass is the priority class admission assigned to r.
+func requestClass(r *http.Request) string {
+	if c, ok := r.Context().Value(classKey{}).(string); ok {
+		return c
+	}
+	return defaultClass
+}
+
+func (a *admission) classify(r *http.Request) *classState {
+	if rc := matchRoute(a.routes, r); rc != nil && rc.Class != "" {
+		return a.classes[rc.Class]
+	}
+	return a.classes[defaultClass]
+}
+
+// admit reserves a slot for class c. When c is being shed it returns
+// false, with classFull set if c's own budget rather than the overall load
+// was the reason.
+func (a *admission) admit(c *classState) (ok, classFull bool) {
+	total := a.total.Add(1)
+	if a.max > 0 && float64(total) > c.shedAt*float64(a.max) {
+		a.total.Add(-1)
+		return false, false
+	}
+	if n := c.inflight.Add(1); c.limit > 0 && n > c.limit {
+		c.inflight.Add(-1)
+		a.total.Add(-1)
+		return false, true
+	}
+	return true, false
+}
+
+func (a *admission) release(c *classState) {
+	c.inflight.Add(-1)
+	a.total.Add(-1)
+}
+
+func (a *admission) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := a.classify(r)
+		ok, classFull := a.admit(c)
+		if !ok {
+			shedRequests.Inc(c.name)
+			slog.Warn("⏸️ Shedding request", "class", c.name, "method", r.Method, "path", r.URL.Path)
+			if classFull {
+				// The class is over its own budget: tell the client to slow
+				// down rather than that we're broken.
+				writeBackpressure(w, http.StatusTooManyRequests, a.pools.retryAfter(c.inflight.Load()-c.limit+1, c.limit), c.limit)
+				return
+			}
+			threshold := int64(c.shedAt * float64(a.max))
+			writeBackpressure(w, http.StatusServiceUnavailable, a.pools.retryAfter(a.total.Load()-threshold+1, threshold), 0)
+			return
+		}
+		defer a.release(c)
+		if c.limit > 0 {
+			w.Header().Set("RateLimit-Limit", strconv.FormatInt(c.limit, 10))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatInt(max(0, c.limit-c.inflight.Load()), 10))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), classKey{}, c.name)))
+	})
+}
@@ -0,0 +1,161 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrorKind classifies why a proxied request failed. It is used in logs,
+// as the "kind" metrics label and in /stats.
+type ErrorKind string
+
+const (
+	ErrDial             ErrorKind = "dial_error"
+	ErrTLS              ErrorKind = "tls_error"
+	ErrTimeout          ErrorKind = "timeout"
+	ErrBackend5xx       ErrorKind = "backend_5xx"
+	ErrNoHealthyBackend ErrorKind = "no_healthy_backend"
+	ErrOverloaded       ErrorKind = "overloaded"
+	ErrClientAbort      ErrorKind = "client_abort"
+	ErrDeadlineExceeded ErrorKind = "deadline_exceeded"
+	ErrProxy            ErrorKind = "proxy_error"
+)
+
+// ProxyError is a classified failure talking to a backend.
+type ProxyError struct {
+	Kind    ErrorKind
+	Backend string
+	Err     error
+}
+
+// log writes e to the log as a warning.
+func (e *ProxyError) log() {
+	if e.Backend == "" {
+		slog.Warn("❌ Proxy error", "kind", string(e.Kind), "err", e.Err)
+		return
+	}
+	slog.Warn("❌ Proxy error", "kind", string(e.Kind), "backend", e.Backend, "err", e.Err)
+}
+
+func (e *ProxyError) Error() string {
+	if e.Backend == "" {
+		return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	}
+	return fmt.Sprintf("backend %s: %s: %v", e.Backend, e.Kind, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error { return e.Err }
+
+var proxyErrors = newCounterVec("lb_proxy_errors_total",
+	"Failed proxied requests by error kind and backend.", "kind", "backend")
+
+func classifyError(r *http.Request, err error) ErrorKind {
+	var (
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+		opErr      *net.OpError
+		netErr     net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled) || (r != nil && r.Context().Err() == context.Canceled):
+		return ErrClientAbort
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return ErrTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrDial
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	}
+	return ErrProxy
+}
+
+// recentErrorsSize is how many errors each server remembers for the
+// dashboard's server page.
+const recentErrorsSize = 10
+
+type lastError struct {
+	Kind    ErrorKind `json:"kind"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+func (s *Server) recordError(perr *ProxyError) {
+	s.mux.Lock()
+	if s.errorCounts == nil {
+		s.errorCounts = make(map[ErrorKind]int64)
+	}
+	s.errorCounts[perr.Kind]++
+	s.lastErr = &lastError{Kind: perr.Kind, Message: perr.Err.Error(), Time: time.Now()}
+	if len(s.recentErrs) == recentErrorsSize {
+		s.recentErrs = append(s.recentErrs[:0], s.recentErrs[1:]...)
+	}
+	s.recentErrs = append(s.recentErrs, *s.lastErr)
+	s.mux.Unlock()
+	countError(perr)
+}
+
+// RecentErrors returns the last errors of s, oldest first.
+func (s *Server) RecentErrors() []lastError {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return append([]lastError{}, s.recentErrs...)
+}
+
+// countError feeds the error metric and, unless the client gave up on its
+// own, the error rate in /stats/summary.
+func countError(perr *ProxyError) {
+	proxyErrors.Inc(string(perr.Kind), perr.Backend)
+	statsd.Count("errors", 1, "kind:"+string(perr.Kind), "backend:"+perr.Backend)
+	if perr.Kind != ErrClientAbort {
+		errorWindow.Inc()
+	}
+}
+
+// ErrorStats returns a copy of the per-kind error counts and the most
+// recent error, if any.
+func (s *Server) ErrorStats() (map[ErrorKind]int64, *lastError) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	counts := make(map[ErrorKind]int64, len(s.errorCounts))
+	for k, v := range s.errorCounts {
+		counts[k] = v
+	}
+	return counts, s.lastErr
+}
+
+// proxyErrorHandler replaces ReverseProxy's default "502 and log" with a
+// classified error.
+func (s *Server) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	perr := &ProxyError{Kind: classifyError(r, err), Backend: s.Name, Err: err}
+	s.recordError(perr)
+	if perr.Kind == ErrClientAbort {
+		// Nobody is listening for a response any more.
+		return
+	}
+	perr.log()
+	status := http.StatusBadGateway
+	if perr.Kind == ErrTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// checkBackendStatus counts 5xx answers from the backend as errors too.
+func (s *Server) checkBackendStatus(resp *http.Response) error {
+	if resp.StatusCode >= 500 {
+		s.recordError(&ProxyError{Kind: ErrBackend5xx, Backend: s.Name, Err: errors.New(resp.Status)})
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package balancer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocolConfig accepts HAProxy PROXY protocol (v1 text and v2
+// binary) headers on the listener, so the real client address survives an
+// L4 load balancer in front of us.
+type ProxyProtocolConfig struct {
+	// TrustedCIDRs are the peers allowed to send a PROXY header. Headers
+	// from anyone else are not parsed. Required: trusting every peer would
+	// let any client forge its address.
+	TrustedCIDRs []string `json:"trusted_cidrs"`
+	// HeaderTimeout bounds how long we wait for the header. Defaults to 5s.
+	HeaderTimeout Duration `json:"header_timeout"`
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// parseCIDRs accepts CIDRs ("10.0.0.0/8") and bare addresses ("10.0.0.1").
+func parseCIDRs(list []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap().WithZone("")
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	// Zoned addresses never match a prefix.
+	addr = addr.Unmap().WithZone("")
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyProtoListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+func (c *ProxyProtocolConfig) validate() error {
+	if len(c.TrustedCIDRs) == 0 {
+		return errors.New("proxy_protocol: trusted_cidrs is required")
+	}
+	_, err := parseCIDRs(c.TrustedCIDRs)
+	if err != nil {
+		return fmt.Errorf("proxy_protocol: %w", err)
+	}
+	return nil
+}
+
+func newProxyProtoListener(ln net.Listener, c ProxyProtocolConfig) (net.Listener, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	trusted, _ := parseCIDRs(c.TrustedCIDRs)
+	timeout := time.Duration(c.HeaderTimeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &proxyProtoListener{Listener: ln, trusted: trusted, timeout: timeout}, nil
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	peer, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil || !prefixesContain(l.trusted, peer.Addr()) {
+		return c, nil
+	}
+	return &proxyProtoConn{Conn: c, r: bufio.NewReader(c), timeout: l.timeout}, nil
+}
+
+// proxyProtoConn reads the header lazily on first use, so a slow peer only
+// blocks its own connection goroutine and never the Accept loop.
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes a v1 or v2 header if one is present. It returns
+// a nil address when there's no header or it carries no address (v1
+// UNKNOWN, v2 LOCAL); the connection's own address applies then.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, nil
+	}
+	switch first[0] {
+	case 'P':
+		if b, _ := r.Peek(6); bytes.Equal(b, []byte("PROXY ")) {
+			return readProxyV1(r)
+		}
+	case '\r':
+		if b, _ := r.Peek(len(proxyV2Signature)); bytes.Equal(b, proxyV2Signature) {
+			return readProxyV2(r)
+		}
+	}
+	return nil, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The spec caps a v1 line at 107 bytes including CRLF.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy protocol v1: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol v1: header too long")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol v1: malformed header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: %w", err)
+	}
+	if (fields[1] == "TCP4") != ip.Is4() {
+		return nil, fmt.Errorf("proxy protocol v1: %s does not match address %s", fields[1], fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: bad port %q", fields[4])
+	}
+	// TCP6 may carry an IPv4-mapped address; report it as the IPv4 one.
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	verCmd, family := hdr[12], hdr[13]
+	length := int(binary.BigEndian.Uint16(hdr[14:16]))
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported version %d", verCmd>>4)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+
+	switch verCmd & 0x0F {
+	case 0x0:
+		// LOCAL: health checks from the proxy itself, keep the real address.
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("proxy protocol v2: unsupported command %#x", verCmd&0x0F)
+	}
+	// Only STREAM (TCP) makes sense in front of an HTTP listener.
+	if transport := family & 0x0F; transport != 0x1 && family>>4 != 0x0 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported transport %#x", transport)
+	}
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("proxy protocol v2: short IPv4 address block")
+		}
+		ip := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[8:10]))), nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("proxy protocol v2: short IPv6 address block")
+		}
+		ip := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(body[32:34]))), nil
+	}
+	// AF_UNIX / AF_UNSPEC carry nothing useful for an HTTP client IP.
+	return nil, nil
+}
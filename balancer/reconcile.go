@@ -0,0 +1,200 @@
+package balancer
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/loadbalancer/pluginapi"
+)
+
+// reconcileDrainTimeout is how long a server its source no longer reports
+// may keep finishing requests before it is removed anyway.
+const reconcileDrainTimeout = 30 * time.Second
+
+var reconcileChanges = newCounterVec("lb_reconcile_changes_total",
+	"Servers added, updated, replaced and removed by config reloads and discovery.", "source", "change")
+
+// targetSync reconciles the servers one source (the config file, a
+// discovery plugin, xDS) reports with the pools. It only ever changes or
+// removes servers it added itself. Servers it keeps are updated in place,
+// so their counters, health history and stats carry on; servers it drops
+// are drained first.
+type targetSync struct {
+	pools  *PoolManager
+	source string
+	// drainTimeout bounds how long a dropped server drains.
+	drainTimeout time.Duration
+
+	mu    sync.Mutex
+	owned map[string]bool
+	// draining are the dropped servers still finishing their requests.
+	draining map[string]*Server
+}
+
+func newTargetSync(pools *PoolManager, source string) *targetSync {
+	return &targetSync{pools: pools, source: source, drainTimeout: reconcileDrainTimeout,
+		owned: map[string]bool{}, draining: map[string]*Server{}}
+}
+
+// A syncPlan is what it takes to bring a source's servers in line with
+// what it reports now. Reweigh changes only the weight, in place. A new
+// URL, pool or labels needs a new server, so replace swaps it in.
+// Revive takes back servers that were draining after an earlier removal.
+type syncPlan struct {
+	add, reweigh, replace, revive []pluginapi.Target
+	remove                        []string
+}
+
+// plan compares targets with the servers ts owns. Targets naming an
+// unknown pool are logged and left out.
+func (ts *targetSync) plan(targets []pluginapi.Target) syncPlan {
+	var sp syncPlan
+	seen := map[string]bool{}
+	for _, t := range targets {
+		seen[t.Name] = true
+		p := ts.pools.Get(t.Pool)
+		if p == nil {
+			slog.Warn("⚠️ Discovered server names an unknown pool", "source", ts.source, "server", t.Name, "pool", t.Pool)
+			continue
+		}
+		s := ts.pools.Find(t.Name)
+		switch {
+		case ts.draining[t.Name] != nil && ts.draining[t.Name] == s && ts.matches(s, p, t):
+			sp.revive = append(sp.revive, t)
+		case !ts.owned[t.Name] || s == nil:
+			sp.add = append(sp.add, t)
+		case !ts.matches(s, p, t):
+			sp.replace = append(sp.replace, t)
+		case t.Weight > 0 && p.Weight(s) != t.Weight:
+			sp.reweigh = append(sp.reweigh, t)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(ts.owned)) {
+		if !seen[name] {
+			sp.remove = append(sp.remove, name)
+		}
+	}
+	return sp
+}
+
+// matches says whether s is what t describes, apart from its weight.
+func (ts *targetSync) matches(s *Server, p *ServerPool, t pluginapi.Target) bool {
+	if s.pool != p || s.URL != t.URL {
+		return false
+	}
+	labels := maps.Clone(p.config.Labels)
+	if len(t.Labels) > 0 {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		maps.Copy(labels, t.Labels)
+	}
+	return maps.Equal(s.Labels, labels)
+}
+
+// apply reconciles the pools with targets and returns what it changed.
+// Removed servers drain in the background.
+func (ts *targetSync) apply(targets []pluginapi.Target) syncPlan {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	sp := ts.plan(targets)
+	for _, t := range sp.revive {
+		s := ts.draining[t.Name]
+		delete(ts.draining, t.Name)
+		ts.owned[t.Name] = true
+		// Unless an operator disabled it meanwhile.
+		ts.pools.setState(s, StateActive, StateDraining)
+		if t.Weight > 0 && s.pool.Weight(s) != t.Weight {
+			s.pool.SetWeight(s, t.Weight)
+		}
+		slog.Info("↩️ Server reported again, ending its drain", "server", t.Name, "source", ts.source)
+	}
+	for _, t := range sp.reweigh {
+		s := ts.pools.Find(t.Name)
+		s.pool.SetWeight(s, t.Weight)
+		slog.Info("⚖️ Server weight updated", "server", t.Name, "weight", t.Weight, "source", ts.source)
+	}
+	for _, t := range sp.replace {
+		// The name can only be registered once, so the old server goes
+		// at once. Its in-flight requests still finish.
+		ts.pools.Unregister(t.Name)
+		delete(ts.owned, t.Name)
+		if ts.register(t) {
+			slog.Info("🔁 Server replaced", "server", t.Name, "url", t.URL, "pool", t.Pool, "source", ts.source)
+		}
+	}
+	for _, t := range sp.add {
+		if s := ts.draining[t.Name]; s != nil {
+			// Back under a new address while the old one drains.
+			delete(ts.draining, t.Name)
+			if ts.pools.Find(t.Name) == s {
+				ts.pools.Unregister(t.Name)
+			}
+		}
+		if ts.register(t) {
+			slog.Info("➕ Server added", "server", t.Name, "source", ts.source)
+		}
+	}
+	for _, name := range sp.remove {
+		delete(ts.owned, name)
+		s := ts.pools.Find(name)
+		if s == nil {
+			continue
+		}
+		ts.draining[name] = s
+		ts.pools.Drain(s)
+		if s.pool.Active(s) == 0 {
+			ts.finishDrain(s)
+			continue
+		}
+		slog.Info("⏸️ Server no longer in its source, draining", "server", name, "in_flight", s.pool.Active(s), "source", ts.source)
+		go ts.drain(s)
+	}
+	reconcileChanges.Add(float64(len(sp.add)), ts.source, "added")
+	reconcileChanges.Add(float64(len(sp.reweigh)+len(sp.revive)), ts.source, "updated")
+	reconcileChanges.Add(float64(len(sp.replace)), ts.source, "replaced")
+	reconcileChanges.Add(float64(len(sp.remove)), ts.source, "removed")
+	return sp
+}
+
+func (ts *targetSync) register(t pluginapi.Target) bool {
+	p := ts.pools.Get(t.Pool)
+	s := p.newServer(ServerConfig{Name: t.Name, URL: t.URL, Weight: t.Weight, Labels: t.Labels})
+	if err := ts.pools.Register(s); err != nil {
+		slog.Warn("⚠️ Discovered server not added", "source", ts.source, "err", err)
+		return false
+	}
+	ts.owned[t.Name] = true
+	return true
+}
+
+// drain waits for s's requests to finish, or the drain timeout, and then
+// removes it unless it was reported again meanwhile.
+func (ts *targetSync) drain(s *Server) {
+	deadline := time.Now().Add(ts.drainTimeout)
+	for s.pool.Active(s) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.draining[s.Name] == s {
+		ts.finishDrain(s)
+	}
+}
+
+// finishDrain removes a drained server. The caller holds ts.mu.
+func (ts *targetSync) finishDrain(s *Server) {
+	delete(ts.draining, s.Name)
+	if ts.pools.Find(s.Name) == s {
+		ts.pools.Unregister(s.Name)
+	}
+	if n := s.pool.Active(s); n > 0 {
+		drainsTimedOut.Inc(s.pool.name)
+		slog.Warn("⚠️ Drain timed out, removing the server with requests in flight", "server", s.Name, "in_flight", n, "source", ts.source)
+		return
+	}
+	slog.Info("➖ Server removed, no longer in its source", "server", s.Name, "source", ts.source)
+}
@@ -0,0 +1,39 @@
+package balancer
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+var handlerPanics = newCounterVec("lb_handler_panics_total",
+	"Panics recovered while serving a request.")
+
+// recoverPanics turns a panic anywhere in the handler chain into a 502
+// and a logged stack trace, instead of the connection just being dropped.
+// If the response had already started it can only be cut short.
+// http.ErrAbortHandler, which ReverseProxy uses to abort a response when
+// the backend goes away mid-body, is passed on as is.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := getStatusWriter(w)
+		defer putStatusWriter(sw)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			handlerPanics.Inc()
+			slog.Error("💥 Panic serving request", "method", r.Method, "path", r.URL.Path, "client", clientIP(r), "panic", v, "stack", string(debug.Stack()))
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,134 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// RedactConfig removes sensitive JSON fields from request bodies before
+// they are copied anywhere other than the backend, such as logs, mirrors
+// or recordings. The body sent to the backend is never changed.
+type RedactConfig struct {
+	// Fields to redact. A bare name ("password") matches at any depth; a
+	// dotted path ("user.token") matches from the top of the document.
+	// Arrays are looked through.
+	Fields []string `json:"fields"`
+	// Action is "mask" (default), which replaces the value with Mask, or
+	// "strip", which drops the field.
+	Action string `json:"action"`
+	// Mask defaults to "[REDACTED]".
+	Mask string `json:"mask"`
+}
+
+// maxRedactBody bounds how much of a body is read for a redacted copy.
+const maxRedactBody = 1 << 20
+
+func (c *RedactConfig) validate() error {
+	if len(c.Fields) == 0 {
+		return errors.New("redact: fields is required")
+	}
+	for _, f := range c.Fields {
+		if f == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") || strings.Contains(f, "..") {
+			return fmt.Errorf("redact: bad field %q", f)
+		}
+	}
+	switch c.Action {
+	case "", "mask", "strip":
+	default:
+		return fmt.Errorf("redact: unknown action %q", c.Action)
+	}
+	return nil
+}
+
+// redact returns a copy of a JSON body with the configured fields masked
+// or stripped. ok is false for bodies that aren't JSON or don't parse;
+// those must not be copied anywhere, since we can't tell what's in them.
+func (c *RedactConfig) redact(contentType string, body []byte) (out []byte, ok bool) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body, true
+	}
+	if mt, _, _ := mime.ParseMediaType(contentType); contentType != "" && mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil || dec.More() {
+		return nil, false
+	}
+	doc = c.walk(doc, nil)
+	out, err := json.Marshal(doc)
+	return out, err == nil
+}
+
+func (c *RedactConfig) walk(v any, path []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, fv := range v {
+			p := append(path, k)
+			if !c.matches(p) {
+				v[k] = c.walk(fv, p)
+			} else if c.Action == "strip" {
+				delete(v, k)
+			} else {
+				v[k] = c.mask()
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = c.walk(v[i], path)
+		}
+	}
+	return v
+}
+
+func (c *RedactConfig) matches(path []string) bool {
+	for _, f := range c.Fields {
+		if !strings.Contains(f, ".") {
+			if strings.EqualFold(f, path[len(path)-1]) {
+				return true
+			}
+		} else if strings.EqualFold(f, strings.Join(path, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RedactConfig) mask() string {
+	if c.Mask == "" {
+		return "[REDACTED]"
+	}
+	return c.Mask
+}
+
+// redactedBody is what features that copy request bodies (logging,
+// mirroring, recording) should use: it reads r's body, puts it back for
+// the proxy untouched, and returns the redacted copy for r's route. With
+// no redaction configured for the route the body is returned as is. ok
+// is false when the body must not be copied: it isn't JSON, doesn't parse
+// or is larger than 1MB.
+func (b *Balancer) redactedBody(r *http.Request) (out []byte, ok bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRedactBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil || len(body) > maxRedactBody {
+		return nil, false
+	}
+	rc := matchRoute(b.cfg.Routes, r)
+	if rc == nil || rc.Redact == nil {
+		return body, true
+	}
+	return rc.Redact.redact(r.Header.Get("Content-Type"), body)
+}
@@ -0,0 +1,389 @@
+package balancer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// RegistrationConfig lets backends add themselves: they POST /register
+// on startup, heartbeat while they run and DELETE their registration on
+// shutdown. Servers whose heartbeats stop are removed too. Either way they
+// are drained first. Meant for autoscaled backends nobody wants to list in
+// the config.
+type RegistrationConfig struct {
+	Tokens []RegistrationToken `json:"tokens"`
+	// DefaultTTL is how long a registration lasts without a heartbeat
+	// when the backend doesn't ask for a TTL. Defaults to 30s.
+	DefaultTTL Duration `json:"default_ttl"`
+	// MaxTTL caps the TTL a backend may ask for. Defaults to 10m.
+	MaxTTL Duration `json:"max_ttl"`
+	// DrainTimeout is how long a leaving server's in-flight requests get
+	// to finish before it is removed anyway. Defaults to 30s.
+	DrainTimeout Duration `json:"drain_timeout"`
+	// Webhook is told about every server that leaves, once it has been
+	// drained. Its severities are ignored.
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+}
+
+// RegistrationToken is what backends present as "Authorization: Bearer
+// <token>". It only lets them register, and only into Pools (any pool if
+// empty).
+type RegistrationToken struct {
+	Token string   `json:"token"`
+	Pools []string `json:"pools,omitempty"`
+}
+
+func (c *RegistrationConfig) validate() error {
+	if len(c.Tokens) == 0 {
+		return fmt.Errorf("registration: at least one token is required")
+	}
+	seen := map[string]bool{}
+	for i, t := range c.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("registration: tokens[%d]: empty token", i)
+		}
+		if seen[t.Token] {
+			return fmt.Errorf("registration: tokens[%d]: token used twice", i)
+		}
+		seen[t.Token] = true
+	}
+	if c.DefaultTTL < 0 || c.MaxTTL < 0 {
+		return fmt.Errorf("registration: default_ttl and max_ttl can't be negative")
+	}
+	if c.MaxTTL > 0 && c.DefaultTTL > c.MaxTTL {
+		return fmt.Errorf("registration: default_ttl is longer than max_ttl")
+	}
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("registration: drain_timeout can't be negative")
+	}
+	if c.Webhook != nil {
+		if _, err := newWebhookNotifier(*c.Webhook); err != nil {
+			return fmt.Errorf("registration: webhook: %w", err)
+		}
+	}
+	return nil
+}
+
+// registrationSweepInterval is how often expired registrations are
+// removed.
+const registrationSweepInterval = time.Second
+
+// drainPollInterval is how often a draining server's in-flight requests
+// are counted.
+const drainPollInterval = 50 * time.Millisecond
+
+var (
+	registrationsExpired = newCounterVec("lb_registrations_expired_total",
+		"Self-registered servers removed because their heartbeats stopped.", "pool")
+	drainsTimedOut = newCounterVec("lb_registration_drain_timeouts_total",
+		"Leaving self-registered servers removed with requests still in flight.", "pool")
+)
+
+// registrar keeps track of the servers that registered themselves and
+// when each one expires. It only ever changes or removes those.
+type registrar struct {
+	cfg          RegistrationConfig
+	pools        *PoolManager
+	defaultTTL   time.Duration
+	maxTTL       time.Duration
+	drainTimeout time.Duration
+	// webhook is nil without registration.webhook.
+	webhook *webhookNotifier
+
+	mu      sync.Mutex
+	entries map[string]*registration
+}
+
+type registration struct {
+	server  *Server
+	ttl     time.Duration
+	expires time.Time
+}
+
+func newRegistrar(c RegistrationConfig, pools *PoolManager) (*registrar, error) {
+	r := &registrar{cfg: c, pools: pools, defaultTTL: 30 * time.Second, maxTTL: 10 * time.Minute,
+		drainTimeout: 30 * time.Second, entries: map[string]*registration{}}
+	if c.DefaultTTL > 0 {
+		r.defaultTTL = time.Duration(c.DefaultTTL)
+	}
+	if c.MaxTTL > 0 {
+		r.maxTTL = time.Duration(c.MaxTTL)
+	}
+	if c.DrainTimeout > 0 {
+		r.drainTimeout = time.Duration(c.DrainTimeout)
+	}
+	if c.Webhook != nil {
+		var err error
+		if r.webhook, err = newWebhookNotifier(*c.Webhook); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// token returns the registration token r presents, or nil.
+func (rg *registrar) token(r *http.Request) *RegistrationToken {
+	tok, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || tok == "" {
+		return nil
+	}
+	for i, t := range rg.cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(tok)) == 1 {
+			return &rg.cfg.Tokens[i]
+		}
+	}
+	return nil
+}
+
+func (rg *registrar) authorize(h func(http.ResponseWriter, *http.Request, *RegistrationToken)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := rg.token(r)
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r, t)
+	}
+}
+
+func (rg *registrar) registerRoutes(mux *http.ServeMux) {
+	if rg == nil {
+		return
+	}
+	mux.HandleFunc("POST /register", rg.authorize(rg.handleRegister))
+	mux.HandleFunc("POST /register/{name}/heartbeat", rg.authorize(rg.handleHeartbeat))
+	mux.HandleFunc("DELETE /register/{name}", rg.authorize(rg.handleDeregister))
+}
+
+type registerBody struct {
+	ServerConfig
+	// Pool defaults to the default pool.
+	Pool string `json:"pool"`
+	// TTL is how long the registration lasts without a heartbeat.
+	TTL Duration `json:"ttl"`
+}
+
+type registrationInfo struct {
+	Name    string    `json:"name"`
+	Pool    string    `json:"pool"`
+	TTL     Duration  `json:"ttl"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleRegister adds the backend, or refreshes it if it is registered
+// already. Registering again with a new URL or pool replaces it.
+func (rg *registrar) handleRegister(w http.ResponseWriter, r *http.Request, t *RegistrationToken) {
+	var c registerBody
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.Name == "" || c.URL == "" {
+		http.Error(w, "Bad Request: name and url are required", http.StatusBadRequest)
+		return
+	}
+	if c.TTL < 0 || c.Weight < 0 {
+		http.Error(w, "Bad Request: ttl and weight can't be negative", http.StatusBadRequest)
+		return
+	}
+	if err := validateServerURL(c.ServerConfig); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateHealthURL(c.ServerConfig); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := rg.pools.Get(c.Pool)
+	if p == nil || (len(t.Pools) > 0 && !slices.Contains(t.Pools, p.name)) {
+		http.Error(w, fmt.Sprintf("Bad Request: unknown pool %q", c.Pool), http.StatusBadRequest)
+		return
+	}
+	c.Name = qualifyName(p.config.tenant, c.Name)
+	ttl := rg.defaultTTL
+	if c.TTL > 0 {
+		ttl = min(time.Duration(c.TTL), rg.maxTTL)
+	}
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	status := http.StatusCreated
+	if e := rg.entries[c.Name]; e != nil && rg.pools.Find(c.Name) == e.server {
+		s := e.server
+		if s.URL == c.URL && s.pool == p {
+			if c.Weight > 0 && p.Weight(s) != c.Weight {
+				p.SetWeight(s, c.Weight)
+			}
+			e.ttl, e.expires = ttl, time.Now().Add(ttl)
+			writeRegistration(w, http.StatusOK, c.Name, e)
+			return
+		}
+		// Moved to a new address or pool: replace it.
+		rg.pools.Unregister(c.Name)
+		delete(rg.entries, c.Name)
+		status = http.StatusOK
+	}
+	s := p.newServer(c.ServerConfig)
+	if err := rg.pools.Register(s); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	e := &registration{server: s, ttl: ttl, expires: time.Now().Add(ttl)}
+	rg.entries[c.Name] = e
+	slog.Info("➕ Server registered itself", "server", c.Name, "pool", p.name, "url", c.URL, "ttl", ttl.String())
+	writeRegistration(w, status, c.Name, e)
+}
+
+// handleHeartbeat extends a registration by its TTL. The name is the one
+// POST /register answered with, which is qualified in tenant pools. A 404
+// tells the backend it has expired and should register again.
+func (rg *registrar) handleHeartbeat(w http.ResponseWriter, r *http.Request, t *RegistrationToken) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	name := r.PathValue("name")
+	e := rg.lookup(name, t)
+	if e == nil {
+		http.Error(w, "Not registered", http.StatusNotFound)
+		return
+	}
+	e.expires = time.Now().Add(e.ttl)
+	writeRegistration(w, http.StatusOK, name, e)
+}
+
+// lookup returns the registration of name if t may see it. rg.mu must be
+// held.
+func (rg *registrar) lookup(name string, t *RegistrationToken) *registration {
+	e := rg.entries[name]
+	if e == nil || (len(t.Pools) > 0 && !slices.Contains(t.Pools, e.server.pool.name)) {
+		return nil
+	}
+	if rg.pools.Find(name) != e.server {
+		// Removed through the admin API meanwhile.
+		delete(rg.entries, name)
+		return nil
+	}
+	return e
+}
+
+type deregisterInfo struct {
+	Name string `json:"name"`
+	// Drained is false if requests were still in flight at the drain
+	// timeout; InFlight says how many.
+	Drained  bool `json:"drained"`
+	InFlight int  `json:"in_flight"`
+}
+
+// handleDeregister drains the server and answers once it is gone, so a
+// backend can call it from its shutdown hook and exit afterwards.
+func (rg *registrar) handleDeregister(w http.ResponseWriter, r *http.Request, t *RegistrationToken) {
+	name := r.PathValue("name")
+	rg.mu.Lock()
+	e := rg.lookup(name, t)
+	if e != nil {
+		delete(rg.entries, name)
+	}
+	rg.mu.Unlock()
+	if e == nil {
+		http.Error(w, "Not registered", http.StatusNotFound)
+		return
+	}
+	slog.Info("👋 Registered server is leaving, draining it", "server", name, "active", e.server.pool.Active(e.server))
+	inFlight := rg.drain(e.server, "backend_deregistered")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deregisterInfo{Name: name, Drained: inFlight == 0, InFlight: inFlight})
+}
+
+// drain takes s out of rotation, waits up to the drain timeout for its
+// in-flight requests, then removes it and tells the webhook. kind names
+// why it left. It returns the requests still in flight at removal.
+func (rg *registrar) drain(s *Server, kind string) int {
+	rg.pools.Drain(s)
+	deadline := time.Now().Add(rg.drainTimeout)
+	for s.pool.Active(s) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	inFlight := s.pool.Active(s)
+	if rg.pools.Find(s.Name) == s {
+		rg.pools.Unregister(s.Name)
+	}
+	if inFlight > 0 {
+		drainsTimedOut.Inc(s.pool.name)
+		slog.Warn("⚠️ Drain timed out, removing the server with requests in flight", "server", s.Name, "in_flight", inFlight)
+	} else {
+		slog.Info("➖ Registered server drained and removed", "server", s.Name, "pool", s.pool.name)
+	}
+	rg.notify(s, kind, inFlight)
+	return inFlight
+}
+
+// notify posts the departure of s to the webhook, if there is one.
+func (rg *registrar) notify(s *Server, kind string, inFlight int) {
+	if rg.webhook == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s left pool %s", s.Name, s.pool.name)
+	if kind == "backend_expired" {
+		msg = fmt.Sprintf("%s stopped sending heartbeats and was removed from pool %s", s.Name, s.pool.name)
+	}
+	if inFlight > 0 {
+		msg += fmt.Sprintf(" (in flight at removal: %d)", inFlight)
+	}
+	al := Alert{Key: "server:" + s.Name, Kind: kind, Severity: severityInfo, Server: s.Name, Message: msg, Time: time.Now()}
+	go func() {
+		if err := rg.webhook.Notify(al); err != nil {
+			slog.Warn("⚠️ Deregistration webhook failed", "server", s.Name, "err", err)
+		}
+	}()
+}
+
+func writeRegistration(w http.ResponseWriter, status int, name string, e *registration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(registrationInfo{
+		Name: name, Pool: e.server.pool.name, TTL: Duration(e.ttl), Expires: e.expires,
+	})
+}
+
+// sweep drains and removes the registrations that expired by now. The
+// drains run in the background; sweep returns once they have started.
+func (rg *registrar) sweep(now time.Time) *sync.WaitGroup {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, name := range slices.Sorted(maps.Keys(rg.entries)) {
+		e := rg.entries[name]
+		if now.Before(e.expires) {
+			continue
+		}
+		delete(rg.entries, name)
+		if rg.pools.Find(name) != e.server {
+			continue
+		}
+		registrationsExpired.Inc(e.server.pool.name)
+		slog.Warn("⌛ Registered server stopped sending heartbeats, draining it", "server", name, "pool", e.server.pool.name)
+		wg.Go(func() { rg.drain(e.server, "backend_expired") })
+	}
+	return &wg
+}
+
+func (rg *registrar) start(beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(registrationSweepInterval).Do(func() {
+		defer recoverJob("registration_sweep")
+		rg.sweep(time.Now())
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
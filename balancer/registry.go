@@ -0,0 +1,272 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultPoolName is the pool configured by the top-level pool settings,
+// and the one requests go to unless their route names another.
+const defaultPoolName = "default"
+
+// PoolManager owns the named server pools and every registered server.
+// Each server belongs to exactly one pool. Names are unique across pools,
+// so the admin API and discovery can keep addressing servers by name.
+type PoolManager struct {
+	// mu guards the pool and server lists, which the admin API can change
+	// while the health checker and /stats iterate over them.
+	mu      sync.RWMutex
+	pools   map[string]*ServerPool
+	servers []*Server
+}
+
+// newPoolManager returns a manager holding just the default pool, which
+// always exists.
+func newPoolManager() *PoolManager {
+	m := &PoolManager{pools: map[string]*ServerPool{}}
+	m.pools[defaultPoolName] = &ServerPool{name: defaultPoolName}
+	return m
+}
+
+func (m *PoolManager) Default() *ServerPool {
+	return m.Get(defaultPoolName)
+}
+
+// Get returns the named pool, or nil. An empty name means the default.
+func (m *PoolManager) Get(name string) *ServerPool {
+	if name == "" {
+		name = defaultPoolName
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pools[name]
+}
+
+// Pools returns every pool, the default first and the rest by name.
+func (m *PoolManager) Pools() []*ServerPool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]*ServerPool, 0, len(m.pools))
+	for _, name := range slices.Sorted(maps.Keys(m.pools)) {
+		if name != defaultPoolName {
+			out = append(out, m.pools[name])
+		}
+	}
+	return append([]*ServerPool{m.pools[defaultPoolName]}, out...)
+}
+
+// Create adds an empty pool set up from c. Servers listed in c are not
+// registered; the caller does that.
+func (m *PoolManager) Create(name string, c PoolConfig) (*ServerPool, error) {
+	if name == "" {
+		return nil, errors.New("pool name is required")
+	}
+	p := &ServerPool{name: name}
+	if err := p.configure(c); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pools[name]; ok {
+		return nil, fmt.Errorf("pool %q already exists", name)
+	}
+	m.pools[name] = p
+	return p, nil
+}
+
+// Delete removes a pool and unregisters its servers. In-flight requests to
+// them finish normally. The default pool can't be deleted.
+func (m *PoolManager) Delete(name string) ([]*Server, error) {
+	if name == defaultPoolName {
+		return nil, errors.New("the default pool can't be deleted")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("pool %q not found", name)
+	}
+	var removed []*Server
+	m.servers = slices.DeleteFunc(m.servers, func(s *Server) bool {
+		if s.pool != p {
+			return false
+		}
+		p.RemoveServer(s)
+		removed = append(removed, s)
+		return true
+	})
+	delete(m.pools, name)
+	p.close()
+	return removed, nil
+}
+
+// DrainAll drains every server of the pool, as Drain would. They keep
+// being health-checked and can be enabled again one by one or with
+// EnableAll.
+func (m *PoolManager) DrainAll(name string) ([]*Server, error) {
+	p := m.Get(name)
+	if p == nil {
+		return nil, fmt.Errorf("pool %q not found", name)
+	}
+	members := m.Members(p)
+	for _, s := range members {
+		m.Drain(s)
+	}
+	return members, nil
+}
+
+func (m *PoolManager) EnableAll(name string) ([]*Server, error) {
+	p := m.Get(name)
+	if p == nil {
+		return nil, fmt.Errorf("pool %q not found", name)
+	}
+	members := m.Members(p)
+	for _, s := range members {
+		m.Enable(s)
+	}
+	return members, nil
+}
+
+// Servers returns every registered server, in registration order.
+func (m *PoolManager) Servers() []*Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.servers)
+}
+
+// Members returns the registered servers of p.
+func (m *PoolManager) Members(p *ServerPool) []*Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []*Server
+	for _, s := range m.servers {
+		if s.pool == p {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (m *PoolManager) Find(name string) *Server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, s := range m.servers {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Register adds s to the known servers and, unless it starts unhealthy,
+// to its pool's heap. s must have been created by the pool's newServer.
+func (m *PoolManager) Register(s *Server) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.pool == nil || m.pools[s.pool.name] != s.pool {
+		return fmt.Errorf("server %q: its pool does not exist", s.Name)
+	}
+	for _, existing := range m.servers {
+		if existing.Name == s.Name {
+			return fmt.Errorf("server %q already exists", s.Name)
+		}
+	}
+	m.servers = append(m.servers, s)
+	if s.CheckHealth() {
+		s.pool.AddServer(s)
+	}
+	return nil
+}
+
+// Unregister takes the named server out of its pool for good. In-flight
+// requests to it finish normally.
+func (m *PoolManager) Unregister(name string) *Server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, s := range m.servers {
+		if s.Name == name {
+			m.servers = slices.Delete(m.servers, i, i+1)
+			s.pool.RemoveServer(s)
+			return s
+		}
+	}
+	return nil
+}
+
+// rejoin puts a recovered server back in its pool's heap, unless it was
+// removed or disabled while its health check was running. A draining
+// server rejoins too, to be passed over until it is active again.
+func (m *PoolManager) rejoin(s *Server) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !slices.Contains(m.servers, s) || s.isDisabledByOperator() {
+		return false
+	}
+	s.pool.AddServer(s)
+	return true
+}
+
+// configure sets the pool up from its config section: the transport its
+// servers share, the strategy, the health checker, subsetting and lazy
+// counters.
+func (p *ServerPool) configure(c PoolConfig) error {
+	transport, dns, err := newPoolTransport(c)
+	if err != nil {
+		return err
+	}
+	st, err := strategyByName(c.Strategy)
+	if err != nil {
+		return err
+	}
+	checker, err := c.HealthCheck.checker()
+	if err != nil {
+		return err
+	}
+	p.config, p.transport, p.dns, p.checker = c, transport, dns, checker
+	p.SetStrategy(st)
+	p.setSubset(c.Subset)
+	if c.LazyCounters > 0 && !p.lazy.Load() {
+		p.stopLazy = p.UseLazyCounters(time.Duration(c.LazyCounters))
+	}
+	return nil
+}
+
+// newServer creates a server for p from its config entry. It still has
+// to be registered.
+func (p *ServerPool) newServer(c ServerConfig) *Server {
+	s := newServer(c.Name, c.URL)
+	s.pool = p
+	if p.transport != nil {
+		s.ReverseProxy.Transport = &connTracing{next: p.transport, stats: &s.conns}
+	}
+	s.ReverseProxy.BufferPool = buffersOfSize(p.config.BufferSize)
+	if u := healthURL(c, p.config.HealthCheck); u != c.URL {
+		s.healthURL = u
+	}
+	if p.config.StartUnhealthy {
+		// Joins the heap once the health checker has seen it pass.
+		s.Health = false
+	}
+	s.Weight = c.Weight
+	if s.Weight <= 0 {
+		s.Weight = 1
+	}
+	if p.config.AdaptiveConcurrency != nil {
+		s.limiter = newAdaptiveLimiter(*p.config.AdaptiveConcurrency)
+	}
+	if len(p.config.Labels)+len(c.Labels) > 0 {
+		s.Labels = make(map[string]string, len(p.config.Labels)+len(c.Labels))
+		maps.Copy(s.Labels, p.config.Labels)
+		maps.Copy(s.Labels, c.Labels)
+	}
+	return s
+}
+
+// Name is the pool's name in the PoolManager.
+func (p *ServerPool) Name() string {
+	return p.name
+}
@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRequestSampleRate is what request_sample_rate defaults to: one
+// request in ten makes it into a server's request tail.
+const defaultRequestSampleRate = 10
+
+var requestSampleRate = defaultRequestSampleRate
+
+// requestTailSize is how many sampled requests each server remembers, for
+// the dashboard's server page to start from.
+const requestTailSize = 50
+
+// RequestSample is one sampled request sent to a server. The path leaves
+// out the query string, which can carry secrets.
+type RequestSample struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"duration_ms"`
+}
+
+// requestTail keeps a server's recent sampled requests and streams new
+// ones to watchers. Like poolSubscribers, sends never block: a watcher
+// that falls behind misses samples.
+type requestTail struct {
+	seq atomic.Uint64
+
+	mu      sync.Mutex
+	samples []RequestSample
+	subs    map[chan RequestSample]bool
+}
+
+// record samples a finished request. It is on every request's path, so
+// the ones not sampled cost an atomic add.
+func (t *requestTail) record(r *http.Request, status int, elapsed time.Duration) {
+	if t.seq.Add(1)%uint64(requestSampleRate) != 0 {
+		return
+	}
+	if status == 0 {
+		// The proxy panicked, and recoverPanics answered.
+		status = http.StatusBadGateway
+	}
+	sm := RequestSample{
+		Time: time.Now(), Method: r.Method, Path: r.URL.Path, Status: status,
+		Duration: float64(elapsed) / float64(time.Millisecond),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) == requestTailSize {
+		t.samples = append(t.samples[:0], t.samples[1:]...)
+	}
+	t.samples = append(t.samples, sm)
+	for ch := range t.subs {
+		select {
+		case ch <- sm:
+		default:
+		}
+	}
+}
+
+// recent returns the remembered samples, oldest first.
+func (t *requestTail) recent() []RequestSample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]RequestSample{}, t.samples...)
+}
+
+// watch returns a channel of new samples and a function that stops it.
+func (t *requestTail) watch() (<-chan RequestSample, func()) {
+	ch := make(chan RequestSample, 16)
+	t.mu.Lock()
+	if t.subs == nil {
+		t.subs = make(map[chan RequestSample]bool)
+	}
+	t.subs[ch] = true
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,237 @@
+package balancer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// ResourcesConfig periodically asks every backend how busy it is: its
+// CPU and memory use and, optionally, a load score of its own. The
+// resource_aware strategy ranks servers by it, for services where a
+// handful of expensive requests can saturate a server that
+// least_connections would still call idle.
+type ResourcesConfig struct {
+	// Path is fetched from each backend. Defaults to "/metrics/load".
+	Path string `json:"path"`
+	// Port replaces the port of the server URL, for a metrics sidecar.
+	Port int `json:"port"`
+	// Interval defaults to 5s.
+	Interval Duration `json:"interval"`
+	// Timeout bounds each fetch. Defaults to 1s, and must be shorter than
+	// Interval.
+	Timeout Duration `json:"timeout"`
+}
+
+func (c *ResourcesConfig) validate() error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return errors.New("resources: path must start with /")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("resources: invalid port %d", c.Port)
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return errors.New("resources: interval and timeout must not be negative")
+	}
+	if c.timeout() >= c.interval() {
+		return errors.New("resources: timeout must be shorter than interval")
+	}
+	return nil
+}
+
+func (c *ResourcesConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval)
+	}
+	return 5 * time.Second
+}
+
+func (c *ResourcesConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return time.Second
+}
+
+// maxAge is how long a report is used once fetches start failing: three
+// rounds, so one slow answer doesn't throw it away.
+func (c *ResourcesConfig) maxAge() time.Duration {
+	return 3 * c.interval()
+}
+
+// resourcesURL is where s's report is fetched, like healthURL.
+func (c *ResourcesConfig) resourcesURL(s *Server) string {
+	path := c.Path
+	if path == "" {
+		path = "/metrics/load"
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return strings.TrimSuffix(s.URL, "/") + path
+	}
+	if c.Port > 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(c.Port))
+	}
+	u.Path, u.RawPath = path, ""
+	return u.String()
+}
+
+// ResourceReport is what a backend answered, as JSON such as
+// {"cpu": 0.85, "memory": 0.4}. CPU and memory are fractions of what the
+// server has; load is a score of the backend's own, with 1 meaning fully
+// loaded. Any of them may be left out.
+type ResourceReport struct {
+	CPU    *float64 `json:"cpu,omitempty"`
+	Memory *float64 `json:"memory,omitempty"`
+	Load   *float64 `json:"load,omitempty"`
+	// Pressure is the highest of the three, which is what resource_aware
+	// ranks by, and Time when the report was fetched. The balancer sets
+	// both.
+	Pressure float64   `json:"pressure"`
+	Time     time.Time `json:"time"`
+}
+
+// parseResourceReport reads a report, refusing one with nothing in it or
+// with values that aren't numbers of 0 or more.
+func parseResourceReport(body []byte) (*ResourceReport, error) {
+	var r ResourceReport
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	r.Pressure = math.Inf(-1)
+	for _, v := range []*float64{r.CPU, r.Memory, r.Load} {
+		if v == nil {
+			continue
+		}
+		if *v < 0 || math.IsNaN(*v) || math.IsInf(*v, 0) {
+			return nil, fmt.Errorf("invalid value %v", *v)
+		}
+		r.Pressure = max(r.Pressure, *v)
+	}
+	if math.IsInf(r.Pressure, -1) {
+		return nil, errors.New("no cpu, memory or load in the answer")
+	}
+	return &r, nil
+}
+
+var resourceScrapesFailed = newCounterVec("lb_resource_scrapes_failed_total",
+	"Resource reports fetched from backends that failed or couldn't be read.", "pool")
+
+// resourceScrapeConcurrency is how many backends a round fetches from at
+// once.
+const resourceScrapeConcurrency = 10
+
+func (b *Balancer) startResourceScrapes(c ResourcesConfig, beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(c.interval()).Do(func() {
+		defer recoverJob("resource_scrape")
+		b.scrapeResources(c)
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
+
+// scrapeResources runs one round over the healthy servers. A server that
+// doesn't answer keeps its last report until it is maxAge old, and is
+// then ranked by its connections alone.
+func (b *Balancer) scrapeResources(c ResourcesConfig) {
+	queue := make(chan *Server, resourceScrapeConcurrency)
+	var wg sync.WaitGroup
+	for range resourceScrapeConcurrency {
+		wg.Go(func() {
+			for s := range queue {
+				b.scrapeServer(c, s)
+			}
+		})
+	}
+	for _, s := range b.pools.Servers() {
+		if s.CheckHealth() {
+			queue <- s
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
+
+func (b *Balancer) scrapeServer(c ResourcesConfig, s *Server) {
+	r, err := s.fetchResources(c)
+	if err == nil {
+		r.Time = time.Now()
+		s.pool.setResources(s, r)
+		return
+	}
+	resourceScrapesFailed.Inc(s.pool.name)
+	if last := s.pool.Resources(s); last != nil && time.Since(last.Time) > c.maxAge() {
+		s.pool.setResources(s, nil)
+		slog.Warn("⚠️ Server's resource report is out of date, ranking it by connections alone",
+			"server", s.Name, "reported", last.Time, "err", err)
+	}
+}
+
+func (s *Server) fetchResources(c ResourcesConfig) (*ResourceReport, error) {
+	client := http.Client{Transport: s.ReverseProxy.Transport, Timeout: c.timeout()}
+	resp, err := client.Get(c.resourcesURL(s))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	return parseResourceReport(body)
+}
+
+// setResources replaces s's report, nil for none, and moves s in the heap
+// to match.
+func (p *ServerPool) setResources(s *Server, r *ResourceReport) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s.resources = r
+	if s.Index != -1 {
+		p.servers.fix(s.Index)
+	}
+}
+
+// Resources is s's last report, or nil.
+func (p *ServerPool) Resources(s *Server) *ResourceReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return s.resources
+}
+
+// minHeadroom keeps a server reporting full or over-full use finite, so
+// it is still picked when every other server is far busier.
+const minHeadroom = 0.05
+
+// resourceAware divides a server's weighted connections, counting the
+// request about to be sent, by the share of its resources still free. A
+// server at 80% CPU counts as five times as busy as an idle one with the
+// same connections. Servers without a report have all their resources
+// free.
+type resourceAware struct{}
+
+func (resourceAware) Name() string { return "resource_aware" }
+func (resourceAware) Load(s *Server) float64 {
+	headroom := 1.0
+	if r := s.resources; r != nil {
+		headroom = max(1-r.Pressure, minHeadroom)
+	}
+	return (s.predictedActive() + 1) / float64(max(s.Weight, 1)) / headroom
+}
@@ -0,0 +1,116 @@
+package balancer
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// RouteStats is one route as it is applied right now: its match, and the
+// pool, strategy, timeouts and affinity its requests get. The last entry
+// is the fallback for requests matching no route.
+type RouteStats struct {
+	Name           string   `json:"name"`
+	Tenant         string   `json:"tenant,omitempty"`
+	Fallback       bool     `json:"fallback,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	PathPrefix     string   `json:"path_prefix,omitempty"`
+	Methods        []string `json:"methods,omitempty"`
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	Class          string   `json:"class"`
+	Pool           string   `json:"pool"`
+	// Strategy is the pool's current one, which the admin API and reloads
+	// can change.
+	Strategy string `json:"strategy"`
+	// Timeout is the route's own, if any; DialTimeout the pool's.
+	Timeout     *Duration `json:"timeout,omitempty"`
+	DialTimeout Duration  `json:"dial_timeout"`
+	// ClientDeadline says whether client budgets (X-Request-Timeout,
+	// grpc-timeout) are honoured, and DeadlineMax caps them.
+	ClientDeadline bool      `json:"client_deadline"`
+	DeadlineMax    *Duration `json:"deadline_max,omitempty"`
+	// Retries is what the balancer retries on failure: nothing, since a
+	// request is sent to one backend only.
+	Retries int `json:"retries"`
+	// Affinity is "sticky" when clients are pinned to a backend, else "none".
+	Affinity  string    `json:"affinity"`
+	StickyTTL *Duration `json:"sticky_ttl,omitempty"`
+	// ClientKey identifies clients for affinity, e.g. "ip" or "header:X-User".
+	ClientKey string `json:"client_key"`
+	// Flush is "streaming", an interval or empty for the default.
+	Flush string `json:"flush,omitempty"`
+	// Cost is what each request counts as in its backend's connections.
+	Cost int `json:"cost"`
+}
+
+// routeStats lists the routes of tenant, or all of them and the fallback
+// for "".
+func (b *Balancer) routeStats(tenant string) []RouteStats {
+	out := make([]RouteStats, 0, len(b.cfg.Routes)+1)
+	for i := range b.cfg.Routes {
+		if rc := &b.cfg.Routes[i]; tenant == "" || rc.tenant == tenant {
+			out = append(out, b.routeStat(rc))
+		}
+	}
+	if tenant != "" {
+		return out
+	}
+	fallback := b.routeStat(&RouteConfig{})
+	fallback.Fallback = true
+	return append(out, fallback)
+}
+
+func (b *Balancer) routeStat(rc *RouteConfig) RouteStats {
+	c := &b.cfg
+	st := RouteStats{
+		Name: rc.Name, Tenant: rc.tenant, Host: rc.Host, PathPrefix: rc.PathPrefix,
+		Methods: rc.Methods, AllowedMethods: rc.AllowedMethods,
+		Class: rc.Class, Pool: rc.Pool, Affinity: "none", ClientKey: "ip", Cost: rc.cost(),
+	}
+	if st.Class == "" {
+		st.Class = "normal"
+	}
+	if st.Pool == "" {
+		st.Pool = defaultPoolName
+	}
+	p := b.poolFor(rc)
+	st.Strategy = p.Strategy().Name()
+	if rc.Timeout > 0 {
+		st.Timeout = &rc.Timeout
+	}
+	if d, err := p.config.Dialer.dialer(); err == nil {
+		st.DialTimeout = Duration(d.Timeout)
+	}
+	if c.Deadline != nil {
+		st.ClientDeadline = true
+		if c.Deadline.Max > 0 {
+			st.DeadlineMax = &c.Deadline.Max
+		}
+	}
+	if b.sticky != nil {
+		ttl := Duration(b.sticky.ttl)
+		st.Affinity, st.StickyTTL = "sticky", &ttl
+	}
+	key := c.ClientKey
+	if rc.ClientKey != nil {
+		key = rc.ClientKey
+	}
+	if key != nil && key.Source != "" && key.Source != "ip" {
+		st.ClientKey = key.Source
+		if key.Name != "" {
+			st.ClientKey += ":" + key.Name
+		}
+	}
+	switch fi := rc.flushInterval(); {
+	case fi < 0:
+		st.Flush = "streaming"
+	case fi > 0:
+		st.Flush = fi.String()
+	}
+	return st
+}
+
+func (b *Balancer) routesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(b.routeStats(callerTenant(r)))
+}
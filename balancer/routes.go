@@ -0,0 +1,163 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RouteConfig matches requests by host, path prefix and method. The first
+// matching route wins; requests matching none get the defaults.
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Host matches the request host (port ignored), case-insensitively.
+	Host       string   `json:"host"`
+	PathPrefix string   `json:"path_prefix"`
+	Methods    []string `json:"methods"`
+	// AllowedMethods, unlike Methods, doesn't affect matching: requests
+	// that match the route with any other method get 405 at the edge.
+	// HEAD is allowed along with GET.
+	AllowedMethods []string `json:"allowed_methods"`
+	// Class is the priority class ("critical", "normal", "batch" or one
+	// defined under priority.classes). Defaults to "normal".
+	Class string `json:"class"`
+	// Pool is the pool the route's requests go to. Defaults to the
+	// default pool.
+	Pool string `json:"pool"`
+	// ClientKey overrides the top-level client_key for this route.
+	ClientKey *ClientKeyConfig `json:"client_key,omitempty"`
+	// Redact lists JSON body fields hidden from anything that copies
+	// request bodies. See redact.go.
+	Redact *RedactConfig `json:"redact,omitempty"`
+	// FlushInterval is how often responses are flushed to the client while
+	// they are copied from the backend, e.g. "100ms". Negative values flush
+	// after every write. Responses that are text/event-stream or have no
+	// Content-Length are always flushed after every write.
+	FlushInterval Duration `json:"flush_interval"`
+	// Streaming flushes after every write, as a negative FlushInterval
+	// does. Meant for long-polling and streaming APIs.
+	Streaming bool `json:"streaming"`
+	// Timeout bounds the whole backend request, response body included;
+	// past it the client gets 504. A shorter client deadline still wins.
+	Timeout Duration `json:"timeout"`
+	// Cost is how many connections each of the route's requests counts as
+	// while in flight, so that heavy endpoints weigh on their backend's
+	// load in proportion. 0 leaves the load alone, e.g. for health pings.
+	// Defaults to 1.
+	Cost *int `json:"cost,omitempty"`
+
+	// tenant is the tenant whose routes this one came from, if any.
+	tenant string
+}
+
+func (rc *RouteConfig) matches(r *http.Request) bool {
+	if rc.Host != "" && !strings.EqualFold(rc.Host, requestHost(r)) {
+		return false
+	}
+	if rc.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, rc.PathPrefix) {
+		return false
+	}
+	if len(rc.Methods) > 0 && !slices.ContainsFunc(rc.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+		return false
+	}
+	return true
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	return normalizeSNI(strings.Trim(host, "[]"))
+}
+
+// matchRoute returns the first route matching r, or nil.
+func matchRoute(routes []RouteConfig, r *http.Request) *RouteConfig {
+	for i := range routes {
+		if routes[i].matches(r) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// flushInterval is the ReverseProxy FlushInterval for the route's
+// requests, or 0 to keep the default.
+func (rc *RouteConfig) flushInterval() time.Duration {
+	if rc.Streaming {
+		return -1
+	}
+	return time.Duration(rc.FlushInterval)
+}
+
+// cost is how many connections a request of rc counts as; rc may be
+// nil, for unrouted requests.
+func (rc *RouteConfig) cost() int {
+	if rc == nil || rc.Cost == nil {
+		return 1
+	}
+	return *rc.Cost
+}
+
+// allows reports whether the route lets method through.
+func (rc *RouteConfig) allows(method string) bool {
+	if len(rc.AllowedMethods) == 0 {
+		return true
+	}
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	return slices.ContainsFunc(rc.AllowedMethods, func(m string) bool { return strings.EqualFold(m, method) })
+}
+
+// filterMethods answers requests whose route doesn't allow their method
+// with 405 and an Allow header, so they never reach a backend.
+func filterMethods(routes []RouteConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := matchRoute(routes, r)
+		if rc == nil || rc.allows(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allow := make([]string, 0, len(rc.AllowedMethods)+1)
+		for _, m := range rc.AllowedMethods {
+			allow = append(allow, strings.ToUpper(m))
+		}
+		if slices.Contains(allow, http.MethodGet) && !slices.Contains(allow, http.MethodHead) {
+			allow = append(allow, http.MethodHead)
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+func validateRoutes(routes []RouteConfig, classes map[string]PriorityClassConfig, pools map[string]PoolConfig) error {
+	for i, rc := range routes {
+		if _, ok := pools[rc.Pool]; rc.Pool != "" && rc.Pool != defaultPoolName && !ok {
+			return fmt.Errorf("routes[%d]: unknown pool %q", i, rc.Pool)
+		}
+		if rc.Timeout < 0 {
+			return fmt.Errorf("routes[%d]: timeout must not be negative", i)
+		}
+		if rc.Cost != nil && *rc.Cost < 0 {
+			return fmt.Errorf("routes[%d]: cost must not be negative", i)
+		}
+		if rc.Redact != nil {
+			if err := rc.Redact.validate(); err != nil {
+				return fmt.Errorf("routes[%d]: %w", i, err)
+			}
+		}
+		if rc.Class == "" {
+			continue
+		}
+		if _, ok := builtinClasses[rc.Class]; ok {
+			continue
+		}
+		if _, ok := classes[rc.Class]; !ok {
+			return fmt.Errorf("routes[%d]: unknown class %q", i, rc.Class)
+		}
+	}
+	return nil
+}
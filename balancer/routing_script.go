@@ -0,0 +1,158 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// scriptRequest is what a routing script sees as `request`.
+type scriptRequest struct {
+	Method    string            `expr:"method"`
+	Host      string            `expr:"host"`
+	Path      string            `expr:"path"`
+	Query     map[string]string `expr:"query"`
+	Headers   map[string]string `expr:"headers"`
+	Cookies   map[string]string `expr:"cookies"`
+	ClientIP  string            `expr:"client_ip"`
+	ClientKey string            `expr:"client_key"`
+}
+
+type scriptEnv struct {
+	Request scriptRequest `expr:"request"`
+}
+
+// routingScript is an expr-lang expression run for every request. It
+// returns nil to leave the choice to the pool, or a map with any of:
+//
+//	backend: name of the server to use
+//	labels:  {key: value} a server must have
+//	headers: {name: value} to set on the upstream request
+type routingScript struct {
+	program *vm.Program
+}
+
+func newRoutingScript(src string) (*routingScript, error) {
+	program, err := expr.Compile(src, expr.Env(scriptEnv{}))
+	if err != nil {
+		return nil, fmt.Errorf("routing_script: %w", err)
+	}
+	return &routingScript{program: program}, nil
+}
+
+type routeDecision struct {
+	backend string
+	labels  map[string]string
+	headers map[string]string
+}
+
+// decide runs the script for r, whose client key is key. A nil script
+// decides nothing.
+func (rs *routingScript) decide(r *http.Request, key string) *routeDecision {
+	if rs == nil {
+		return nil
+	}
+	out, err := expr.Run(rs.program, scriptEnv{Request: newScriptRequest(r, key)})
+	if err != nil {
+		slog.Warn("⚠️ routing_script failed", "err", err)
+		return nil
+	}
+	d, err := parseDecision(out)
+	if err != nil {
+		slog.Warn("⚠️ routing_script failed", "err", err)
+		return nil
+	}
+	return d
+}
+
+func newScriptRequest(r *http.Request, key string) scriptRequest {
+	sr := scriptRequest{
+		Method:    r.Method,
+		Host:      requestHost(r),
+		Path:      r.URL.Path,
+		Query:     make(map[string]string),
+		Headers:   make(map[string]string, len(r.Header)),
+		Cookies:   make(map[string]string),
+		ClientIP:  clientIP(r),
+		ClientKey: key,
+	}
+	for k, vv := range r.URL.Query() {
+		sr.Query[k] = vv[0]
+	}
+	// Lower-case names so scripts don't have to guess the canonical form.
+	for k, vv := range r.Header {
+		sr.Headers[strings.ToLower(k)] = vv[0]
+	}
+	for _, c := range r.Cookies() {
+		sr.Cookies[c.Name] = c.Value
+	}
+	return sr
+}
+
+func parseDecision(out any) (*routeDecision, error) {
+	if out == nil {
+		return nil, nil
+	}
+	m, ok := out.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a map or nil, got %T", out)
+	}
+	d := &routeDecision{}
+	for k, v := range m {
+		var err error
+		switch k {
+		case "backend":
+			if d.backend, ok = v.(string); !ok {
+				err = fmt.Errorf("backend must be a string")
+			}
+		case "labels":
+			d.labels, err = stringMap(k, v)
+		case "headers":
+			d.headers, err = stringMap(k, v)
+		default:
+			err = fmt.Errorf("unknown key %q", k)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+func stringMap(name string, v any) (map[string]string, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map", name)
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = fmt.Sprint(v)
+	}
+	return out, nil
+}
+
+// acquire takes a slot on the server the decision asks for. ok is false
+// when the decision names no server or none of its choices can take the
+// request; the normal selection applies then. Labels pick from p; a
+// backend named outright may sit in any pool of m.
+func (d *routeDecision) acquire(m *PoolManager, p *ServerPool) (s *Server, ok bool) {
+	switch {
+	case d.backend != "":
+		s = m.Find(d.backend)
+		if s == nil || !s.pool.Selectable(s) || !s.HasLabels(d.labels) {
+			return nil, false
+		}
+	case len(d.labels) > 0:
+		s = p.GetNextServerWhere(func(c *Server) bool { return c.HasLabels(d.labels) && c.limiter.HasRoom() })
+		if s == nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return s, s.limiter.Acquire()
+}
@@ -0,0 +1,215 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/robfig/cron/v3"
+)
+
+// ScheduleConfig is a recurring time window. It opens at every Cron match
+// (standard five fields, local time) and stays open for Duration.
+type ScheduleConfig struct {
+	Name     string   `json:"name"`
+	Cron     string   `json:"cron"`
+	Duration Duration `json:"duration"`
+	// Action is "maintenance" (take Servers out of rotation) or
+	// "traffic_split" (use TrafficSplit instead of the pool's own).
+	Action       string         `json:"action"`
+	Servers      []string       `json:"servers,omitempty"`
+	TrafficSplit []TrafficSplit `json:"traffic_split,omitempty"`
+	// Drain takes maintenance servers out of rotation this long before
+	// the window opens, so in-flight requests can finish first.
+	Drain Duration `json:"drain,omitempty"`
+}
+
+// MaintenanceWindow is a server's own known maintenance window, e.g. its
+// nightly restart.
+type MaintenanceWindow struct {
+	Cron     string   `json:"cron"`
+	Duration Duration `json:"duration"`
+	Drain    Duration `json:"drain,omitempty"`
+}
+
+func (m MaintenanceWindow) schedule(server string) ScheduleConfig {
+	return ScheduleConfig{
+		Name:     server + " maintenance",
+		Cron:     m.Cron,
+		Duration: m.Duration,
+		Action:   "maintenance",
+		Servers:  []string{server},
+		Drain:    m.Drain,
+	}
+}
+
+func (c *ScheduleConfig) validate() error {
+	if _, err := cron.ParseStandard(c.Cron); err != nil {
+		return fmt.Errorf("schedules: %s: cron: %w", c.Name, err)
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("schedules: %s: duration is required", c.Name)
+	}
+	if c.Drain < 0 {
+		return fmt.Errorf("schedules: %s: drain must not be negative", c.Name)
+	}
+	switch c.Action {
+	case "maintenance":
+		if len(c.Servers) == 0 {
+			return fmt.Errorf("schedules: %s: servers are required", c.Name)
+		}
+	case "traffic_split":
+		if err := validateTrafficSplit(c.TrafficSplit); err != nil {
+			return fmt.Errorf("schedules: %s: %w", c.Name, err)
+		}
+	default:
+		return fmt.Errorf("schedules: %s: unknown action %q", c.Name, c.Action)
+	}
+	return nil
+}
+
+type scheduleWindow struct {
+	b   *Balancer
+	cfg ScheduleConfig
+
+	mu      sync.Mutex
+	open    bool
+	closeAt *time.Timer
+	held    []heldServer // servers this window took out, to put back
+	split   *[]TrafficSplit
+}
+
+type heldServer struct {
+	*Server
+	wasHealthy bool
+}
+
+// begin opens the window until end, or extends it if already open.
+func (w *scheduleWindow) begin(end time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.open {
+		w.closeAt.Reset(time.Until(end))
+		return
+	}
+	w.open = true
+	w.closeAt = time.AfterFunc(time.Until(end), w.end)
+	slog.Info("🕑 Schedule started", "schedule", w.cfg.Name, "until", end.Format(time.Kitchen))
+
+	switch w.cfg.Action {
+	case "maintenance":
+		for _, name := range w.cfg.Servers {
+			s := w.b.pools.Find(name)
+			if s == nil {
+				slog.Warn("⚠️ Schedule names an unknown server", "schedule", w.cfg.Name, "server", name)
+				continue
+			}
+			// Health alerts are held back while the server is in
+			// maintenance; end reports the net change instead.
+			if !w.b.pools.setState(s, StateMaintenance, StateActive) {
+				continue // an operator got there first; leave it to them
+			}
+			w.held = append(w.held, heldServer{s, s.CheckHealth()})
+			slog.Info("⏸️ Server disabled for schedule", "server", s.Name, "schedule", w.cfg.Name)
+		}
+	case "traffic_split":
+		split := w.cfg.TrafficSplit
+		w.split = &split
+		w.b.splitOverride.Store(w.split)
+	}
+}
+
+func (w *scheduleWindow) end() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.open {
+		return
+	}
+	w.open = false
+	w.closeAt.Stop()
+	for _, s := range w.held {
+		if !w.b.pools.setState(s.Server, StateActive, StateMaintenance) {
+			// An operator took over meanwhile; their state stays.
+			slog.Info("⏸️ Server left as the operator set it after schedule", "server", s.Name, "schedule", w.cfg.Name, "state", s.State().String())
+			continue
+		}
+		if alive := s.CheckHealth(); alive != s.wasHealthy {
+			w.b.alerts.healthChanged(s.Server, alive)
+		}
+		slog.Info("▶️ Server enabled after schedule", "server", s.Name, "schedule", w.cfg.Name)
+	}
+	w.held = nil
+	if w.split != nil {
+		// Only clear it if a later window hasn't replaced it.
+		w.b.splitOverride.CompareAndSwap(w.split, nil)
+		w.split = nil
+	}
+	slog.Info("🕑 Schedule ended", "schedule", w.cfg.Name)
+}
+
+// current returns when the window that is open at now ends. A window
+// counts as open from Drain before its start.
+func (w *scheduleWindow) current(now time.Time) (end time.Time, ok bool) {
+	sched, err := cron.ParseStandard(w.cfg.Cron)
+	if err != nil {
+		return time.Time{}, false
+	}
+	lead, d := time.Duration(w.cfg.Drain), time.Duration(w.cfg.Duration)
+	for t := sched.Next(now.Add(-d)); !t.After(now.Add(lead)); t = sched.Next(t) {
+		end = t.Add(d)
+	}
+	return end, !end.IsZero()
+}
+
+// check opens the window if it should be open at now. Windows close on
+// their own timer.
+func (w *scheduleWindow) check(now time.Time) {
+	w.mu.Lock()
+	open := w.open
+	w.mu.Unlock()
+	if open {
+		return
+	}
+	if end, ok := w.current(now); ok {
+		w.begin(end)
+	}
+}
+
+// startSchedules runs the configured schedules and the maintenance
+// windows of every configured server, in any pool. Cron expressions only
+// resolve to minutes, so checking every few seconds is plenty and also
+// opens windows we started in the middle of.
+func (b *Balancer) startSchedules() []*scheduleWindow {
+	configs := slices.Clone(b.cfg.Schedules)
+	b.cfg.eachPool(func(_ string, pc *PoolConfig) error {
+		for _, sc := range pc.Servers {
+			for _, m := range sc.Maintenance {
+				configs = append(configs, m.schedule(sc.Name))
+			}
+		}
+		return nil
+	})
+	if len(configs) == 0 {
+		return nil
+	}
+	var windows []*scheduleWindow
+	for _, sc := range configs {
+		windows = append(windows, &scheduleWindow{b: b, cfg: sc})
+	}
+	s := gocron.NewScheduler(time.Local)
+	s.Every(5 * time.Second).Do(func() {
+		now := time.Now()
+		for _, w := range windows {
+			w.check(now)
+		}
+	})
+	s.StartAsync()
+	go func() {
+		<-b.done
+		s.Stop()
+	}()
+	return windows
+}
@@ -0,0 +1,715 @@
+package balancer
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerHeap is a min-heap of servers ordered by the strategy's effective
+// load. A nil strategy means weighted least connections.
+type ServerHeap struct {
+	items    []*Server
+	strategy Strategy
+}
+
+func (h ServerHeap) Len() int { return len(h.items) }
+
+// --- THE CORE DSA LOGIC ---
+func (h ServerHeap) Less(i, j int) bool {
+	st := h.strategy
+	if st == nil {
+		st = defaultStrategy
+	}
+	return st.Load(h.items[i]) < st.Load(h.items[j])
+}
+
+func (h ServerHeap) load(i int) float64 {
+	if h.strategy == nil {
+		return defaultStrategy.Load(h.items[i])
+	}
+	return h.strategy.Load(h.items[i])
+}
+
+// The per-request counter updates used to call heap.Fix, which goes
+// through the heap.Interface and recomputes both loads at every
+// comparison. siftDown/siftUp work on the concrete type, compute the
+// moving server's load once, and return straight away in the common case
+// where the change doesn't reorder anything (e.g. equal counts).
+
+// siftDown moves i towards the leaves while a child is less loaded. It
+// reports whether i moved.
+func (h *ServerHeap) siftDown(i int) bool {
+	n := len(h.items)
+	li := h.load(i)
+	start := i
+	for {
+		c := 2*i + 1
+		if c >= n {
+			break
+		}
+		lc := h.load(c)
+		if r := c + 1; r < n {
+			if lr := h.load(r); lr < lc {
+				c, lc = r, lr
+			}
+		}
+		if !(lc < li) {
+			break
+		}
+		h.Swap(i, c)
+		i = c
+	}
+	return i != start
+}
+
+// siftUp moves i towards the root while it is less loaded than its parent.
+func (h *ServerHeap) siftUp(i int) bool {
+	li := h.load(i)
+	start := i
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !(li < h.load(parent)) {
+			break
+		}
+		h.Swap(i, parent)
+		i = parent
+	}
+	return i != start
+}
+
+// fix restores order after i's load changed in either direction.
+func (h *ServerHeap) fix(i int) {
+	if !h.siftDown(i) {
+		h.siftUp(i)
+	}
+}
+
+func (h ServerHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].Index = i
+	h.items[j].Index = j
+}
+
+func (h *ServerHeap) Push(x interface{}) {
+	n := len(h.items)
+	item := x.(*Server)
+	item.Index = n
+	h.items = append(h.items, item)
+}
+
+func (h *ServerHeap) Pop() interface{} {
+	old := h.items
+	n := len(old)
+	item := old[n-1]
+	item.Index = -1
+	h.items = old[0 : n-1]
+	return item
+}
+
+type ServerPool struct {
+	// name, config and transport are set when the pool is created and
+	// don't change afterwards.
+	name      string
+	config    PoolConfig
+	transport http.RoundTripper
+	// dns caches the backends' addresses when the config has a dns block.
+	dns *dnsCache
+	// checker probes the pool's servers; nil for the default probe.
+	checker HealthChecker
+
+	servers ServerHeap
+	lock    sync.Mutex
+	// lazy makes IncrementActive, DecrementActive and ObserveLatency
+	// lock-free: they update per-server atomics that are folded into the
+	// heap at selection time and by a periodic rebuild.
+	lazy atomic.Bool
+	// added is closed (and cleared) by the next AddServer; waiters in
+	// GetNextServerContext block on it.
+	added chan struct{}
+	// subset, when set, keeps only this instance's subset of the joined
+	// servers in the heap; the others wait in standby. See subset.go.
+	subset  *subsetter
+	standby map[*Server]bool
+
+	events poolSubscribers
+
+	// clock is time.Now when nil. A simulation runs pools on its own.
+	clock func() time.Time
+	// stopLazy ends the lazy counter rebuilds, if they run.
+	stopLazy func()
+	// surging are the servers with a surge still to fade; see
+	// load_prediction.go.
+	surging map[*Server]bool
+	// rng is the global source when nil. A simulation seeds its own, so
+	// that its runs repeat; it isn't safe for concurrent use.
+	rng *rand.Rand
+}
+
+// intN is rand.IntN from p's source.
+func (p *ServerPool) intN(n int) int {
+	if p.rng != nil {
+		return p.rng.IntN(n)
+	}
+	return rand.IntN(n)
+}
+
+// float64 is rand.Float64 from p's source.
+func (p *ServerPool) float64() float64 {
+	if p.rng != nil {
+		return p.rng.Float64()
+	}
+	return rand.Float64()
+}
+
+// normFloat64 is rand.NormFloat64 from p's source.
+func (p *ServerPool) normFloat64() float64 {
+	if p.rng != nil {
+		return p.rng.NormFloat64()
+	}
+	return rand.NormFloat64()
+}
+
+func (p *ServerPool) AddServer(s *Server) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.joined(s) {
+		// The health checker and an admin enable can both decide to put
+		// s back; only the first one does.
+		return
+	}
+	if s.pool == nil {
+		// Built by hand rather than by a pool's newServer: it joins the
+		// first pool it's added to.
+		s.pool = p
+	}
+	if p.subset != nil {
+		p.standby[s] = true
+		p.resubset()
+		return
+	}
+	p.push(s)
+}
+
+func (p *ServerPool) GetNextServer() *Server {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s := p.next()
+	if s != nil {
+		p.publish(Selected, s, true)
+	}
+	return s
+}
+
+// GetNextServerContext is GetNextServer that waits for a server to join
+// when the pool is empty. It gives up with ctx.Err() once ctx is done, so
+// the caller's deadline decides how long a request may queue.
+func (p *ServerPool) GetNextServerContext(ctx context.Context) (*Server, error) {
+	for {
+		p.lock.Lock()
+		if s := p.next(); s != nil {
+			p.publish(Selected, s, true)
+			p.lock.Unlock()
+			return s, nil
+		}
+		if p.added == nil {
+			p.added = make(chan struct{})
+		}
+		added := p.added
+		p.lock.Unlock()
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// next returns the root. The caller holds the lock.
+func (p *ServerPool) next() *Server {
+	if len(p.servers.items) == 0 {
+		return nil
+	}
+	p.decaySurges()
+	if p.lazy.Load() {
+		// Bring the root up to date; stop once the current root had
+		// nothing pending, so it really is the least loaded we know of.
+		for range p.servers.items {
+			if !p.servers.items[0].fold() {
+				break
+			}
+			p.servers.fix(0)
+		}
+	}
+	pick := p.servers.items[0]
+	if pick.passedOver.Load() {
+		// The root is draining: take the least loaded server below it.
+		found := p.servers.best(1, nil)
+		if len(found) == 0 {
+			return nil
+		}
+		pick = found[0]
+	}
+	tie := p.servers.breakTie(pick, p.intN)
+	if tie != pick && p.lazy.Load() && tie.fold() {
+		// Its count had moved on, so it wasn't tied after all.
+		p.servers.fix(tie.Index)
+		return pick
+	}
+	return tie
+}
+
+// GetNextServers returns up to n servers, least loaded first, under a
+// single lock acquisition. The servers are not reserved: callers that
+// dispatch to them should still IncrementActive each one.
+func (p *ServerPool) GetNextServers(n int) []*Server {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n <= 0 || len(p.servers.items) == 0 {
+		return nil
+	}
+	p.next() // brings a lazy root up to date
+	h := &p.servers
+	if n >= len(h.items) {
+		out := slices.DeleteFunc(slices.Clone(h.items), (*Server).isPassedOver)
+		slices.SortStableFunc(out, func(a, b *Server) int {
+			return cmp.Compare(h.load(a.Index), h.load(b.Index))
+		})
+		return out
+	}
+	return h.best(n, nil)
+}
+
+// GetNextServerWhere returns the least loaded server that match accepts,
+// or nil if none does.
+func (p *ServerPool) GetNextServerWhere(match func(*Server) bool) *Server {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.next() == nil {
+		return nil
+	}
+	found := p.servers.best(1, match)
+	if len(found) == 0 {
+		return nil
+	}
+	p.publish(Selected, found[0], true)
+	return found[0]
+}
+
+// GetServerBy returns the server st finds least loaded among those match
+// accepts (nil accepts all), or nil if there is none. The heap is ordered
+// by the pool's own strategy, so this scans every member.
+func (p *ServerPool) GetServerBy(st Strategy, match func(*Server) bool) *Server {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.decaySurges()
+	var best *Server
+	bestLoad := 0.0
+	for _, s := range p.servers.items {
+		if s.passedOver.Load() || (match != nil && !match(s)) {
+			continue
+		}
+		if load := st.Load(s); best == nil || load < bestLoad {
+			best, bestLoad = s, load
+		}
+	}
+	if best != nil {
+		p.publish(Selected, best, true)
+	}
+	return best
+}
+
+// best returns the n least loaded servers accepted by match (nil accepts
+// all) by expanding from the root and always taking the least loaded
+// frontier slot next. Draining servers are passed over but still
+// expanded, since their children can be anything. Without a filter the
+// cost depends on n and the draining servers, not on the pool size.
+func (h *ServerHeap) best(n int, match func(*Server) bool) []*Server {
+	out := make([]*Server, 0, n)
+	frontier := []int{0}
+	for len(out) < n && len(frontier) > 0 {
+		pick := 0
+		for i := range frontier {
+			if h.load(frontier[i]) < h.load(frontier[pick]) {
+				pick = i
+			}
+		}
+		i := frontier[pick]
+		frontier = slices.Delete(frontier, pick, pick+1)
+		if s := h.items[i]; !s.passedOver.Load() && (match == nil || match(s)) {
+			out = append(out, s)
+		}
+		for _, c := range []int{2*i + 1, 2*i + 2} {
+			if c < len(h.items) {
+				frontier = append(frontier, c)
+			}
+		}
+	}
+	return out
+}
+
+// tieBreakLimit bounds how many servers breakTie looks at, so a pool of
+// many idle servers doesn't make every pick walk all of them.
+const tieBreakLimit = 16
+
+// breakTie returns a server picked at random with intN, in proportion to weight,
+// from those as loaded as pick, which is the least loaded one taking
+// traffic. Always taking the same one of several tied servers sends it
+// every request that arrives before its count goes up: a burst of picks
+// made together, or every pick under ewma_latency while no server has a
+// sample. Weighing the odds keeps weights meaningful in a quiet pool,
+// where every server is idle and so tied. The tied servers sit at the top
+// of the heap, each below another that is no more loaded, so the walk
+// stops at the first more loaded server on each branch.
+func (h *ServerHeap) breakTie(pick *Server, intN func(int) int) *Server {
+	if len(h.items) == 1 {
+		return pick
+	}
+	target := h.load(pick.Index)
+	chosen, total := pick, 0
+	stack := []int{0}
+	for visited := 0; len(stack) > 0 && visited < tieBreakLimit; visited++ {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		l := h.load(i)
+		if l > target {
+			continue
+		}
+		if s := h.items[i]; l == target && !s.passedOver.Load() {
+			// Weighted reservoir sampling: keep s with its share of
+			// the weight seen so far.
+			w := max(s.Weight, 1)
+			if total += w; intN(total) < w {
+				chosen = s
+			}
+		}
+		for _, c := range []int{2*i + 1, 2*i + 2} {
+			if c < len(h.items) {
+				stack = append(stack, c)
+			}
+		}
+	}
+	return chosen
+}
+
+// fold applies pending lazy updates to the fields the strategies read. The
+// caller holds the pool lock. It reports whether anything changed.
+func (s *Server) fold() bool {
+	changed := false
+	if d := s.pendingActive.Swap(0); d != 0 {
+		s.ActiveConnections += int(d)
+		changed = true
+	}
+	if l := math.Float64frombits(s.liveLatency.Load()); l != 0 && l != s.LatencyEWMA {
+		s.LatencyEWMA = l
+		changed = true
+	}
+	return changed
+}
+
+// UseLazyCounters switches the pool to lock-free counter updates and
+// rebuilds the heap from the counters every interval. Selections between
+// rebuilds may be slightly stale for servers other than the root. stop
+// ends the rebuilds; the counters stay lazy.
+func (p *ServerPool) UseLazyCounters(interval time.Duration) (stop func()) {
+	p.lock.Lock()
+	for _, s := range p.servers.items {
+		s.liveLatency.Store(math.Float64bits(s.LatencyEWMA))
+	}
+	p.lazy.Store(true)
+	p.lock.Unlock()
+	ticker := time.NewTicker(interval)
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			// Both may be ready at once; a stop wins.
+			select {
+			case <-done:
+				return
+			default:
+				p.Rebuild()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+			<-exited
+		})
+	}
+}
+
+// close stops the pool's background work, for a pool that is deleted or
+// whose balancer stops.
+func (p *ServerPool) close() {
+	if p.stopLazy != nil {
+		p.stopLazy()
+	}
+}
+
+// Rebuild folds every server's pending updates and re-heapifies.
+func (p *ServerPool) Rebuild() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	changed := false
+	for _, s := range p.servers.items {
+		if s.fold() {
+			changed = true
+		}
+	}
+	if changed {
+		heap.Init(&p.servers)
+	}
+}
+
+// Active is s's current number of in-flight requests, including updates
+// not yet folded into the heap.
+func (p *ServerPool) Active(s *Server) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return s.ActiveConnections + int(s.pendingActive.Load())
+}
+
+// Contains reports whether s currently sits in the heap.
+func (p *ServerPool) Contains(s *Server) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.contains(s)
+}
+
+// Selectable reports whether s sits in the heap and may be picked: it
+// isn't draining or in maintenance.
+func (p *ServerPool) Selectable(s *Server) bool {
+	return p.Contains(s) && !s.passedOver.Load()
+}
+
+func (s *Server) isPassedOver() bool { return s.passedOver.Load() }
+
+// Joined reports whether s was added and not removed since: it sits in
+// the heap, or in standby outside this instance's subset.
+func (p *ServerPool) Joined(s *Server) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.joined(s)
+}
+
+func (p *ServerPool) joined(s *Server) bool {
+	return p.contains(s) || p.standby[s]
+}
+
+func (p *ServerPool) contains(s *Server) bool {
+	return s.Index >= 0 && s.Index < len(p.servers.items) && p.servers.items[s.Index] == s
+}
+
+// Strategy returns the strategy currently ordering the heap.
+func (p *ServerPool) Strategy() Strategy {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.servers.strategy == nil {
+		return defaultStrategy
+	}
+	return p.servers.strategy
+}
+
+// SetStrategy switches how servers are compared and re-heapifies. The
+// servers keep their in-flight counts and latency EWMAs, which every
+// strategy reads, so the new one starts from the pool's current load
+// rather than from zero. Pending lazy updates are folded in first.
+func (p *ServerPool) SetStrategy(st Strategy) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, s := range p.servers.items {
+		s.fold()
+	}
+	p.servers.strategy = st
+	heap.Init(&p.servers)
+}
+
+// Load is s's effective load under the current strategy.
+func (p *ServerPool) Load(s *Server) float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.decaySurges()
+	if p.servers.strategy == nil {
+		return defaultStrategy.Load(s)
+	}
+	return p.servers.strategy.Load(s)
+}
+
+// Weight is s's weight. It can change at runtime through SetWeight.
+func (p *ServerPool) Weight(s *Server) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return s.Weight
+}
+
+// SetWeight changes s's weight and restores the heap order. Its
+// in-flight count and latency EWMA are kept.
+func (p *ServerPool) SetWeight(s *Server, weight int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s.fold()
+	s.Weight = weight
+	if s.Index != -1 {
+		p.servers.fix(s.Index)
+	}
+}
+
+// Latency is s's latency EWMA in milliseconds, including samples not yet
+// folded into the heap.
+func (p *ServerPool) Latency(s *Server) float64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if l := math.Float64frombits(s.liveLatency.Load()); l != 0 {
+		return l
+	}
+	return s.LatencyEWMA
+}
+
+// ObserveLatency folds a finished request's duration into s's EWMA.
+func (p *ServerPool) ObserveLatency(s *Server, d time.Duration) {
+	if p.lazy.Load() {
+		for {
+			old := s.liveLatency.Load()
+			next := math.Float64bits(updateEWMA(math.Float64frombits(old), d))
+			if s.liveLatency.CompareAndSwap(old, next) {
+				return
+			}
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s.LatencyEWMA = updateEWMA(s.LatencyEWMA, d)
+	if s.Index != -1 {
+		p.servers.fix(s.Index)
+	}
+}
+
+func (p *ServerPool) IncrementActive(s *Server) { p.AddActive(s, 1) }
+
+func (p *ServerPool) DecrementActive(s *Server) { p.AddActive(s, -1) }
+
+// AddActive changes s's active connections by n, which is a request's
+// route cost rather than always 1.
+func (p *ServerPool) AddActive(s *Server, n int) {
+	if n == 0 {
+		return
+	}
+	if p.lazy.Load() {
+		s.pendingActive.Add(int64(n))
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.addActive(s, n)
+	if s.Index != -1 {
+		p.servers.fix(s.Index)
+	}
+}
+
+func (p *ServerPool) RemoveServer(s *Server) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.standby, s)
+	if s.Index != -1 {
+		p.pop(s)
+		p.resubset()
+	}
+}
+
+// Repair checks the heap against the registered servers and fixes any
+// drift it finds: entries whose Index points at the wrong slot, servers in
+// the heap twice, servers that are unhealthy, disabled or no longer
+// registered, registered servers whose Index claims a slot they don't
+// hold, and a broken heap order. It returns a description of each fix.
+func (p *ServerPool) Repair(members []*Server) []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	registered := make(map[*Server]bool, len(members))
+	for _, s := range members {
+		registered[s] = true
+	}
+
+	var fixes []string
+	seen := make(map[*Server]bool, len(p.servers.items))
+	kept := p.servers.items[:0]
+	for i, s := range p.servers.items {
+		switch {
+		case seen[s]:
+			fixes = append(fixes, fmt.Sprintf("%s: duplicate heap entry at slot %d", s.Name, i))
+			continue
+		case !registered[s]:
+			fixes = append(fixes, fmt.Sprintf("%s: no longer registered but still in heap", s.Name))
+		case !s.CheckHealth():
+			fixes = append(fixes, fmt.Sprintf("%s: unhealthy but still in heap", s.Name))
+		case s.isDisabledByOperator():
+			fixes = append(fixes, fmt.Sprintf("%s: disabled but still in heap", s.Name))
+		default:
+			seen[s] = true
+			kept = append(kept, s)
+			continue
+		}
+		seen[s] = true
+		s.Index = -1
+		p.publish(ServerRemoved, s, s.CheckHealth())
+	}
+	clear(p.servers.items[len(kept):])
+	p.servers.items = kept
+
+	for i, s := range p.servers.items {
+		if s.Index != i {
+			fixes = append(fixes, fmt.Sprintf("%s: Index %d but sits at slot %d", s.Name, s.Index, i))
+			s.Index = i
+		}
+	}
+	for _, s := range members {
+		if s.Index != -1 && !seen[s] {
+			fixes = append(fixes, fmt.Sprintf("%s: Index %d but not in heap", s.Name, s.Index))
+			s.Index = -1
+		}
+	}
+
+	for s := range p.standby {
+		if !registered[s] || !s.CheckHealth() || s.isDisabledByOperator() {
+			fixes = append(fixes, fmt.Sprintf("%s: in standby but no longer eligible", s.Name))
+			delete(p.standby, s)
+		}
+	}
+
+	if len(fixes) > 0 || !p.servers.valid() {
+		if len(fixes) == 0 {
+			fixes = append(fixes, "heap order violated")
+		}
+		heap.Init(&p.servers)
+		p.resubset()
+	}
+	return fixes
+}
+
+// valid reports whether every parent is no more loaded than its children.
+func (h ServerHeap) valid() bool {
+	for i := 1; i < h.Len(); i++ {
+		if h.Less(i, (i-1)/2) {
+			return false
+		}
+	}
+	return true
+}
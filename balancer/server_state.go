@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ServerState is where a server stands in its pool. Operators and
+// schedules set the administrative states (draining, maintenance,
+// disabled); health checks only decide between active and unhealthy, so
+// they can never undo what an operator did.
+type ServerState int
+
+const (
+	// StateActive takes traffic.
+	StateActive ServerState = iota
+	// StateDraining takes no new requests while its in-flight ones finish,
+	// before it is removed or its backend shuts down.
+	StateDraining
+	// StateMaintenance is held by a scheduled maintenance window, which
+	// puts it back when it ends. Its health changes don't raise alerts.
+	StateMaintenance
+	// StateUnhealthy failed its last health check.
+	StateUnhealthy
+	// StateDisabled was taken out by an operator until enabled again.
+	StateDisabled
+)
+
+var serverStateNames = []string{"active", "draining", "maintenance", "unhealthy", "disabled"}
+
+func (st ServerState) String() string {
+	if int(st) < len(serverStateNames) {
+		return serverStateNames[st]
+	}
+	return fmt.Sprintf("ServerState(%d)", int(st))
+}
+
+func (st ServerState) MarshalText() ([]byte, error) {
+	return []byte(st.String()), nil
+}
+
+func (st *ServerState) UnmarshalText(b []byte) error {
+	i := slices.Index(serverStateNames, string(b))
+	if i < 0 {
+		return fmt.Errorf("unknown server state %q", b)
+	}
+	*st = ServerState(i)
+	return nil
+}
+
+// State combines the administrative state with health: an administrative
+// state wins, and an active server that fails its checks is unhealthy.
+func (s *Server) State() ServerState {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.AdminState != StateActive {
+		return s.AdminState
+	}
+	if !s.Health {
+		return StateUnhealthy
+	}
+	return StateActive
+}
+
+// transition moves s to the administrative state to if it is in one of
+// from, or unconditionally without from. It reports whether it moved.
+func (s *Server) transition(to ServerState, from ...ServerState) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if len(from) > 0 && !slices.Contains(from, s.AdminState) {
+		return false
+	}
+	s.AdminState = to
+	s.passedOver.Store(to == StateDraining || to == StateMaintenance)
+	return true
+}
+
+// isDisabledByOperator reports whether s is disabled, the one
+// administrative state that takes it out of its heap.
+func (s *Server) isDisabledByOperator() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.AdminState == StateDisabled
+}
+
+// setState moves s to an administrative state as transition does. A
+// disabled server leaves the heap. Draining and maintenance keep it there,
+// still counting its in-flight requests, but passed over by selection;
+// see ServerPool.next.
+func (m *PoolManager) setState(s *Server, to ServerState, from ...ServerState) bool {
+	// Under mu, so a rejoin that already checked s is active can't put
+	// it back after this.
+	m.mu.Lock()
+	ok := s.transition(to, from...)
+	if ok && to == StateDisabled {
+		s.pool.RemoveServer(s)
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	switch {
+	case to == StateDisabled:
+	case !s.CheckHealth():
+		// Left in the heap by a drain while it failed its checks.
+		s.pool.RemoveServer(s)
+	case !s.pool.Joined(s):
+		m.rejoin(s)
+	default:
+		s.pool.selectableChanged()
+	}
+	return true
+}
+
+// Disable takes s out of rotation until Enable, whatever state it was in.
+func (m *PoolManager) Disable(s *Server) {
+	m.setState(s, StateDisabled)
+}
+
+// Enable makes s active again from any administrative state; it rejoins
+// its pool once healthy.
+func (m *PoolManager) Enable(s *Server) {
+	m.setState(s, StateActive)
+}
+
+// Drain stops sending new requests to s. A disabled server stays
+// disabled.
+func (m *PoolManager) Drain(s *Server) bool {
+	return m.setState(s, StateDraining, StateActive, StateMaintenance, StateDraining)
+}
@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/loadbalancer/pluginapi"
+)
+
+func configTargets(c *Config) []pluginapi.Target {
+	var targets []pluginapi.Target
+	c.eachPool(func(name string, pc *PoolConfig) error {
+		for _, sc := range pc.Servers {
+			targets = append(targets, pluginapi.Target{Name: sc.Name, URL: sc.URL, Weight: sc.Weight, Labels: sc.Labels, Pool: name})
+		}
+		return nil
+	})
+	return targets
+}
+
+// reloadConfig re-reads the config file and applies its pools, server
+// lists and strategies. New pools are created; pools dropped from the
+// file stay until deleted through the admin API. Everything else needs a
+// restart. A broken file is logged and changes nothing.
+func (b *Balancer) reloadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	configured := 0
+	err = c.eachPool(func(name string, pc *PoolConfig) error {
+		configured += len(pc.Servers)
+		p := b.pools.Get(name)
+		if p == nil {
+			if _, err := b.pools.Create(name, *pc); err != nil {
+				return err
+			}
+			slog.Info("🆕 Pool created by config reload", "pool", name)
+			return nil
+		}
+		st, _ := strategyByName(pc.Strategy)
+		if p.Strategy().Name() != st.Name() {
+			p.SetStrategy(st)
+			slog.Info("🔀 Pool strategy switched by config reload", "pool", name, "strategy", st.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sp := b.configServers.apply(configTargets(c))
+	slog.Info("🔄 Reloaded config", "path", path, "servers", configured,
+		"added", len(sp.add), "updated", len(sp.reweigh)+len(sp.revive), "replaced", len(sp.replace), "removed", len(sp.remove))
+	return nil
+}
+
+var (
+	logMu   sync.Mutex
+	logFile *os.File
+)
+
+// openLog sends the log to path, replacing the current file. Called again
+// on SIGUSR1 after logrotate has moved the old file away.
+func openLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	logOutput.Set(f)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = f
+	return nil
+}
+
+func (b *Balancer) reopenLog() {
+	if d := b.decisions; d != nil {
+		if err := d.reopen(); err != nil {
+			slog.Warn("⚠️ Reopening the decision log failed, keeping the current one", "path", d.cfg.Path, "err", err)
+		} else {
+			slog.Info("📝 Reopened the decision log", "path", d.cfg.Path)
+		}
+	}
+	if b.cfg.LogFile == "" {
+		slog.Info("📝 No log_file configured; nothing to reopen")
+		return
+	}
+	if err := openLog(b.cfg.LogFile); err != nil {
+		slog.Warn("⚠️ Reopening the log file failed, keeping the current one", "path", b.cfg.LogFile, "err", err)
+		return
+	}
+	slog.Info("📝 Reopened the log file", "path", b.cfg.LogFile)
+}
+
+// dumpPoolState logs one line per server, for a quick look at a running
+// balancer without going through /stats.
+func (b *Balancer) dumpPoolState() {
+	for _, p := range b.pools.Pools() {
+		b.dumpPool(p)
+	}
+}
+
+func (b *Balancer) dumpPool(p *ServerPool) {
+	servers := b.pools.Members(p)
+	slog.Info("📋 Pool", "pool", p.name, "servers", len(servers), "strategy", p.Strategy().Name())
+	for _, s := range servers {
+		st := serverStats(s)
+		state := "down"
+		switch {
+		case st.Maintenance:
+			state = "maintenance"
+		case st.Disabled:
+			state = "disabled"
+		case st.Health:
+			state = "up"
+		}
+		slog.Info("📋 Server", "server", st.Name, "pool", p.name, "state", state, "in_pool", st.InPool,
+			"weight", st.Weight, "active", st.Active, "load", fmt.Sprintf("%.2f", st.Load), "errors", st.totalErrors())
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package balancer
+
+// handleSignals is a no-op where SIGHUP and SIGUSR1/2 don't exist.
+func (b *Balancer) handleSignals(string) {}
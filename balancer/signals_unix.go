@@ -0,0 +1,35 @@
+//go:build unix
+
+package balancer
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals gives the balancer the usual daemon controls: SIGHUP
+// reloads the config, SIGUSR1 reopens the log file and SIGUSR2 dumps the
+// pool state to the log.
+func (b *Balancer) handleSignals(configPath string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range ch {
+			switch sig {
+			case syscall.SIGHUP:
+				if err := b.reloadConfig(configPath); err != nil {
+					slog.Warn("⚠️ Config reload failed, keeping the current config", "err", err)
+					sdNotify("STATUS=Config reload failed: " + err.Error())
+				} else {
+					sdNotify("STATUS=Config reloaded")
+				}
+			case syscall.SIGUSR1:
+				b.reopenLog()
+			case syscall.SIGUSR2:
+				b.dumpPoolState()
+			}
+		}
+	}()
+}
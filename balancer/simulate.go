@@ -0,0 +1,460 @@
+package balancer
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// The simulate subcommand runs a request stream through the routing and
+// strategy code on a virtual clock: routes, the routing script, sticky
+// pins, traffic splits, adaptive limits and the pools' strategies all run
+// as they would, but nothing is proxied and nothing waits. Every
+// configured server counts as healthy.
+
+// simRequest is one request of a recorded stream, one JSON object per
+// line. The fields are those of the dashboard's request tail plus what
+// routing can look at. Time spaces the requests; without it they arrive
+// at -rate.
+type simRequest struct {
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	Client   string            `json:"client"`
+	Headers  map[string]string `json:"headers"`
+	Duration float64           `json:"duration_ms"`
+}
+
+// simOptions shape a simulation. Fail and Slow name servers; slow ones
+// take their own mean time per request, whatever the stream says.
+type simOptions struct {
+	Rate    float64
+	Latency time.Duration
+	Fail    map[string]bool
+	Slow    map[string]time.Duration
+	Seed    uint64
+}
+
+// simReport is what a simulation saw, per pool.
+type simReport struct {
+	Requests int        `json:"requests"`
+	Duration float64    `json:"duration_s"`
+	Pools    []*simPool `json:"pools"`
+}
+
+type simPool struct {
+	Name     string `json:"name"`
+	Strategy string `json:"strategy"`
+	Requests int    `json:"requests"`
+	// Unserved found no server, or every server at its concurrency limit.
+	Unserved int `json:"unserved"`
+	// Retries are requests a failing server got that a retry would send
+	// elsewhere; the retry is made and counted on the server it lands on.
+	Retries int `json:"retries"`
+	// MaxOverMean and CV compare the servers' requests per unit of weight:
+	// the busiest against the average, and the spread (stddev / mean).
+	// 1 and 0 are a perfect balance.
+	MaxOverMean float64      `json:"max_over_mean"`
+	CV          float64      `json:"cv"`
+	Servers     []*simServer `json:"servers"`
+}
+
+type simServer struct {
+	Name     string  `json:"name"`
+	Weight   int     `json:"weight"`
+	Requests int     `json:"requests"`
+	Share    float64 `json:"share"`
+	// WeightShare is the share its weight alone would give it.
+	WeightShare float64 `json:"weight_share"`
+	Failed      int     `json:"failed"`
+	RetriesIn   int     `json:"retries_in"`
+	PeakActive  int     `json:"peak_active"`
+	MeanActive  float64 `json:"mean_active"`
+
+	area  float64 // active requests × seconds, for MeanActive
+	since float64
+}
+
+// simFinish is a request in flight on the virtual clock.
+type simFinish struct {
+	at     float64
+	s      *Server
+	took   time.Duration
+	failed bool
+	cost   int
+}
+
+type simFinishes []simFinish
+
+func (h simFinishes) Len() int           { return len(h) }
+func (h simFinishes) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h simFinishes) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *simFinishes) Push(x any)        { *h = append(*h, x.(simFinish)) }
+func (h *simFinishes) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+type simulation struct {
+	b      *Balancer
+	opts   simOptions
+	rng    *rand.Rand
+	now    float64 // seconds since the first request
+	flight simFinishes
+	pools  map[string]*simPool
+	stats  map[*Server]*simServer
+}
+
+// simulate runs stream through a balancer set up from c.
+func simulate(c Config, stream []simRequest, opts simOptions) (*simReport, error) {
+	// Nothing here should reach the real world or wait to be probed.
+	c.DecisionLog = nil
+	c.StartUnhealthy = false
+	pools := make(map[string]PoolConfig, len(c.Pools))
+	for name, pc := range c.Pools {
+		pc.StartUnhealthy = false
+		pools[name] = pc
+	}
+	c.Pools = pools
+	b, err := newBalancer(c)
+	if err != nil {
+		return nil, err
+	}
+	defer b.stop()
+	for name := range opts.Fail {
+		if b.pools.Find(name) == nil {
+			return nil, fmt.Errorf("-fail: no server %q", name)
+		}
+	}
+	for name := range opts.Slow {
+		if b.pools.Find(name) == nil {
+			return nil, fmt.Errorf("-slow: no server %q", name)
+		}
+	}
+	sim := &simulation{b: b, opts: opts, rng: rand.New(rand.NewPCG(opts.Seed, opts.Seed)),
+		pools: map[string]*simPool{}, stats: map[*Server]*simServer{}}
+	epoch := time.Now()
+	clock := func() time.Time { return epoch.Add(time.Duration(sim.now * float64(time.Second))) }
+	if b.sticky != nil {
+		b.sticky.now = clock
+	}
+	for _, p := range b.pools.Pools() {
+		p.clock, p.rng = clock, sim.rng
+		sp := &simPool{Name: p.name, Strategy: p.Strategy().Name()}
+		sim.pools[p.name] = sp
+		for _, s := range b.pools.Members(p) {
+			ss := &simServer{Name: s.Name, Weight: p.Weight(s)}
+			sim.stats[s] = ss
+			sp.Servers = append(sp.Servers, ss)
+		}
+	}
+
+	var start time.Time
+	for i, sr := range stream {
+		at := float64(i) / opts.Rate
+		if !sr.Time.IsZero() {
+			if start.IsZero() {
+				start = sr.Time
+			}
+			at = sr.Time.Sub(start).Seconds()
+		}
+		sim.finishUntil(max(at, sim.now))
+		sim.request(sr)
+	}
+	sim.finishUntil(math.Inf(1))
+	return sim.report(len(stream)), nil
+}
+
+// request routes one request and puts it in flight on the server it
+// gets, retrying once elsewhere if that server is one that fails.
+func (sim *simulation) request(sr simRequest) {
+	r := sr.httpRequest()
+	rc := matchRoute(sim.b.cfg.Routes, r)
+	p := sim.b.poolFor(rc)
+	sp := sim.pools[p.name]
+	if sp == nil {
+		// A route to a pool that isn't there.
+		sp = &simPool{Name: p.name}
+		sim.pools[p.name] = sp
+	}
+	sp.Requests++
+	s, _ := sim.b.selectServer(r, p)
+	if s == nil {
+		sp.Unserved++
+		return
+	}
+	failed := sim.opts.Fail[s.Name]
+	sim.start(s, sr, failed, rc.cost())
+	if !failed {
+		return
+	}
+	sp.Retries++
+	retry := s.pool.GetNextServerWhere(func(c *Server) bool { return c != s && c.limiter.HasRoom() })
+	if retry == nil || !retry.limiter.Acquire() {
+		return
+	}
+	sim.stats[retry].RetriesIn++
+	sim.start(retry, sr, sim.opts.Fail[retry.Name], rc.cost())
+}
+
+func (sr simRequest) httpRequest() *http.Request {
+	method := cmp.Or(sr.Method, http.MethodGet)
+	host := cmp.Or(sr.Host, "localhost")
+	path := cmp.Or(sr.Path, "/")
+	r, err := http.NewRequest(method, "http://"+host+path, nil)
+	if err != nil {
+		r, _ = http.NewRequest(method, "http://"+host+"/", nil)
+	}
+	r.RemoteAddr = cmp.Or(sr.Client, "127.0.0.1") + ":0"
+	for k, v := range sr.Headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func (sim *simulation) start(s *Server, sr simRequest, failed bool, cost int) {
+	ss := sim.stats[s]
+	sim.account(s)
+	s.pool.AddActive(s, cost)
+	ss.Requests++
+	if failed {
+		ss.Failed++
+	}
+	ss.PeakActive = max(ss.PeakActive, s.pool.Active(s))
+	took := sim.duration(s, sr)
+	heap.Push(&sim.flight, simFinish{at: sim.now + took.Seconds(), s: s, took: took, failed: failed, cost: cost})
+}
+
+// duration is how long s takes with sr: its -slow mean, else what the
+// stream recorded, else the -latency mean. Means are drawn from an
+// exponential distribution.
+func (sim *simulation) duration(s *Server, sr simRequest) time.Duration {
+	mean, ok := sim.opts.Slow[s.Name]
+	if !ok && sr.Duration > 0 {
+		return time.Duration(sr.Duration * float64(time.Millisecond))
+	}
+	if !ok {
+		mean = sim.opts.Latency
+	}
+	return time.Duration(sim.rng.ExpFloat64() * float64(mean))
+}
+
+// finishUntil advances the clock to t, ending the requests due by then
+// the way ForwardRequest does.
+func (sim *simulation) finishUntil(t float64) {
+	for len(sim.flight) > 0 && sim.flight[0].at <= t {
+		f := heap.Pop(&sim.flight).(simFinish)
+		sim.now = f.at
+		sim.account(f.s)
+		f.s.pool.AddActive(f.s, -f.cost)
+		f.s.limiter.Release(f.took, f.failed)
+		f.s.arm.observe(f.s.pool.config.Bandit, f.took, f.failed)
+		f.s.pool.ObserveLatency(f.s, f.took)
+	}
+	if !math.IsInf(t, 1) {
+		sim.now = t
+	}
+}
+
+// account adds s's active requests since its last change to its area.
+func (sim *simulation) account(s *Server) {
+	ss := sim.stats[s]
+	ss.area += float64(s.pool.Active(s)) * (sim.now - ss.since)
+	ss.since = sim.now
+}
+
+func (sim *simulation) report(requests int) *simReport {
+	rep := &simReport{Requests: requests, Duration: sim.now}
+	for _, sp := range sim.pools {
+		if sp.Requests == 0 && len(sp.Servers) == 0 {
+			continue
+		}
+		served := 0
+		weights := 0
+		for _, ss := range sp.Servers {
+			served += ss.Requests
+			weights += ss.Weight
+		}
+		var perWeight []float64
+		for _, ss := range sp.Servers {
+			if served > 0 {
+				ss.Share = float64(ss.Requests) / float64(served)
+			}
+			if weights > 0 {
+				ss.WeightShare = float64(ss.Weight) / float64(weights)
+			}
+			if sim.now > 0 {
+				ss.MeanActive = ss.area / sim.now
+			}
+			perWeight = append(perWeight, float64(ss.Requests)/float64(max(ss.Weight, 1)))
+		}
+		sp.MaxOverMean, sp.CV = imbalance(perWeight)
+		slices.SortFunc(sp.Servers, func(a, b *simServer) int { return strings.Compare(a.Name, b.Name) })
+		rep.Pools = append(rep.Pools, sp)
+	}
+	slices.SortFunc(rep.Pools, func(a, b *simPool) int { return strings.Compare(a.Name, b.Name) })
+	return rep
+}
+
+// imbalance returns the largest of xs over their mean, and their
+// coefficient of variation.
+func imbalance(xs []float64) (maxOverMean, cv float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	sum, top := 0.0, 0.0
+	for _, x := range xs {
+		sum += x
+		top = max(top, x)
+	}
+	mean := sum / float64(len(xs))
+	if mean == 0 {
+		return 0, 0
+	}
+	variance := 0.0
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean)
+	}
+	return top / mean, math.Sqrt(variance/float64(len(xs))) / mean
+}
+
+// synthetic makes n requests from clients distinct client addresses to
+// paths, chosen at random from seed.
+func synthetic(n, clients int, paths []string, seed uint64) []simRequest {
+	rng := rand.New(rand.NewPCG(seed, seed+1))
+	stream := make([]simRequest, n)
+	for i := range stream {
+		c := rng.IntN(max(clients, 1))
+		stream[i] = simRequest{
+			Path:   paths[rng.IntN(len(paths))],
+			Client: fmt.Sprintf("10.%d.%d.%d", c>>16&0xff, c>>8&0xff, c&0xff),
+		}
+	}
+	return stream
+}
+
+// readStream reads a recorded stream, one simRequest per line.
+func readStream(r io.Reader) ([]simRequest, error) {
+	var stream []simRequest
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var sr simRequest
+		if err := json.Unmarshal(sc.Bytes(), &sr); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		stream = append(stream, sr)
+	}
+	return stream, sc.Err()
+}
+
+// simulateCommand is "loadbalancer simulate". It returns the exit code.
+func simulateCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	config := fs.String("config", "config.json", "path to the config file")
+	input := fs.String("input", "", `recorded requests, one JSON object per line ("-" for stdin); synthetic if unset`)
+	requests := fs.Int("requests", 10000, "number of synthetic requests")
+	rate := fs.Float64("rate", 500, "requests per second, for requests without a time")
+	latency := fs.Duration("latency", 50*time.Millisecond, "mean request duration, for requests without one")
+	clients := fs.Int("clients", 100, "number of distinct synthetic client addresses")
+	paths := fs.String("paths", "/", "comma-separated paths synthetic requests pick from")
+	fail := fs.String("fail", "", "comma-separated servers whose requests fail")
+	slow := fs.String("slow", "", "comma-separated name=duration mean request times, e.g. web-2=200ms")
+	seed := fs.Uint64("seed", 1, "random seed")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *rate <= 0 || *latency < 0 || *requests < 0 {
+		fmt.Fprintln(stderr, "simulate: -rate must be positive, -latency and -requests can't be negative")
+		return 2
+	}
+	opts := simOptions{Rate: *rate, Latency: *latency, Fail: map[string]bool{}, Slow: map[string]time.Duration{}, Seed: *seed}
+	for name := range strings.SplitSeq(*fail, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Fail[name] = true
+		}
+	}
+	for kv := range strings.SplitSeq(*slow, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, d, ok := strings.Cut(kv, "=")
+		mean, err := time.ParseDuration(d)
+		if !ok || err != nil {
+			fmt.Fprintf(stderr, "simulate: -slow wants name=duration, got %q\n", kv)
+			return 2
+		}
+		opts.Slow[name] = mean
+	}
+
+	c, err := LoadConfig(*config)
+	if err != nil {
+		fmt.Fprintln(stderr, "simulate:", err)
+		return 1
+	}
+	var stream []simRequest
+	switch *input {
+	case "":
+		stream = synthetic(*requests, *clients, strings.Split(*paths, ","), *seed)
+	case "-":
+		stream, err = readStream(os.Stdin)
+	default:
+		var f *os.File
+		if f, err = os.Open(*input); err == nil {
+			stream, err = readStream(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "simulate:", err)
+		return 1
+	}
+	rep, err := simulate(*c, stream, opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "simulate:", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(rep)
+		return 0
+	}
+	rep.writeText(stdout)
+	return 0
+}
+
+func (rep *simReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%d requests over %.1fs of simulated time\n", rep.Requests, rep.Duration)
+	for _, sp := range rep.Pools {
+		fmt.Fprintf(w, "\npool %s (%s): %d requests, %d unserved, %d would-be retries, max/mean %.2f, cv %.2f\n",
+			sp.Name, sp.Strategy, sp.Requests, sp.Unserved, sp.Retries, sp.MaxOverMean, sp.CV)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "server\tweight\trequests\tshare\tweight share\tfailed\tretries in\tpeak active\tmean active\t")
+		for _, ss := range sp.Servers {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%.1f%%\t%d\t%d\t%d\t%.2f\t\n", ss.Name, ss.Weight, ss.Requests,
+				100*ss.Share, 100*ss.WeightShare, ss.Failed, ss.RetriesIn, ss.PeakActive, ss.MeanActive)
+		}
+		tw.Flush()
+	}
+}
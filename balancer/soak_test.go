@@ -0,0 +1,216 @@
+//go:build soak
+
+package balancer
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run with: go test -tags soak -run TestSoak -soak.duration 5m -v .
+var soakDuration = flag.Duration("soak.duration", 3*time.Minute, "how long TestSoak keeps the balancer under load")
+
+// simBackend behaves like the Backend simulator, with millisecond delays
+// instead of seconds so the soak gets through many requests. While down
+// it drops every connection, so proxying and health checks both fail.
+type simBackend struct {
+	*httptest.Server
+	down atomic.Bool
+}
+
+func newSimBackend(name string) *simBackend {
+	sb := &simBackend{}
+	sb.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sb.down.Load() {
+			if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+		if rand.Intn(100) == 0 {
+			http.Error(w, "simulated failure", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Hello from %s!", name)
+	}))
+	return sb
+}
+
+// ==========================================
+// SOAK: Leaks Under Load and Churn
+// ==========================================
+func TestSoak(t *testing.T) {
+	logOutput.Set(io.Discard)
+	defer logOutput.Set(os.Stderr)
+
+	backends := map[string]*simBackend{}
+	var names []string
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("sim-%d", i)
+		backends[name] = newSimBackend(name)
+		defer backends[name].Close()
+		names = append(names, name)
+	}
+	servers := func(names ...string) string {
+		out := ""
+		for i, n := range names {
+			if i > 0 {
+				out += ","
+			}
+			out += fmt.Sprintf(`{"name": %q, "url": %q, "weight": %d}`, n, backends[n].URL, i+1)
+		}
+		return out
+	}
+	c, err := ParseConfig([]byte(fmt.Sprintf(`{
+		"servers": [%s],
+		"adaptive_concurrency": {"initial_limit": 20},
+		"pools": {"batch": {"lazy_counters": "10ms", "strategy": "least_connections", "servers": [%s]}},
+		"routes": [{"path_prefix": "/batch", "pool": "batch"}]
+	}`, servers(names[:3]...), servers(names[3:]...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := newBalancer(*c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := lb.proxyHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.start(nil)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+	// Every server ever created, so removed ones are checked too.
+	var mu sync.Mutex
+	seen := lb.pools.Servers()
+	closeIdle := func() {
+		client.CloseIdleConnections()
+		for _, p := range lb.pools.Pools() {
+			if tr, ok := p.transport.(interface{ CloseIdleConnections() }); ok {
+				tr.CloseIdleConnections()
+			}
+		}
+	}
+	heapInUse := func() uint64 {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	closeIdle()
+	baseGoroutines, baseHeap := runtime.NumGoroutine(), heapInUse()
+
+	ctx, stop := context.WithTimeout(context.Background(), *soakDuration)
+	defer stop()
+	var wg sync.WaitGroup
+	var requests, failures atomic.Int64
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				path := "/"
+				if rand.Intn(3) == 0 {
+					path = "/batch"
+				}
+				// Some clients give up mid-request.
+				rctx, cancel := context.WithTimeout(ctx, time.Duration(5+rand.Intn(100))*time.Millisecond)
+				req, _ := http.NewRequestWithContext(rctx, "GET", front.URL+path, nil)
+				resp, err := client.Do(req)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				} else {
+					failures.Add(1)
+				}
+				cancel()
+				requests.Add(1)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			time.Sleep(20 * time.Millisecond)
+			name := names[rand.Intn(len(names))]
+			s := lb.pools.Find(name)
+			switch rand.Intn(4) {
+			case 0:
+				if s != nil {
+					lb.pools.Disable(s)
+					time.Sleep(10 * time.Millisecond)
+					lb.pools.Enable(s)
+				}
+			case 1:
+				if s != nil {
+					lb.pools.Unregister(name)
+					fresh := s.pool.newServer(ServerConfig{Name: name, URL: s.URL, Weight: s.Weight})
+					mu.Lock()
+					seen = append(seen, fresh)
+					mu.Unlock()
+					lb.pools.Register(fresh)
+				}
+			case 2:
+				sb := backends[name]
+				sb.down.Store(!sb.down.Load())
+			case 3:
+				lb.checkServers()
+			}
+		}
+	}()
+	wg.Wait()
+	for _, sb := range backends {
+		sb.down.Store(false)
+	}
+	t.Logf("%d requests, %d client-side failures", requests.Load(), failures.Load())
+
+	// Let in-flight proxying, health checks and closing connections
+	// settle, then everything should be back where it started.
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		closeIdle()
+		var busy []string
+		mu.Lock()
+		for _, s := range seen {
+			if n := s.pool.Active(s); n != 0 {
+				busy = append(busy, fmt.Sprintf("%s=%d", s.Name, n))
+			}
+			if s.limiter != nil {
+				s.limiter.mu.Lock()
+				if s.limiter.inflight != 0 {
+					busy = append(busy, fmt.Sprintf("%s limiter=%d", s.Name, s.limiter.inflight))
+				}
+				s.limiter.mu.Unlock()
+			}
+		}
+		mu.Unlock()
+		goroutines := runtime.NumGoroutine()
+		if len(busy) == 0 && goroutines <= baseGoroutines+5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected counters and goroutines back to baseline; active: %v, goroutines %d (baseline %d)",
+				busy, goroutines, baseGoroutines)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if h := heapInUse(); h > baseHeap+32<<20 {
+		t.Errorf("Expected the heap back near its baseline of %d bytes, got %d", baseHeap, h)
+	}
+}
@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// StartupConfig holds off readiness at boot until enough backends have
+// passed a health check, instead of trusting every configured server
+// from the start.
+type StartupConfig struct {
+	// MinHealthy is how many servers, across all pools, must pass a probe.
+	// Defaults to 1.
+	MinHealthy int `json:"min_healthy"`
+	// Timeout gives up waiting and starts anyway, with a warning. Defaults
+	// to 60s.
+	Timeout Duration `json:"timeout"`
+	// HoldListener waits before listening at all. Otherwise the listener
+	// starts at once and only /readyz waits.
+	HoldListener bool `json:"hold_listener"`
+}
+
+func (c *StartupConfig) validate() error {
+	if c.MinHealthy < 0 || c.Timeout < 0 {
+		return fmt.Errorf("startup: min_healthy and timeout can't be negative")
+	}
+	return nil
+}
+
+func (c *StartupConfig) minHealthy() int {
+	if c.MinHealthy > 0 {
+		return c.MinHealthy
+	}
+	return 1
+}
+
+func (c *StartupConfig) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout)
+	}
+	return 60 * time.Second
+}
+
+// startupPollInterval is how often the gate counts healthy servers after
+// its first health check round.
+const startupPollInterval = 250 * time.Millisecond
+
+// confirmedHealthy counts the servers that passed their last probe, and
+// not just because servers start out healthy.
+func (b *Balancer) confirmedHealthy() int {
+	n := 0
+	for _, s := range b.pools.Servers() {
+		if _, success, failures := s.checkFreshness(); !success.IsZero() && failures == 0 && !s.IsDisabled() {
+			n++
+		}
+	}
+	return n
+}
+
+// awaitHealthy runs a health check round and then waits for the servers
+// the startup gate asks for, or its timeout. Either way the balancer is
+// ready afterwards. It reports whether enough servers turned up.
+func (b *Balancer) awaitHealthy(c StartupConfig) bool {
+	defer b.ready.Store(true)
+	want, deadline := c.minHealthy(), time.Now().Add(c.timeout())
+	slog.Info("⏳ Waiting for healthy backends before serving", "want", want, "timeout", c.timeout().String())
+	b.checkServers()
+	for {
+		got := b.confirmedHealthy()
+		if got >= want {
+			slog.Info("✅ Enough backends are healthy, ready to serve", "healthy", got)
+			return true
+		}
+		if time.Now().After(deadline) {
+			slog.Warn("⚠️ Startup gate timed out, serving anyway", "healthy", got, "want", want)
+			return false
+		}
+		time.Sleep(startupPollInterval)
+	}
+}
+
+// readyzHandler answers 200 once the startup gate has passed (at once
+// without one), else 503. It needs no token, for load balancer and
+// orchestrator probes.
+func (b *Balancer) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if b.cfg.Startup != nil && !b.ready.Load() {
+		http.Error(w, "not ready: waiting for healthy backends", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
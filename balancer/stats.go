@@ -0,0 +1,226 @@
+package balancer
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ServerStats struct {
+	Name      string            `json:"name"`
+	URL       string            `json:"url"`
+	HealthURL string            `json:"health_url,omitempty"`
+	Pool      string            `json:"pool"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	InPool    bool              `json:"in_pool"`
+	// State is active, draining, maintenance, unhealthy or disabled.
+	// Disabled and Maintenance are older views of it, kept for existing
+	// clients; disabled is set in every state an operator or schedule
+	// put the server in.
+	State ServerState `json:"state"`
+	// Standby is set for joined servers outside this instance's subset.
+	Standby     bool    `json:"standby,omitempty"`
+	Weight      int     `json:"weight"`
+	Health      bool    `json:"health"`
+	Disabled    bool    `json:"disabled"`
+	Maintenance bool    `json:"maintenance"`
+	Version     string  `json:"version,omitempty"`
+	Active      int     `json:"active_connections"`
+	Load        float64 `json:"load"`
+	// Latency is the smoothed request duration in milliseconds.
+	Latency float64 `json:"latency_ms"`
+	Limit   int     `json:"concurrency_limit,omitempty"`
+	// Reward is the bandit's expected reward, in bandit pools.
+	Reward *float64 `json:"bandit_reward,omitempty"`
+	// Resources is the backend's last resource report, with resources.
+	Resources *ResourceReport `json:"resources,omitempty"`
+	// LastCheck is the older name of LastCheckTime, kept for existing
+	// clients.
+	LastCheck           *time.Time          `json:"last_check"`
+	LastCheckTime       *time.Time          `json:"last_check_time"`
+	LastSuccessTime     *time.Time          `json:"last_success_time"`
+	ConsecutiveFailures int                 `json:"consecutive_failures"`
+	Errors              map[ErrorKind]int64 `json:"errors"`
+	LastError           *lastError          `json:"last_error"`
+	History             []HealthEvent       `json:"health_history"`
+	Connections         ConnStats           `json:"connections"`
+	// Addresses are the resolved addresses of the backend's host name,
+	// with dns.
+	Addresses []AddrStats `json:"addresses,omitempty"`
+}
+
+func serverStats(s *Server) ServerStats {
+	errs, last := s.ErrorStats()
+	st := ServerStats{
+		Name:        s.Name,
+		URL:         s.URL,
+		HealthURL:   s.healthURL,
+		Pool:        s.pool.name,
+		Labels:      s.Labels,
+		InPool:      s.pool.Selectable(s),
+		State:       s.State(),
+		Standby:     s.pool.InStandby(s),
+		Weight:      s.pool.Weight(s),
+		Health:      s.CheckHealth(),
+		Disabled:    s.IsDisabled(),
+		Maintenance: s.InMaintenance(),
+		Version:     s.Version(),
+		Active:      s.pool.Active(s),
+		Load:        s.pool.Load(s),
+		Latency:     s.pool.Latency(s),
+		Limit:       s.limiter.Limit(),
+		Resources:   s.pool.Resources(s),
+		Errors:      errs,
+		LastError:   last,
+		History:     s.HealthHistory(),
+		Connections: s.conns.view(),
+		Addresses:   s.pool.dns.addresses(s.host()),
+	}
+	checked, success, failures := s.checkFreshness()
+	if !checked.IsZero() {
+		st.LastCheck, st.LastCheckTime = &checked, &checked
+	}
+	if !success.IsZero() {
+		st.LastSuccessTime = &success
+	}
+	st.ConsecutiveFailures = failures
+	if usesBandit(s.pool) {
+		reward := s.Reward()
+		st.Reward = &reward
+	}
+	return st
+}
+
+func (st ServerStats) totalErrors() int64 {
+	var n int64
+	for _, v := range st.Errors {
+		n += v
+	}
+	return n
+}
+
+// statsSorters are the keys accepted by ?sort=. Prefix with "-" to reverse.
+var statsSorters = map[string]func(a, b ServerStats) int{
+	"name":   func(a, b ServerStats) int { return strings.Compare(a.Name, b.Name) },
+	"active": func(a, b ServerStats) int { return cmp.Compare(a.Active, b.Active) },
+	"load":   func(a, b ServerStats) int { return cmp.Compare(a.Load, b.Load) },
+	"weight": func(a, b ServerStats) int { return cmp.Compare(a.Weight, b.Weight) },
+	"errors": func(a, b ServerStats) int { return cmp.Compare(a.totalErrors(), b.totalErrors()) },
+}
+
+// matchesHealth implements ?health=up|down|disabled.
+func matchesHealth(st ServerStats, want string) bool {
+	switch want {
+	case "up":
+		return st.Health && !st.Disabled
+	case "down":
+		return !st.Health
+	case "disabled":
+		return st.Disabled
+	}
+	return true
+}
+
+// statsHandler lists servers as JSON. Optional query parameters: pool,
+// health (up, down, disabled), state (active, draining...), label
+// (key=value, repeatable), sort (name, active, load, weight, errors;
+// "-active" for descending), limit and offset. X-Total-Count carries the
+// number of matches before paging.
+func (b *Balancer) statsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, total, err := b.queryStats(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("Content-Type", "application/json")
+	// Monitors judge freshness from it; a cached copy would look stuck.
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// queryStats is the page of servers statsHandler answers r with, and the
+// number of matches before paging.
+func (b *Balancer) queryStats(r *http.Request) (stats []ServerStats, total int, err error) {
+	q := r.URL.Query()
+
+	var sorter func(a, b ServerStats) int
+	if key := q.Get("sort"); key != "" {
+		f, ok := statsSorters[strings.TrimPrefix(key, "-")]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown sort key %q", key)
+		}
+		sorter = f
+		if strings.HasPrefix(key, "-") {
+			sorter = func(a, b ServerStats) int { return f(b, a) }
+		}
+	}
+	if h := q.Get("health"); h != "" && h != "up" && h != "down" && h != "disabled" {
+		return nil, 0, fmt.Errorf("unknown health filter %q", h)
+	}
+	var state *ServerState
+	if v := q.Get("state"); v != "" {
+		state = new(ServerState)
+		if err := state.UnmarshalText([]byte(v)); err != nil {
+			return nil, 0, err
+		}
+	}
+	limit, err := queryInt(q.Get("limit"), 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad limit")
+	}
+	offset, err := queryInt(q.Get("offset"), 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad offset")
+	}
+
+	selector := map[string]string{}
+	for _, l := range q["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, 0, fmt.Errorf("label filter %q must be key=value", l)
+		}
+		selector[k] = v
+	}
+
+	stats = []ServerStats{}
+	for _, s := range b.pools.Servers() {
+		if !s.HasLabels(selector) || !s.pool.visibleTo(callerTenant(r)) {
+			continue
+		}
+		st := serverStats(s)
+		if p := q.Get("pool"); p != "" && st.Pool != p {
+			continue
+		}
+		if !matchesHealth(st, q.Get("health")) || (state != nil && st.State != *state) {
+			continue
+		}
+		stats = append(stats, st)
+	}
+	if sorter != nil {
+		slices.SortStableFunc(stats, sorter)
+	}
+
+	total = len(stats)
+	stats = stats[min(offset, len(stats)):]
+	if limit > 0 && limit < len(stats) {
+		stats = stats[:limit]
+	}
+	return stats, total, nil
+}
+
+func queryInt(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,143 @@
+package balancer
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsDConfig pushes metrics to a StatsD or DogStatsD agent over UDP, for
+// setups that don't scrape /metrics.
+type StatsDConfig struct {
+	Address string `json:"address"`
+	// Prefix is prepended to every metric name. Defaults to "lb.".
+	Prefix string `json:"prefix"`
+	// Flavor is "statsd" (default) or "dogstatsd". Plain StatsD has no
+	// tags, so tag values are appended to the metric name instead.
+	Flavor string `json:"flavor"`
+	// Tags are added to every metric (DogStatsD only), e.g. "env:prod".
+	Tags []string `json:"tags"`
+}
+
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	dog    bool
+	tags   []string
+}
+
+// statsd is nil unless configured; its methods are no-ops then.
+var statsd *statsdClient
+
+func (c *StatsDConfig) validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("statsd: address is required")
+	}
+	switch c.Flavor {
+	case "", "statsd", "dogstatsd":
+	default:
+		return fmt.Errorf("statsd: unknown flavor %q", c.Flavor)
+	}
+	return nil
+}
+
+func newStatsDClient(c StatsDConfig) (*statsdClient, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = "lb."
+	}
+	return &statsdClient{conn: conn, prefix: prefix, dog: c.Flavor == "dogstatsd", tags: c.Tags}, nil
+}
+
+var (
+	statsdNameCleaner = strings.NewReplacer(":", "_", "|", "_", "@", "_", " ", "_", ",", "_", "#", "_")
+	statsdTagCleaner  = strings.NewReplacer("|", "_", "@", "_", " ", "_", ",", "_", "#", "_")
+)
+
+// send writes one metric. tags are "key:value" pairs.
+func (c *statsdClient) send(name, value, typ string, tags ...string) {
+	if c == nil {
+		return
+	}
+	var line strings.Builder
+	line.WriteString(c.prefix)
+	line.WriteString(name)
+	if !c.dog {
+		for _, t := range tags {
+			_, v, _ := strings.Cut(t, ":")
+			if v == "" {
+				continue
+			}
+			line.WriteByte('.')
+			line.WriteString(statsdNameCleaner.Replace(v))
+		}
+	}
+	fmt.Fprintf(&line, ":%s|%s", value, typ)
+	if c.dog {
+		all := append(append([]string(nil), c.tags...), tags...)
+		for i, t := range all {
+			all[i] = statsdTagCleaner.Replace(t)
+		}
+		if len(all) > 0 {
+			line.WriteString("|#")
+			line.WriteString(strings.Join(all, ","))
+		}
+	}
+	// UDP: a lost metric is better than a blocked request.
+	c.conn.Write([]byte(line.String()))
+}
+
+// Count, Timing and Gauge are called on every request; they check for a
+// nil client before formatting anything.
+func (c *statsdClient) Count(name string, n int64, tags ...string) {
+	if c != nil {
+		c.send(name, strconv.FormatInt(n, 10), "c", tags...)
+	}
+}
+
+func (c *statsdClient) Timing(name string, d time.Duration, tags ...string) {
+	if c != nil {
+		c.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'g', -1, 64), "ms", tags...)
+	}
+}
+
+func (c *statsdClient) Gauge(name string, v float64, tags ...string) {
+	if c != nil {
+		c.send(name, strconv.FormatFloat(v, 'g', -1, 64), "g", tags...)
+	}
+}
+
+// reportHealth emits per-server and pool gauges after a health check round.
+// Every pool in all gets a pool gauge, empty ones included.
+func (c *statsdClient) reportHealth(all []*ServerPool, servers []*Server) {
+	if c == nil {
+		return
+	}
+	healthy := map[string]int{}
+	for _, p := range all {
+		healthy[p.name] = 0
+	}
+	for _, s := range servers {
+		up := 0.0
+		if s.CheckHealth() && !s.IsDisabled() {
+			up = 1
+			healthy[s.pool.name]++
+		}
+		c.Gauge("backend.healthy", up, "backend:"+s.Name)
+		c.Gauge("backend.active_connections", float64(s.pool.Active(s)), "backend:"+s.Name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(healthy)) {
+		c.Gauge("pool.healthy", float64(healthy[name]), "pool:"+name)
+	}
+}
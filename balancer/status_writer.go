@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"net/http"
+	"sync"
+)
+
+// statusWriter remembers the final status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusWriters saves allocating a statusWriter for every request.
+var statusWriters = sync.Pool{New: func() any { return new(statusWriter) }}
+
+func getStatusWriter(w http.ResponseWriter) *statusWriter {
+	sw := statusWriters.Get().(*statusWriter)
+	sw.ResponseWriter = w
+	return sw
+}
+
+// putStatusWriter returns sw to the pool. It must not be used afterwards.
+func putStatusWriter(sw *statusWriter) {
+	*sw = statusWriter{}
+	statusWriters.Put(sw)
+}
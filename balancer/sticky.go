@@ -0,0 +1,236 @@
+package balancer
+
+import (
+	"cmp"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// StickyConfig pins each client to the backend that served it first.
+// The table lives in memory, so it is bounded by both TTL and size.
+type StickyConfig struct {
+	// TTL is how long an idle pin is kept. Every request refreshes it.
+	// Defaults to 30m.
+	TTL Duration `json:"ttl"`
+	// MaxEntries caps the table. The least recently used pin is evicted
+	// when it is full. Defaults to 100000.
+	MaxEntries int `json:"max_entries"`
+}
+
+func (c *StickyConfig) validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("sticky: ttl must not be negative")
+	}
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("sticky: max_entries must not be negative")
+	}
+	return nil
+}
+
+var stickyEvictions = newCounterVec("lb_sticky_evictions_total",
+	"Sticky session pins dropped, by reason (expired, size, backend_gone).", "reason")
+
+var stickySessions = newFuncMetric("lb_sticky_sessions",
+	"Client pins currently held in the sticky session table.", "gauge", func(b *Balancer) []metricSample {
+		if b.sticky == nil {
+			return nil
+		}
+		return []metricSample{{value: float64(b.sticky.Len())}}
+	})
+
+var stickyRemaps = newCounterVec("lb_sticky_remaps_total",
+	"Sticky sessions moved off a backend that left its pool, by that backend.", "backend")
+
+// remapReportTTL is how long a backend stays in the remap report after
+// its last session moved.
+const remapReportTTL = 24 * time.Hour
+
+// stickyTable is an LRU of client key -> server. With one TTL for all
+// entries and every hit refreshing it, the back of the list is always the
+// next to expire, so expired pins are trimmed from there.
+type stickyTable struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu    sync.Mutex
+	order *list.List // of *stickyEntry, most recent first
+	byKey map[string]*list.Element
+	// remaps counts the sessions moved off each backend that left.
+	remaps map[string]*StickyRemap
+}
+
+// StickyRemap is how many sessions were moved off a backend after it
+// left its pool, and where to. A session counts when its client comes
+// back, so First is about when the first users noticed.
+type StickyRemap struct {
+	Backend  string         `json:"backend"`
+	Pool     string         `json:"pool"`
+	Sessions int            `json:"sessions"`
+	First    time.Time      `json:"first"`
+	Last     time.Time      `json:"last"`
+	To       map[string]int `json:"to"`
+}
+
+type stickyEntry struct {
+	key     string
+	server  *Server
+	expires time.Time
+}
+
+func newStickyTable(c StickyConfig) *stickyTable {
+	t := &stickyTable{
+		ttl:   time.Duration(c.TTL),
+		max:   c.MaxEntries,
+		now:   time.Now,
+		order: list.New(),
+		byKey: make(map[string]*list.Element),
+
+		remaps: make(map[string]*StickyRemap),
+	}
+	if t.ttl <= 0 {
+		t.ttl = 30 * time.Minute
+	}
+	if t.max <= 0 {
+		t.max = 100000
+	}
+	return t
+}
+
+// acquire returns the server key is pinned to, with a concurrency slot
+// taken, if it is still in the pool and has room. If it left the pool the
+// pin moves to key's consistent hash server among the rest, so every
+// instance moves the same sessions to the same place. Otherwise the pin
+// is left for pin to replace and nil is returned.
+func (t *stickyTable) acquire(key string) *Server {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.trim(now)
+	el, ok := t.byKey[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*stickyEntry)
+	if from := e.server; !from.pool.Selectable(from) {
+		to := from.pool.GetServerForKey(key, func(s *Server) bool { return s.limiter.HasRoom() })
+		if to == nil || !to.limiter.Acquire() {
+			t.remove(el, "backend_gone")
+			return nil
+		}
+		e.server, e.expires = to, now.Add(t.ttl)
+		t.order.MoveToFront(el)
+		t.remapped(from, to, now)
+		return to
+	}
+	if !e.server.limiter.Acquire() {
+		return nil
+	}
+	e.expires = now.Add(t.ttl)
+	t.order.MoveToFront(el)
+	return e.server
+}
+
+// pin records that key is served by s.
+func (t *stickyTable) pin(key string, s *Server) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.trim(now)
+	if el, ok := t.byKey[key]; ok {
+		e := el.Value.(*stickyEntry)
+		e.server, e.expires = s, now.Add(t.ttl)
+		t.order.MoveToFront(el)
+		return
+	}
+	for t.order.Len() >= t.max {
+		t.remove(t.order.Back(), "size")
+	}
+	t.byKey[key] = t.order.PushFront(&stickyEntry{key: key, server: s, expires: now.Add(t.ttl)})
+}
+
+// trim drops expired pins. Callers hold t.mu.
+func (t *stickyTable) trim(now time.Time) {
+	for el := t.order.Back(); el != nil && !now.Before(el.Value.(*stickyEntry).expires); el = t.order.Back() {
+		t.remove(el, "expired")
+	}
+}
+
+func (t *stickyTable) remove(el *list.Element, reason string) {
+	t.order.Remove(el)
+	delete(t.byKey, el.Value.(*stickyEntry).key)
+	stickyEvictions.Inc(reason)
+}
+
+// remapped records a session moving from one backend to another. Callers
+// hold t.mu.
+func (t *stickyTable) remapped(from, to *Server, now time.Time) {
+	stickyRemaps.Inc(from.Name)
+	rm := t.remaps[from.Name]
+	if rm == nil {
+		rm = &StickyRemap{Backend: from.Name, Pool: from.pool.name, First: now, To: map[string]int{}}
+		t.remaps[from.Name] = rm
+	}
+	rm.Sessions++
+	rm.Last = now
+	rm.To[to.Name]++
+}
+
+// remapReport lists the backends sessions were moved off in the last
+// remapReportTTL, latest first, and forgets older ones.
+func (t *stickyTable) remapReport() []StickyRemap {
+	report := []StickyRemap{}
+	if t == nil {
+		return report
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for name, rm := range t.remaps {
+		if now.Sub(rm.Last) > remapReportTTL {
+			delete(t.remaps, name)
+			continue
+		}
+		c := *rm
+		c.To = maps.Clone(rm.To)
+		report = append(report, c)
+	}
+	slices.SortFunc(report, func(a, b StickyRemap) int {
+		return cmp.Or(b.Last.Compare(a.Last), cmp.Compare(a.Backend, b.Backend))
+	})
+	return report
+}
+
+// stickyHandler reports the sticky table's size and the sessions moved
+// off backends that left, to line them up with backend failures.
+func (b *Balancer) stickyHandler(w http.ResponseWriter, r *http.Request) {
+	var sessions int
+	if b.sticky != nil {
+		sessions = b.sticky.Len()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Enabled  bool          `json:"enabled"`
+		Sessions int           `json:"sessions"`
+		Remaps   []StickyRemap `json:"remaps"`
+	}{b.sticky != nil, sessions, b.sticky.remapReport()})
+}
+
+func (t *stickyTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.order.Len()
+}
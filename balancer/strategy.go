@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Strategy computes the "effective load" the heap orders servers by.
+// Lower is better; the root of the heap is always the least loaded server.
+// Load is called with the pool lock held, so it must not call back into
+// the pool.
+type Strategy interface {
+	Name() string
+	Load(s *Server) float64
+}
+
+type leastConnections struct{}
+
+func (leastConnections) Name() string { return "least_connections" }
+func (leastConnections) Load(s *Server) float64 {
+	return s.predictedActive()
+}
+
+// weightedLeastConnections is the original formula: ActiveConnections / Weight.
+type weightedLeastConnections struct{}
+
+func (weightedLeastConnections) Name() string { return "weighted_least_connections" }
+func (weightedLeastConnections) Load(s *Server) float64 {
+	return s.predictedActive() / float64(s.Weight)
+}
+
+// ewmaLatency prefers the server that has been answering fastest lately.
+// Servers without samples yet score 0, so new servers get tried quickly.
+type ewmaLatency struct{}
+
+func (ewmaLatency) Name() string { return "ewma_latency" }
+func (ewmaLatency) Load(s *Server) float64 {
+	return s.LatencyEWMA
+}
+
+// leastTime weighs latency by load, like HAProxy's leastime: a fast
+// server is preferred until it has enough requests queued that a slower,
+// idle one would answer sooner. Servers without samples score 0.
+type leastTime struct{}
+
+func (leastTime) Name() string { return "least_time" }
+func (leastTime) Load(s *Server) float64 {
+	return s.LatencyEWMA * (s.predictedActive() + 1)
+}
+
+// consistentHash sends each client key to the same server for as long as
+// that server stays in the pool; see hash.go. The heap, ordered like
+// weighted_least_connections, is only used for prewarming and stats.
+type consistentHash struct{ weightedLeastConnections }
+
+func (consistentHash) Name() string { return "consistent_hash" }
+
+var defaultStrategy Strategy = weightedLeastConnections{}
+
+var strategies = map[string]Strategy{
+	"least_connections":          leastConnections{},
+	"weighted_least_connections": weightedLeastConnections{},
+	"ewma_latency":               ewmaLatency{},
+	"least_time":                 leastTime{},
+	"consistent_hash":            consistentHash{},
+	"bandit":                     bandit{},
+	"resource_aware":             resourceAware{},
+}
+
+func strategyByName(name string) (Strategy, error) {
+	if name == "" {
+		return defaultStrategy, nil
+	}
+	st, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q (have %v)", name, strategyNames())
+	}
+	return st, nil
+}
+
+func strategyNames() []string {
+	var names []string
+	for n := range strategies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latencyEWMAAlpha weighs the newest sample; 0.2 smooths over roughly the
+// last ten requests.
+const latencyEWMAAlpha = 0.2
+
+func updateEWMA(current float64, sample time.Duration) float64 {
+	ms := float64(sample) / float64(time.Millisecond)
+	if current == 0 {
+		return ms
+	}
+	return latencyEWMAAlpha*ms + (1-latencyEWMAAlpha)*current
+}
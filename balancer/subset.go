@@ -0,0 +1,164 @@
+package balancer
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// SubsetConfig limits each balancer instance to Size servers of a large
+// pool, so that not every instance holds connections to every backend.
+// Instances rank the servers by a hash of their ID and the server name
+// (rendezvous hashing) and keep the top Size. Different IDs get different
+// subsets, which together cover the pool about evenly. When a server
+// joins or leaves, at most one server moves in or out of each subset.
+type SubsetConfig struct {
+	Size int `json:"size"`
+	// ID identifies this instance. Defaults to the host name, plus the
+	// worker number under prefork.
+	ID string `json:"id"`
+}
+
+func (c *SubsetConfig) validate() error {
+	if c.Size <= 0 {
+		return fmt.Errorf("subset: size must be positive")
+	}
+	return nil
+}
+
+// subsetter ranks servers for one instance.
+type subsetter struct {
+	size int
+	id   string
+}
+
+func newSubsetter(c SubsetConfig) *subsetter {
+	id := c.ID
+	if id == "" {
+		id, _ = os.Hostname()
+		if w := workerID(); w > 0 {
+			id += "/" + strconv.Itoa(w)
+		}
+	}
+	return &subsetter{size: c.Size, id: id}
+}
+
+// rank is s's score for this instance; the highest ranked are kept.
+func (ss *subsetter) rank(s *Server) uint64 {
+	return rendezvousHash(ss.id, s.Name)
+}
+
+// rendezvousHash scores name for key. Ranking names by it gives each key
+// its own order, and removing a name only moves the keys it ranked first.
+func rendezvousHash(key, name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(name))
+	// FNV barely mixes the last bytes, and names often differ only there.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// setSubset turns subsetting on or off, or changes its size.
+func (p *ServerPool) setSubset(c *SubsetConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c == nil {
+		for s := range p.standby {
+			p.push(s)
+		}
+		p.subset, p.standby = nil, nil
+		return
+	}
+	p.subset = newSubsetter(*c)
+	if p.standby == nil {
+		p.standby = map[*Server]bool{}
+	}
+	p.resubset()
+}
+
+// resubset moves servers between the heap and standby so that the heap
+// holds the best ranked joined servers. The caller holds the pool lock.
+func (p *ServerPool) resubset() {
+	if p.subset == nil {
+		return
+	}
+	joined := slices.Concat(p.servers.items, slices.Collect(maps.Keys(p.standby)))
+	// Draining servers go last, so the subset is made of servers that
+	// take traffic.
+	slices.SortFunc(joined, func(a, b *Server) int {
+		return cmp.Or(compareBool(a.passedOver.Load(), b.passedOver.Load()),
+			cmp.Compare(p.subset.rank(b), p.subset.rank(a)), cmp.Compare(a.Name, b.Name))
+	})
+	for i, s := range joined {
+		switch keep := i < p.subset.size; {
+		case keep && p.standby[s]:
+			delete(p.standby, s)
+			p.push(s)
+		case !keep && p.contains(s):
+			p.pop(s)
+			p.standby[s] = true
+		}
+	}
+}
+
+// InStandby reports whether s has joined the pool but is left out of
+// this instance's subset.
+func (p *ServerPool) InStandby(s *Server) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.standby[s]
+}
+
+// push puts s in the heap. The caller holds the pool lock.
+func (p *ServerPool) push(s *Server) {
+	s.fold()
+	heap.Push(&p.servers, s)
+	if p.added != nil {
+		close(p.added)
+		p.added = nil
+	}
+	p.publish(ServerAdded, s, s.CheckHealth())
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// selectableChanged is called when a server in the heap starts or stops
+// being passed over. The subset gives its slot to a server that can take
+// traffic, and waiters for an empty pool are woken.
+func (p *ServerPool) selectableChanged() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.resubset()
+	if p.added != nil {
+		close(p.added)
+		p.added = nil
+	}
+}
+
+// pop takes s out of the heap. The caller holds the pool lock.
+func (p *ServerPool) pop(s *Server) {
+	heap.Remove(&p.servers, s.Index)
+	s.Index = -1
+	p.publish(ServerRemoved, s, s.CheckHealth())
+}
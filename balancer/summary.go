@@ -0,0 +1,192 @@
+package balancer
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateWindow counts events in one-second buckets over the last
+// rateWindowSize seconds, enough for 1/5/15 minute rates.
+const rateWindowSize = 15 * 60
+
+type rateWindow struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	buckets [rateWindowSize]struct {
+		sec   int64
+		count int64
+	}
+}
+
+func newRateWindow() *rateWindow {
+	return &rateWindow{now: time.Now}
+}
+
+func (rw *rateWindow) Inc() {
+	sec := rw.now().Unix()
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	b := &rw.buckets[sec%rateWindowSize]
+	if b.sec != sec {
+		b.sec, b.count = sec, 0
+	}
+	b.count++
+}
+
+// Sum returns the number of events in the last d (rounded to seconds,
+// including the current one).
+func (rw *rateWindow) Sum(d time.Duration) int64 {
+	now := rw.now().Unix()
+	oldest := now - int64(d/time.Second) + 1
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	var n int64
+	for _, b := range rw.buckets {
+		if b.sec >= oldest && b.sec <= now {
+			n += b.count
+		}
+	}
+	return n
+}
+
+var (
+	requestWindow = newRateWindow()
+	errorWindow   = newRateWindow()
+)
+
+// processStart is when the process started, for the uptime.
+var processStart = time.Now()
+
+// requestTotals counts proxied requests since the process started, by
+// status class: classes[2] is 2xx and so on. Config reloads don't reset it.
+var requestTotals struct {
+	all     atomic.Int64
+	classes [6]atomic.Int64
+}
+
+// countResponses counts every request that reaches the proxy, refusals
+// before a backend is picked included, by the class of its status. One
+// that panics is a 5xx: it gets a 502 or a cut-off response.
+func countResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := getStatusWriter(w)
+		defer putStatusWriter(sw)
+		done := false
+		defer func() {
+			status := sw.status
+			switch {
+			case !done:
+				status = http.StatusBadGateway
+			case status == 0:
+				// Nothing written is an empty 200.
+				status = http.StatusOK
+			}
+			requestTotals.all.Add(1)
+			if c := status / 100; c >= 1 && c <= 5 {
+				requestTotals.classes[c].Add(1)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+		done = true
+	})
+}
+
+var summaryWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+type poolSummary struct {
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	Disabled  int `json:"disabled"`
+}
+
+type clusterSummary struct {
+	Pools             map[string]*poolSummary `json:"pools"`
+	ActiveConnections int                     `json:"active_connections"`
+	// RPS and ErrorRate are keyed by window: "1m", "5m", "15m". They
+	// cover the whole balancer, so tenants don't get them.
+	RPS       map[string]float64 `json:"rps,omitempty"`
+	ErrorRate map[string]float64 `json:"error_rate,omitempty"`
+	// Totals are since the process started; tenants don't get them either.
+	*ProcessTotals
+}
+
+type ProcessTotals struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	RequestsTotal int64     `json:"requests_total"`
+	// ResponsesTotal is keyed by status class: "1xx" to "5xx".
+	ResponsesTotal map[string]int64 `json:"responses_total"`
+}
+
+func currentTotals() *ProcessTotals {
+	t := &ProcessTotals{
+		StartedAt:      processStart,
+		UptimeSeconds:  math.Floor(time.Since(processStart).Seconds()),
+		RequestsTotal:  requestTotals.all.Load(),
+		ResponsesTotal: map[string]int64{},
+	}
+	for c := 1; c <= 5; c++ {
+		t.ResponsesTotal[strconv.Itoa(c)+"xx"] = requestTotals.classes[c].Load()
+	}
+	return t
+}
+
+// buildSummary summarizes the pools tenant may see, all for "".
+func (b *Balancer) buildSummary(tenant string) clusterSummary {
+	sum := clusterSummary{Pools: map[string]*poolSummary{}}
+	for _, p := range b.pools.Pools() {
+		if p.visibleTo(tenant) {
+			sum.Pools[p.name] = &poolSummary{}
+		}
+	}
+	for _, s := range b.pools.Servers() {
+		p := sum.Pools[s.pool.name]
+		if p == nil {
+			// Not the tenant's, or its pool was deleted while we were
+			// looking.
+			continue
+		}
+		switch {
+		case s.IsDisabled():
+			p.Disabled++
+		case s.CheckHealth():
+			p.Healthy++
+		default:
+			p.Unhealthy++
+		}
+		sum.ActiveConnections += s.pool.Active(s)
+	}
+	if tenant != "" {
+		return sum
+	}
+	sum.ProcessTotals = currentTotals()
+	sum.RPS, sum.ErrorRate = map[string]float64{}, map[string]float64{}
+	for _, w := range summaryWindows {
+		reqs, errs := requestWindow.Sum(w.d), errorWindow.Sum(w.d)
+		sum.RPS[w.name] = float64(reqs) / w.d.Seconds()
+		if reqs > 0 {
+			sum.ErrorRate[w.name] = float64(errs) / float64(reqs)
+		} else {
+			sum.ErrorRate[w.name] = 0
+		}
+	}
+	return sum
+}
+
+func (b *Balancer) summaryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(b.buildSummary(callerTenant(r)))
+}
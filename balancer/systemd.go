@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdListenFDsStart is the first file descriptor systemd passes, see
+// sd_listen_fds(3).
+const sdListenFDsStart = 3
+
+// systemdListener returns the socket systemd passed us with socket
+// activation, or nil if we weren't socket-activated. With several sockets
+// the one named "http" (FileDescriptorName=) is used, else the first.
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	fd := sdListenFDsStart
+	for i := range n {
+		if i < len(names) && names[i] == "http" {
+			fd = sdListenFDsStart + i
+			break
+		}
+	}
+	f := os.NewFile(uintptr(fd), "systemd-socket")
+	ln, err := net.FileListener(f)
+	f.Close() // FileListener dups the descriptor
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket activation: %w", err)
+	}
+	return ln, nil
+}
+
+// sdNotify sends a state change to systemd (READY=1, WATCHDOG=1...). It
+// does nothing when not started by systemd with Type=notify.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval is how often to ping systemd's watchdog: half of
+// WatchdogSec, or 0 if the watchdog is off for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifyReady tells systemd we are serving on addr and starts the
+// watchdog pings.
+func notifyReady(addr string) {
+	if err := sdNotify("READY=1\nSTATUS=Serving on " + addr); err != nil {
+		slog.Warn("⚠️ sd_notify failed", "err", err)
+	}
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				sdNotify("WATCHDOG=1")
+			}
+		}()
+	}
+}
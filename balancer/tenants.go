@@ -0,0 +1,300 @@
+package balancer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TenantConfig is one team's namespace on a shared balancer: its own
+// pools, routes, admin tokens and request rate. Its pools and servers are
+// registered as "<tenant>.<name>", and its tokens only see and manage
+// those through the admin API.
+type TenantConfig struct {
+	Pools map[string]PoolConfig `json:"pools"`
+	// Routes must match on host or path_prefix and send their requests
+	// to one of the tenant's pools. They are tried before the top-level
+	// routes.
+	Routes      []RouteConfig    `json:"routes"`
+	AdminTokens []AdminToken     `json:"admin_tokens"`
+	RateLimit   *RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// RateLimitConfig caps the requests per second of a tenant's routes, with
+// bursts of up to Burst. Requests over it get 429.
+type RateLimitConfig struct {
+	RPS float64 `json:"rps"`
+	// Burst defaults to RPS, and at least 1.
+	Burst int `json:"burst"`
+}
+
+func (c *RateLimitConfig) validate() error {
+	if c.RPS <= 0 {
+		return fmt.Errorf("rate_limit.rps must be positive")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("rate_limit.burst must not be negative")
+	}
+	return nil
+}
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// tenantName qualifies a tenant's pool, server or route name.
+func tenantName(tenant, name string) string {
+	return tenant + "." + name
+}
+
+// applyTenants validates the tenants and merges their pools, routes and
+// tokens into c under qualified names, so everything else treats them as
+// ordinary config.
+func (c *Config) applyTenants() error {
+	var routes []RouteConfig
+	for _, t := range slices.Sorted(maps.Keys(c.Tenants)) {
+		tc := c.Tenants[t]
+		if !tenantNamePattern.MatchString(t) {
+			return fmt.Errorf("tenants: invalid name %q (lowercase letters, digits, - and _)", t)
+		}
+		if rl := tc.RateLimit; rl != nil {
+			if err := rl.validate(); err != nil {
+				return fmt.Errorf("tenants.%s: %w", t, err)
+			}
+		}
+		for name, pc := range tc.Pools {
+			if name == "" {
+				return fmt.Errorf("tenants.%s: empty pool name", t)
+			}
+			qualified := tenantName(t, name)
+			if _, ok := c.Pools[qualified]; ok {
+				return fmt.Errorf("tenants.%s: pool %q clashes with pool %q", t, name, qualified)
+			}
+			pc.tenant = t
+			pc.Servers = slices.Clone(pc.Servers)
+			for i := range pc.Servers {
+				pc.Servers[i].Name = tenantName(t, pc.Servers[i].Name)
+			}
+			if c.Pools == nil {
+				c.Pools = map[string]PoolConfig{}
+			}
+			c.Pools[qualified] = pc
+		}
+		for i, rc := range tc.Routes {
+			if rc.Host == "" && rc.PathPrefix == "" {
+				return fmt.Errorf("tenants.%s: routes[%d] must match on host or path_prefix", t, i)
+			}
+			if _, ok := tc.Pools[rc.Pool]; !ok {
+				return fmt.Errorf("tenants.%s: routes[%d]: unknown pool %q", t, i, rc.Pool)
+			}
+			rc.tenant = t
+			rc.Pool = tenantName(t, rc.Pool)
+			if rc.Name != "" {
+				rc.Name = tenantName(t, rc.Name)
+			}
+			routes = append(routes, rc)
+		}
+		for _, at := range tc.AdminTokens {
+			at.tenant = t
+			c.AdminTokens = append(c.AdminTokens, at)
+		}
+	}
+	for name := range c.Pools {
+		if t, _, ok := strings.Cut(name, "."); ok && c.Pools[name].tenant == "" {
+			if _, ok := c.Tenants[t]; ok {
+				return fmt.Errorf("pools: %q is in the namespace of tenant %s", name, t)
+			}
+		}
+	}
+	c.Routes = append(routes, c.Routes...)
+	c.tenantsApplied = true
+	return nil
+}
+
+// tenantKey is the request context key for the tenant an admin token is
+// limited to.
+type tenantKey struct{}
+
+// callerTenant is the tenant the admin API caller is limited to, or ""
+// for a top-level token.
+func callerTenant(r *http.Request) string {
+	t, _ := r.Context().Value(tenantKey{}).(string)
+	return t
+}
+
+func withTenant(r *http.Request, tenant string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+}
+
+// visibleTo says whether the caller limited to tenant may see p; a
+// top-level caller sees everything.
+func (p *ServerPool) visibleTo(tenant string) bool {
+	return tenant == "" || p.config.tenant == tenant
+}
+
+// qualifyName resolves a pool or server name given by a tenant caller,
+// who may leave out its own prefix.
+func qualifyName(tenant, name string) string {
+	if tenant == "" || strings.HasPrefix(name, tenant+".") {
+		return name
+	}
+	return tenantName(tenant, name)
+}
+
+// tenantLimits applies the tenants' rate limits to their routes. The
+// admin API can change them at runtime; see set.
+type tenantLimits struct {
+	routes []RouteConfig
+
+	mu      sync.RWMutex
+	buckets map[string]*tokenBucket
+}
+
+// newTenantLimits returns nil if there are no tenants.
+func newTenantLimits(c *Config) *tenantLimits {
+	if len(c.Tenants) == 0 {
+		return nil
+	}
+	tl := &tenantLimits{routes: c.Routes, buckets: map[string]*tokenBucket{}}
+	for t, tc := range c.Tenants {
+		if rl := tc.RateLimit; rl != nil {
+			tl.buckets[t] = newTokenBucket(*rl)
+		}
+	}
+	return tl
+}
+
+// get returns the rate limit of tenant, nil if it has none.
+func (tl *tenantLimits) get(tenant string) *RateLimitConfig {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	if tb := tl.buckets[tenant]; tb != nil {
+		return &RateLimitConfig{RPS: tb.rate, Burst: int(tb.burst)}
+	}
+	return nil
+}
+
+// set replaces the rate limit of tenant, or removes it when c is nil.
+// The new bucket starts full.
+func (tl *tenantLimits) set(tenant string, c *RateLimitConfig) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if c == nil {
+		delete(tl.buckets, tenant)
+		return
+	}
+	tl.buckets[tenant] = newTokenBucket(*c)
+}
+
+func (tl *tenantLimits) bucket(tenant string) *tokenBucket {
+	tl.mu.RLock()
+	defer tl.mu.RUnlock()
+	return tl.buckets[tenant]
+}
+
+func (tl *tenantLimits) Wrap(next http.Handler) http.Handler {
+	if tl == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rc := matchRoute(tl.routes, r); rc != nil && rc.tenant != "" {
+			if tb := tl.bucket(rc.tenant); tb != nil {
+				if wait, ok := tb.take(time.Now()); !ok {
+					tenantRateLimited.Inc(rc.tenant)
+					writeBackpressure(w, http.StatusTooManyRequests, int(math.Ceil(wait.Seconds())), int64(tb.burst))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var tenantRateLimited = newCounterVec("lb_tenant_rate_limited_total",
+	"Requests refused by a tenant's rate limit.", "tenant")
+
+// tokenBucket refills at rate tokens a second up to burst.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(c RateLimitConfig) *tokenBucket {
+	burst := float64(c.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(c.RPS))
+	}
+	return &tokenBucket{rate: c.RPS, burst: burst, tokens: burst}
+}
+
+// take spends a token if there is one, else says how long until there is.
+func (tb *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if !tb.last.IsZero() {
+		tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	}
+	tb.last = now
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second)), false
+}
+
+// adminTenant returns the tenant named in r's path, or answers 404. A
+// tenant's own tokens only see their tenant.
+func (b *Balancer) adminTenant(w http.ResponseWriter, r *http.Request) (string, bool) {
+	t := r.PathValue("tenant")
+	if _, ok := b.cfg.Tenants[t]; !ok || (callerTenant(r) != "" && callerTenant(r) != t) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return "", false
+	}
+	return t, true
+}
+
+func (b *Balancer) adminGetRateLimit(w http.ResponseWriter, r *http.Request) {
+	t, ok := b.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.rateLimits.get(t))
+}
+
+// adminSetRateLimit replaces a tenant's rate limit (PUT, with the same
+// JSON as in the config) or removes it (DELETE). It applies to the next
+// request; a config reload doesn't change it back, a restart does.
+func (b *Balancer) adminSetRateLimit(w http.ResponseWriter, r *http.Request) {
+	t, ok := b.adminTenant(w, r)
+	if !ok {
+		return
+	}
+	var rl *RateLimitConfig
+	if r.Method == http.MethodPut {
+		rl = new(RateLimitConfig)
+		if err := json.NewDecoder(r.Body).Decode(rl); err != nil {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rl.validate(); err != nil {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	old := b.rateLimits.get(t)
+	b.rateLimits.set(t, rl)
+	slog.Info("🚦 Tenant rate limit changed via admin API", "tenant", t, "old", old, "new", rl, "client", clientIP(r))
+	w.WriteHeader(http.StatusNoContent)
+}
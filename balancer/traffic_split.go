@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TrafficSplit routes Percent of requests to the servers carrying all of
+// Labels, e.g. {"labels": {"version": "v2"}, "percent": 10} for a canary.
+// Requests outside every split go to servers matching none of them.
+type TrafficSplit struct {
+	Labels  map[string]string `json:"labels"`
+	Percent float64           `json:"percent"`
+}
+
+func validateTrafficSplit(splits []TrafficSplit) error {
+	total := 0.0
+	for i, sp := range splits {
+		if len(sp.Labels) == 0 {
+			return fmt.Errorf("traffic_split[%d]: labels are required", i)
+		}
+		if sp.Percent < 0 || sp.Percent > 100 {
+			return fmt.Errorf("traffic_split[%d]: percent must be between 0 and 100", i)
+		}
+		total += sp.Percent
+	}
+	if total > 100 {
+		return errors.New("traffic_split: percentages add up to more than 100")
+	}
+	return nil
+}
+
+// HasLabels reports whether s carries every key/value in sel.
+func (s *Server) HasLabels(sel map[string]string) bool {
+	for k, v := range sel {
+		if s.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// splitRoll returns a number in [0, 100) from p's random source;
+// replaced in tests.
+var splitRoll = func(p *ServerPool) float64 { return p.float64() * 100 }
+
+// pickServer applies p's traffic split on top of the heap. When the
+// chosen group has no server available it falls back to the rest, then
+// to any server, rather than failing the request. Scheduled splits only
+// apply to the default pool.
+func (b *Balancer) pickServer(p *ServerPool) *Server {
+	splits := p.config.TrafficSplit
+	if o := b.splitOverride.Load(); o != nil && p.name == defaultPoolName {
+		splits = *o
+	}
+	if len(splits) == 0 {
+		return p.GetNextServer()
+	}
+	roll := splitRoll(p)
+	for _, sp := range splits {
+		if roll < sp.Percent {
+			if s := p.GetNextServerWhere(func(s *Server) bool { return s.HasLabels(sp.Labels) }); s != nil {
+				return s
+			}
+			break
+		}
+		roll -= sp.Percent
+	}
+	rest := func(s *Server) bool {
+		for _, sp := range splits {
+			if s.HasLabels(sp.Labels) {
+				return false
+			}
+		}
+		return true
+	}
+	if s := p.GetNextServerWhere(rest); s != nil {
+		return s
+	}
+	return p.GetNextServer()
+}
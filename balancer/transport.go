@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// BackendTLSConfig configures TLS (and optionally mTLS) from the balancer to
+// a pool's backends. CertFile/KeyFile are our client certificate, CAFile
+// verifies the backends instead of the system roots.
+type BackendTLSConfig struct {
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	CAFile             string `json:"ca_file"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+func (c *BackendTLSConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("backend_tls: %w", err)
+		}
+		var current atomic.Pointer[tls.Certificate]
+		current.Store(&cert)
+		watchFile("backend client certificate "+c.CertFile, []string{c.CertFile, c.KeyFile}, func() error {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err == nil {
+				current.Store(&cert)
+			}
+			return err
+		})
+		tc.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return current.Load(), nil
+		}
+	}
+	// The CA bundle is read once; the transport offers no safe way to swap
+	// RootCAs under live connections, so changing ca_file needs a restart.
+	if c.CAFile != "" {
+		roots, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("backend_tls: %w", err)
+		}
+		tc.RootCAs = roots
+	}
+	return tc, nil
+}
+
+// newTransport builds the transport shared by every server in a pool, used
+// for both proxied traffic and health checks.
+func newTransport(pc PoolConfig) (*http.Transport, error) {
+	t, _, err := newPoolTransport(pc)
+	return t, err
+}
+
+// newPoolTransport is newTransport that also returns the DNS cache, if
+// the pool has one.
+func newPoolTransport(pc PoolConfig) (t *http.Transport, dc *dnsCache, err error) {
+	t = http.DefaultTransport.(*http.Transport).Clone()
+	d, err := pc.Dialer.dialer()
+	if err != nil {
+		return nil, nil, err
+	}
+	dial := dialFunc(d.DialContext)
+	if u, _ := pc.Dialer.proxyURL(); u != nil {
+		if dial, err = egressDialer(u, d); err != nil {
+			return nil, nil, err
+		}
+		// One proxy is enough; don't also use one from the environment.
+		t.Proxy = nil
+	}
+	t.DialContext = dial
+	if pc.Prewarm != nil && pc.Prewarm.Connections > max(t.MaxIdleConnsPerHost, http.DefaultMaxIdleConnsPerHost) {
+		// Otherwise the transport would close the extra warm connections.
+		t.MaxIdleConnsPerHost = pc.Prewarm.Connections
+	}
+	if pc.DNS != nil {
+		dc = newDNSCache(*pc.DNS, d)
+		dc.dial, dc.onChange = dial, t.CloseIdleConnections
+		t.DialContext = dc.DialContext
+	}
+	t.DialContext = trackConns(t.DialContext)
+	if pc.BackendTLS != nil {
+		tc, err := pc.BackendTLS.tlsConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		t.TLSClientConfig = tc
+	}
+	return t, dc, nil
+}
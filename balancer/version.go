@@ -0,0 +1,161 @@
+package balancer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+// VersionCheckConfig periodically asks every backend which version it
+// runs. The answer shows up in /stats and on the dashboard.
+type VersionCheckConfig struct {
+	// Path is fetched from each backend. Defaults to "/version".
+	Path string `json:"path"`
+	// Interval defaults to 1m.
+	Interval Duration `json:"interval"`
+	// Field is read from a JSON answer. Defaults to "version". Answers
+	// that aren't JSON are used as is (first line, trimmed).
+	Field string `json:"field"`
+	// AlertMixed raises an alert while healthy servers report different
+	// versions, e.g. a rollout that stalled halfway.
+	AlertMixed bool `json:"alert_mixed"`
+}
+
+func (c *VersionCheckConfig) validate() error {
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		return errors.New("version_check: path must start with /")
+	}
+	if c.Interval < 0 {
+		return errors.New("version_check: interval must not be negative")
+	}
+	return nil
+}
+
+// maxVersionLen keeps a backend that answers with a whole page from
+// filling /stats.
+const maxVersionLen = 64
+
+func (s *Server) Version() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.version
+}
+
+func (s *Server) setVersion(v string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.version = v
+}
+
+func (c *VersionCheckConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return time.Duration(c.Interval)
+	}
+	return time.Minute
+}
+
+func (b *Balancer) startVersionChecks(c VersionCheckConfig, beat func()) (stop func()) {
+	s := gocron.NewScheduler(time.Local)
+	s.Every(c.interval()).Do(func() {
+		defer recoverJob("version_check")
+		b.checkVersions(c)
+		beat()
+	})
+	s.StartAsync()
+	return s.Stop
+}
+
+// checkVersions runs one round. A server that doesn't answer keeps the
+// version it last reported.
+func (b *Balancer) checkVersions(c VersionCheckConfig) {
+	servers := b.pools.Servers()
+	for _, s := range servers {
+		v, err := s.fetchVersion(c)
+		if err != nil {
+			slog.Warn("⚠️ Version check failed", "server", s.Name, "err", err)
+			continue
+		}
+		if old := s.Version(); old != v {
+			if old != "" {
+				slog.Info("🏷️ Server reports a new version", "server", s.Name, "version", v, "was", old)
+			}
+			s.setVersion(v)
+		}
+	}
+	if c.AlertMixed {
+		b.alerts.evaluateVersions(servers)
+	}
+}
+
+func (s *Server) fetchVersion(c VersionCheckConfig) (string, error) {
+	path := c.Path
+	if path == "" {
+		path = "/version"
+	}
+	client := http.Client{Transport: s.ReverseProxy.Transport, Timeout: 2 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(s.URL, "/") + path)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	v := parseVersion(body, c.Field)
+	if v == "" {
+		return "", errors.New("empty version")
+	}
+	return v, nil
+}
+
+// parseVersion takes field from a JSON object, or else the first line of
+// the body.
+func parseVersion(body []byte, field string) string {
+	if field == "" {
+		field = "version"
+	}
+	var doc map[string]any
+	if json.Unmarshal(body, &doc) == nil {
+		switch v := doc[field].(type) {
+		case string:
+			return truncate(v, maxVersionLen)
+		case float64, bool:
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	line, _, _ := strings.Cut(string(body), "\n")
+	return truncate(strings.TrimSpace(line), maxVersionLen)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// runningVersions lists the distinct versions reported by healthy,
+// enabled servers.
+func runningVersions(servers []*Server) []string {
+	var versions []string
+	for _, s := range servers {
+		if v := s.Version(); v != "" && s.CheckHealth() && !s.IsDisabled() && !slices.Contains(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+	slices.Sort(versions)
+	return versions
+}
@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	watchdogRestarts = newCounterVec("lb_watchdog_restarts_total",
+		"Background jobs restarted by the watchdog after they stopped reporting in.", "job")
+	backgroundPanics = newCounterVec("lb_background_panics_total",
+		"Panics recovered in background jobs.", "job")
+)
+
+// watchdogInterval is how often the watchdog looks at its jobs.
+const watchdogInterval = 5 * time.Second
+
+// watchdog restarts background jobs (health checks, discovery, ...) that
+// stop reporting in, so a panic or a hung round doesn't silently turn
+// one off for good.
+type watchdog struct {
+	mu   sync.Mutex
+	jobs []*watchedJob
+}
+
+// A watchedJob calls beat every time it gets through a round. If it
+// doesn't for longer than stall, the watchdog stops and restarts it.
+type watchedJob struct {
+	name  string
+	stall func() time.Duration
+	start func(beat func()) (stop func())
+
+	stop     func()
+	gen      atomic.Int64
+	lastBeat atomic.Int64 // UnixNano
+}
+
+// watch starts a job under the watchdog. start launches it and returns
+// how to stop it.
+func (wd *watchdog) watch(name string, stall func() time.Duration, start func(beat func()) (stop func())) {
+	j := &watchedJob{name: name, stall: stall, start: start}
+	j.launch()
+	wd.mu.Lock()
+	wd.jobs = append(wd.jobs, j)
+	wd.mu.Unlock()
+}
+
+func (j *watchedJob) launch() {
+	gen := j.gen.Add(1)
+	j.lastBeat.Store(time.Now().UnixNano())
+	j.stop = j.start(func() {
+		// A stuck run that comes back after its restart doesn't count.
+		if j.gen.Load() == gen {
+			j.lastBeat.Store(time.Now().UnixNano())
+		}
+	})
+}
+
+// check restarts the jobs that have been quiet for too long.
+func (wd *watchdog) check(now time.Time) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	for _, j := range wd.jobs {
+		quiet := now.Sub(time.Unix(0, j.lastBeat.Load()))
+		if quiet <= j.stall() {
+			continue
+		}
+		slog.Warn("🐕 Watchdog restarting a job that stopped reporting in", "job", j.name, "quiet", quiet.Round(time.Second))
+		watchdogRestarts.Inc(j.name)
+		if j.stop != nil {
+			j.stop()
+		}
+		j.launch()
+	}
+}
+
+// run checks the jobs every interval until done is closed.
+func (wd *watchdog) run(interval time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			wd.check(now)
+		}
+	}
+}
+
+// stopAll stops every job and forgets them, so check restarts none.
+func (wd *watchdog) stopAll() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	for _, j := range wd.jobs {
+		if j.stop != nil {
+			j.stop()
+		}
+	}
+	wd.jobs = nil
+}
+
+// recoverJob logs and counts a panic in a background job. Defer it at the
+// top of every round; the job then misses its beat and the watchdog
+// takes over.
+func recoverJob(name string) {
+	if r := recover(); r != nil {
+		backgroundPanics.Inc(name)
+		slog.Error("💥 Background job panicked", "job", name, "panic", r, "stack", string(debug.Stack()))
+	}
+}
+
+// every returns a stall timeout of n intervals.
+func every(n int, interval time.Duration) func() time.Duration {
+	return func() time.Duration { return time.Duration(n) * interval }
+}
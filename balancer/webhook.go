@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Format is "generic" (the alert as JSON, default) or "slack"
+	// (an incoming-webhook {"text": ...} payload).
+	Format     string            `json:"format"`
+	Headers    map[string]string `json:"headers"`
+	Severities severityFilter    `json:"severities"`
+}
+
+type webhookNotifier struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+func newWebhookNotifier(c WebhookConfig) (*webhookNotifier, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	switch c.Format {
+	case "", "generic", "slack":
+	default:
+		return nil, fmt.Errorf("unknown format %q", c.Format)
+	}
+	return &webhookNotifier{cfg: c, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+var slackEmoji = map[string]string{
+	severityInfo:     ":white_check_mark:",
+	severityWarning:  ":warning:",
+	severityCritical: ":rotating_light:",
+}
+
+func (n *webhookNotifier) Notify(al Alert) error {
+	var payload any = al
+	if n.cfg.Format == "slack" {
+		payload = map[string]string{"text": fmt.Sprintf("%s *%s* %s", slackEmoji[al.Severity], al.Severity, al.Message)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", n.cfg.URL, resp.Status)
+	}
+	return nil
+}
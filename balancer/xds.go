@@ -0,0 +1,230 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/loadbalancer/pluginapi"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	clusterTypeURL  = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+	endpointTypeURL = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+)
+
+// XDSConfig makes the balancer an xDS client (experimental). It follows
+// one cluster over ADS and keeps the pool's servers in line with the
+// cluster's endpoints.
+type XDSConfig struct {
+	// Server is the control plane's ADS address (host:port, plaintext).
+	Server string `json:"server"`
+	NodeID string `json:"node_id"`
+	// Cluster is the CDS cluster whose endpoints form the pool.
+	Cluster string `json:"cluster"`
+	// Scheme for the endpoint URLs. Defaults to "http".
+	Scheme string `json:"scheme"`
+}
+
+func (c *XDSConfig) validate() error {
+	if c.Server == "" || c.Cluster == "" {
+		return fmt.Errorf("xds: server and cluster are required")
+	}
+	if c.Scheme != "" && c.Scheme != "http" && c.Scheme != "https" {
+		return fmt.Errorf("xds: unknown scheme %q", c.Scheme)
+	}
+	return nil
+}
+
+type xdsClient struct {
+	cfg XDSConfig
+	ts  *targetSync
+}
+
+func (b *Balancer) startXDS(c XDSConfig) {
+	xc := &xdsClient{cfg: c, ts: newTargetSync(b.pools, "xds")}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-b.done
+		cancel()
+	}()
+	go func() {
+		backoff := time.Second
+		for {
+			start := time.Now()
+			err := xc.run(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("⚠️ xDS stream ended", "server", c.Server, "err", err)
+			if time.Since(start) > time.Minute {
+				backoff = time.Second
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, 30*time.Second)
+		}
+	}()
+}
+
+// run holds one ADS stream: CDS for the cluster, then EDS for its
+// endpoints. Servers are kept as they are while the stream is down.
+func (xc *xdsClient) run(ctx context.Context) error {
+	conn, err := grpc.NewClient(xc.cfg.Server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stream, err := discoveryv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	if err != nil {
+		return err
+	}
+	node := &corev3.Node{Id: xc.cfg.NodeID, Cluster: "loadbalancer"}
+	send := func(typeURL string, names []string, resp *discoveryv3.DiscoveryResponse, nack error) error {
+		req := &discoveryv3.DiscoveryRequest{Node: node, TypeUrl: typeURL, ResourceNames: names}
+		if resp != nil {
+			req.ResponseNonce = resp.Nonce
+			req.VersionInfo = resp.VersionInfo
+		}
+		if nack != nil {
+			req.ErrorDetail = &status.Status{Message: nack.Error()}
+			req.VersionInfo = "" // keep whatever version we had
+		}
+		return stream.Send(req)
+	}
+	if err := send(clusterTypeURL, []string{xc.cfg.Cluster}, nil, nil); err != nil {
+		return err
+	}
+
+	edsName := ""
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch resp.TypeUrl {
+		case clusterTypeURL:
+			name, inline, bad := xc.cluster(resp)
+			if err := send(clusterTypeURL, []string{xc.cfg.Cluster}, resp, bad); err != nil {
+				return err
+			}
+			if bad != nil {
+				slog.Warn("⚠️ xDS: rejected CDS update", "err", bad)
+				continue
+			}
+			if inline != nil {
+				xc.ts.apply(xc.targets(inline))
+			} else if name != edsName {
+				edsName = name
+				if err := send(endpointTypeURL, []string{edsName}, nil, nil); err != nil {
+					return err
+				}
+			}
+		case endpointTypeURL:
+			cla, bad := xc.assignment(resp, edsName)
+			if err := send(endpointTypeURL, []string{edsName}, resp, bad); err != nil {
+				return err
+			}
+			if bad != nil {
+				slog.Warn("⚠️ xDS: rejected EDS update", "err", bad)
+				continue
+			}
+			if cla != nil {
+				xc.ts.apply(xc.targets(cla))
+			}
+		}
+	}
+}
+
+// cluster finds our cluster in a CDS response. It returns the EDS service
+// name to subscribe to, or the inline assignment of a static cluster.
+func (xc *xdsClient) cluster(resp *discoveryv3.DiscoveryResponse) (string, *endpointv3.ClusterLoadAssignment, error) {
+	for _, res := range resp.Resources {
+		var c clusterv3.Cluster
+		if err := res.UnmarshalTo(&c); err != nil {
+			return "", nil, err
+		}
+		if c.Name != xc.cfg.Cluster {
+			continue
+		}
+		if c.GetType() == clusterv3.Cluster_EDS {
+			if name := c.GetEdsClusterConfig().GetServiceName(); name != "" {
+				return name, nil, nil
+			}
+			return c.Name, nil, nil
+		}
+		if c.LoadAssignment == nil {
+			return "", nil, fmt.Errorf("cluster %s has no endpoints", c.Name)
+		}
+		return "", c.LoadAssignment, nil
+	}
+	return "", nil, fmt.Errorf("cluster %s not in response", xc.cfg.Cluster)
+}
+
+func (xc *xdsClient) assignment(resp *discoveryv3.DiscoveryResponse, name string) (*endpointv3.ClusterLoadAssignment, error) {
+	for _, res := range resp.Resources {
+		var cla endpointv3.ClusterLoadAssignment
+		if err := res.UnmarshalTo(&cla); err != nil {
+			return nil, err
+		}
+		if cla.ClusterName == name {
+			return &cla, nil
+		}
+	}
+	return nil, nil
+}
+
+// targets turns an assignment into servers. Endpoints the control plane
+// marks unhealthy or draining are left out; locality becomes labels.
+func (xc *xdsClient) targets(cla *endpointv3.ClusterLoadAssignment) []pluginapi.Target {
+	scheme := xc.cfg.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	var out []pluginapi.Target
+	for _, loc := range cla.Endpoints {
+		labels := map[string]string{}
+		if l := loc.GetLocality(); l != nil {
+			for k, v := range map[string]string{"region": l.Region, "zone": l.Zone, "sub_zone": l.SubZone} {
+				if v != "" {
+					labels[k] = v
+				}
+			}
+		}
+		for _, ep := range loc.LbEndpoints {
+			switch ep.GetHealthStatus() {
+			case corev3.HealthStatus_UNHEALTHY, corev3.HealthStatus_DRAINING, corev3.HealthStatus_TIMEOUT:
+				continue
+			}
+			sa := ep.GetEndpoint().GetAddress().GetSocketAddress()
+			if sa == nil {
+				continue
+			}
+			addr := net.JoinHostPort(sa.Address, strconv.Itoa(int(sa.GetPortValue())))
+			weight := 1
+			if w := ep.GetLoadBalancingWeight(); w != nil && w.Value > 0 {
+				weight = int(w.Value)
+			}
+			out = append(out, pluginapi.Target{
+				Name:   xc.cfg.Cluster + "/" + addr,
+				URL:    scheme + "://" + addr,
+				Weight: weight,
+				Labels: labels,
+			})
+		}
+	}
+	return out
+}
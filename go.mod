@@ -2,10 +2,27 @@ module github.com/loadbalancer
 
 go 1.25.1
 
-require github.com/go-co-op/gocron v1.37.0
+require (
+	github.com/envoyproxy/go-control-plane/envoy v1.39.0
+	github.com/expr-lang/expr v1.17.8
+	github.com/go-co-op/gocron v1.37.0
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.57.0
+	golang.org/x/sys v0.47.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.12
+)
 
 require (
-	github.com/google/uuid v1.4.0 // indirect
-	github.com/robfig/cron/v3 v3.0.1 // indirect
+	cel.dev/expr v0.25.2 // indirect
+	github.com/cncf/xds/go v0.0.0-20260202195803-dba9d589def2 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.3.3 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
+	github.com/stretchr/testify v1.11.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20260706201446-f0a921348800 // indirect
 )
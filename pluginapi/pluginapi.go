@@ -0,0 +1,70 @@
+// Package pluginapi is the stable interface between the load balancer and
+// its plugins. It only depends on the standard library, so a plugin built
+// against one release keeps compiling against the next.
+//
+// A plugin is a Go plugin (go build -buildmode=plugin) exporting
+//
+//	func New(config json.RawMessage) (pluginapi.Plugin, error)
+//
+// The returned value implements Plugin and any of Middleware, Strategy,
+// Discovery and HealthChecker.
+package pluginapi
+
+import (
+	"context"
+	"net/http"
+)
+
+// Plugin is implemented by every plugin.
+type Plugin interface {
+	Name() string
+}
+
+// Middleware wraps the proxy handler. Plugins are applied in config
+// order, the first one outermost.
+type Middleware interface {
+	Plugin
+	Wrap(next http.Handler) http.Handler
+}
+
+// Strategy ranks backends; the one with the lowest Load gets the next
+// request. It is selectable as "strategy" under the plugin's Name. Load is
+// called with the pool locked and must be fast.
+type Strategy interface {
+	Plugin
+	Load(b Backend) float64
+}
+
+// Discovery reports the backends that should exist. Backends it stops
+// reporting are removed again.
+type Discovery interface {
+	Plugin
+	Discover(ctx context.Context) ([]Target, error)
+}
+
+// HealthChecker replaces the HTTP health probe for every backend.
+type HealthChecker interface {
+	Plugin
+	Check(ctx context.Context, b Backend) (healthy bool, reason string)
+}
+
+// Backend is a snapshot of one backend.
+type Backend struct {
+	Name              string
+	URL               string
+	Weight            int
+	Labels            map[string]string
+	ActiveConnections int
+	// LatencyEWMA is the smoothed request duration in milliseconds.
+	LatencyEWMA float64
+}
+
+// Target is a backend found by a Discovery plugin.
+type Target struct {
+	Name   string
+	URL    string
+	Weight int
+	Labels map[string]string
+	// Pool is the pool the target joins. Empty means the default pool.
+	Pool string
+}